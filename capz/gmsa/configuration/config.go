@@ -0,0 +1,528 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// config is the effective configuration of the configurator. Settings are
+// taken from flags, then the --config file, then environment variables, then
+// the defaults.
+type config struct {
+	// Name and Namespace identify the workload cluster on the management cluster.
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Kubeconfig is the kubeconfig of the management cluster.
+	Kubeconfig string `json:"kubeconfig"`
+	// ProgressFile records the completed steps.
+	ProgressFile string `json:"progressFile"`
+
+	// SpecSource is where the domain publishes the credential spec, one of
+	// specSources.
+	SpecSource string `json:"specSource"`
+	// KeyVaultURL is the Key Vault the domain stores the credential spec in.
+	KeyVaultURL string `json:"keyVaultURL"`
+	// GmsaID is the suffix of the credential spec secret of this run.
+	GmsaID string `json:"gmsaID"`
+	// SpecSecretName is the Key Vault secret with the credential spec.
+	SpecSecretName string `json:"specSecretName"`
+	// SpecKubernetesSecret is the namespace/name of the management cluster
+	// Secret with the credential spec, under SpecKubernetesSecretKey.
+	SpecKubernetesSecret    string `json:"specKubernetesSecret"`
+	SpecKubernetesSecretKey string `json:"specKubernetesSecretKey"`
+	// SpecFile is a local file with the credential spec.
+	SpecFile string `json:"specFile"`
+	// SpecURL is an HTTP endpoint serving the credential spec.
+	SpecURL string `json:"specURL"`
+	// SpecTimeout is how long to wait for the domain to publish the spec.
+	SpecTimeout duration `json:"specTimeout"`
+	// SpecPollInterval is how often the source is read while waiting.
+	SpecPollInterval duration `json:"specPollInterval"`
+
+	// ApplyCredSpec applies the GMSACredentialSpec and its RBAC in the
+	// workload cluster. The CRD must be installed.
+	ApplyCredSpec bool `json:"applyCredSpec"`
+	// GmsaServiceAccounts are the namespace/name service accounts allowed to
+	// use the GMSACredentialSpec.
+	GmsaServiceAccounts []string `json:"gmsaServiceAccounts"`
+	// CheckGmsaWebhook verifies that the gMSA admission webhook is installed.
+	CheckGmsaWebhook bool `json:"checkGmsaWebhook"`
+
+	// Domain is the DNS domain of the gMSA domain controller.
+	Domain string `json:"domain"`
+	// DNSIP is the DNS server of the domain.
+	DNSIP string `json:"dnsIP"`
+	// SpecPath is where the credential spec is written on the test node.
+	SpecPath string `json:"specPath"`
+	// NodeLabel is the key=value label of the Windows node running the gMSA tests.
+	NodeLabel string `json:"nodeLabel"`
+
+	// NodeExecutor is how commands are run on the nodes, one of
+	// nodeExecutors.
+	NodeExecutor string `json:"nodeExecutor"`
+	// HostProcessImage and HostProcessNamespace are the image and the
+	// workload cluster namespace of the pods of the hostprocess executor.
+	HostProcessImage     string `json:"hostProcessImage"`
+	HostProcessNamespace string `json:"hostProcessNamespace"`
+
+	// SSHUser, SSHKeyFile and SSHPort are used to reach the nodes through
+	// the control plane endpoint.
+	SSHUser    string `json:"sshUser"`
+	SSHKeyFile string `json:"sshKeyFile"`
+	SSHPort    string `json:"sshPort"`
+	// SSHHostKeyMode is how the host keys of the nodes are verified, one of
+	// hostKeyModes.
+	SSHHostKeyMode string `json:"sshHostKeyMode"`
+	// KnownHostsFile is the known_hosts file of the known-hosts and tofu modes.
+	KnownHostsFile string `json:"knownHostsFile"`
+	// HostKeyAnnotation is the node annotation with the SHA256 fingerprints
+	// of the node host keys, in the cluster mode.
+	HostKeyAnnotation string `json:"hostKeyAnnotation"`
+	// HostKeySecret is the namespace/name of a Secret in the workload cluster
+	// mapping hosts to their fingerprints, in the cluster mode.
+	HostKeySecret string `json:"hostKeySecret"`
+	// SSHKeepalive is the interval of keepalive requests on the node
+	// connections. Zero disables them.
+	SSHKeepalive duration `json:"sshKeepalive"`
+	// DNSConcurrency is the number of nodes whose DNS is updated at once.
+	DNSConcurrency int `json:"dnsConcurrency"`
+	// DNSNodeTimeout bounds each attempt to update the DNS of a node.
+	DNSNodeTimeout duration `json:"dnsNodeTimeout"`
+	// DNSRetries is the number of retries of a node whose update failed.
+	DNSRetries int `json:"dnsRetries"`
+	// DNSFailurePolicy is what a failed node does to the others, one of
+	// dnsFailurePolicies.
+	DNSFailurePolicy string `json:"dnsFailurePolicy"`
+
+	// OutputFile collects the output of the commands run on the nodes.
+	OutputFile string `json:"outputFile"`
+}
+
+// duration is a time.Duration written as a string, such as "15m", in the
+// config file.
+type duration time.Duration
+
+func (d duration) String() string { return time.Duration(d).String() }
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Errorf("duration must be a string such as \"10s\", got %s", b)
+	}
+	return d.Set(s)
+}
+
+// stringValue is a flag.Value setting a config string.
+type stringValue string
+
+func (s *stringValue) String() string     { return string(*s) }
+func (s *stringValue) Set(v string) error { *s = stringValue(v); return nil }
+
+// intValue is a flag.Value setting a config integer.
+type intValue int
+
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+
+func (i *intValue) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.Errorf("%q is not an integer", v)
+	}
+	*i = intValue(n)
+	return nil
+}
+
+// boolValue is a flag.Value setting a config boolean.
+type boolValue bool
+
+func (b *boolValue) String() string   { return strconv.FormatBool(bool(*b)) }
+func (b *boolValue) IsBoolFlag() bool { return true }
+
+func (b *boolValue) Set(v string) error {
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.Errorf("%q is not a boolean", v)
+	}
+	*b = boolValue(parsed)
+	return nil
+}
+
+// listValue is a flag.Value setting a config list from comma separated values.
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// configFlag ties a config setting to its flag.
+type configFlag struct {
+	name  string
+	usage string
+	value func(*config) flag.Value
+}
+
+func str(p func(*config) *string) func(*config) flag.Value {
+	return func(c *config) flag.Value { return (*stringValue)(p(c)) }
+}
+
+func integer(p func(*config) *int) func(*config) flag.Value {
+	return func(c *config) flag.Value { return (*intValue)(p(c)) }
+}
+
+var configFlags = []configFlag{
+	{"name", "Name of the workload cluster to configure (required)", str(func(c *config) *string { return &c.Name })},
+	{"namespace", "Namespace of the workload cluster on the management cluster (default the cluster name)", str(func(c *config) *string { return &c.Namespace })},
+	{"kubeconfig", "The kubeconfig for the management cluster ($KUBECONFIG)", str(func(c *config) *string { return &c.Kubeconfig })},
+	{"progress-file", "File recording the completed steps, so a rerun continues where it stopped (default gmsa-progress-<namespace>-<name>.json)", str(func(c *config) *string { return &c.ProgressFile })},
+	{"spec-source", "Where the domain publishes the credential spec: keyvault, secret (a management cluster Secret), file or http", str(func(c *config) *string { return &c.SpecSource })},
+	{"keyvault-url", "Key Vault the domain stores the credential spec in ($GMSA_KEYVAULT_URL)", str(func(c *config) *string { return &c.KeyVaultURL })},
+	{"gmsa-id", "ID of the domain of this run ($GMSA_ID)", str(func(c *config) *string { return &c.GmsaID })},
+	{"spec-secret-name", "Key Vault secret with the credential spec (default gmsa-cred-spec-gmsa-e2e-<gmsa-id>)", str(func(c *config) *string { return &c.SpecSecretName })},
+	{"spec-kubernetes-secret", "namespace/name of the management cluster Secret with the credential spec, with --spec-source=secret", str(func(c *config) *string { return &c.SpecKubernetesSecret })},
+	{"spec-kubernetes-secret-key", "Key of the credential spec in --spec-kubernetes-secret", str(func(c *config) *string { return &c.SpecKubernetesSecretKey })},
+	{"spec-file", "Local file with the credential spec, with --spec-source=file", str(func(c *config) *string { return &c.SpecFile })},
+	{"spec-url", "HTTP endpoint serving the credential spec, with --spec-source=http; 404 means not published yet", str(func(c *config) *string { return &c.SpecURL })},
+	{"spec-timeout", "How long to wait for the domain to publish the credential spec", func(c *config) flag.Value { return &c.SpecTimeout }},
+	{"spec-poll-interval", "How often the credential spec source is read while waiting", func(c *config) flag.Value { return &c.SpecPollInterval }},
+	{"apply-credspec", "Apply the GMSACredentialSpec and the RBAC to use it in the workload cluster, requires the GMSACredentialSpec CRD", func(c *config) flag.Value { return (*boolValue)(&c.ApplyCredSpec) }},
+	{"gmsa-service-accounts", "Comma separated namespace/name service accounts allowed to use the GMSACredentialSpec", func(c *config) flag.Value { return (*listValue)(&c.GmsaServiceAccounts) }},
+	{"check-gmsa-webhook", "Check that the gMSA admission webhook is installed before creating the GMSACredentialSpec", func(c *config) flag.Value { return (*boolValue)(&c.CheckGmsaWebhook) }},
+	{"domain", "DNS domain of the gMSA domain controller", str(func(c *config) *string { return &c.Domain })},
+	{"dns-ip", "IPv4 address of the domain DNS server ($GMSA_DNS_IP)", str(func(c *config) *string { return &c.DNSIP })},
+	{"spec-path", "Path the credential spec is written to on the test node", str(func(c *config) *string { return &c.SpecPath })},
+	{"node-label", "key=value label of the Windows node running the gMSA tests", str(func(c *config) *string { return &c.NodeLabel })},
+	{"node-executor", "How commands are run on the Windows nodes: ssh through the control plane endpoint, or hostprocess in a HostProcess pod", str(func(c *config) *string { return &c.NodeExecutor })},
+	{"hostprocess-image", "Image of the HostProcess pods, with --node-executor=hostprocess", str(func(c *config) *string { return &c.HostProcessImage })},
+	{"hostprocess-namespace", "Workload cluster namespace of the HostProcess pods, with --node-executor=hostprocess", str(func(c *config) *string { return &c.HostProcessNamespace })},
+	{"ssh-user", "SSH user on the control plane and Windows nodes", str(func(c *config) *string { return &c.SSHUser })},
+	{"ssh-key-file", "SSH private key file ($AZURE_SSH_KEY, or $AZURE_SSH_PUBLIC_KEY_FILE without .pub)", str(func(c *config) *string { return &c.SSHKeyFile })},
+	{"ssh-port", "SSH port of the control plane endpoint and Windows nodes", str(func(c *config) *string { return &c.SSHPort })},
+	{"ssh-host-key-mode", "How SSH host keys are verified: known-hosts, cluster (fingerprints on node annotations or in a Secret), tofu (record unknown keys in the known hosts file) or insecure", str(func(c *config) *string { return &c.SSHHostKeyMode })},
+	{"known-hosts-file", "known_hosts file of the known-hosts and tofu modes (default ~/.ssh/known_hosts)", str(func(c *config) *string { return &c.KnownHostsFile })},
+	{"host-key-annotation", "Node annotation with the SHA256 fingerprints of the node host keys, in the cluster mode", str(func(c *config) *string { return &c.HostKeyAnnotation })},
+	{"host-key-secret", "namespace/name of a workload cluster Secret mapping hosts to their SHA256 fingerprints, in the cluster mode", str(func(c *config) *string { return &c.HostKeySecret })},
+	{"ssh-keepalive", "Interval of keepalive requests on SSH connections, a connection not answering within it is dialed again (0 disables them)", func(c *config) flag.Value { return &c.SSHKeepalive }},
+	{"dns-concurrency", "Number of Windows nodes whose DNS is updated at once", integer(func(c *config) *int { return &c.DNSConcurrency })},
+	{"dns-node-timeout", "Timeout of each attempt to update the DNS of a node", func(c *config) flag.Value { return &c.DNSNodeTimeout }},
+	{"dns-retries", "Number of retries of a node whose DNS update failed", integer(func(c *config) *int { return &c.DNSRetries })},
+	{"dns-failure-policy", "fail-fast stops at the first node whose DNS update failed, best-effort updates all nodes, reports the failures and updates them again on a rerun", str(func(c *config) *string { return &c.DNSFailurePolicy })},
+	{"output-file", "File the output of node commands is written to", str(func(c *config) *string { return &c.OutputFile })},
+}
+
+func defaultConfig() *config {
+	return &config{
+		SpecSource:              specSourceKeyVault,
+		SpecKubernetesSecretKey: "credspec",
+		SpecTimeout:             duration(15 * time.Minute),
+		SpecPollInterval:        duration(10 * time.Second),
+		GmsaServiceAccounts:     []string{"default/default"},
+		Domain:                  "k8sgmsa.lan",
+		SpecPath:                "c:/gmsa/gmsa-cred-spec-gmsa-e2e.yml",
+		NodeLabel:               "agentpool=windowsgmsa",
+		NodeExecutor:            nodeExecutorSSH,
+		HostProcessImage:        "mcr.microsoft.com/oss/kubernetes/windows-host-process-containers-base-image:v1.0.0",
+		HostProcessNamespace:    "kube-system",
+		SSHUser:                 "capi",
+		SSHKeyFile:              ".sshkey",
+		SSHPort:                 "22",
+		SSHHostKeyMode:          hostKeyModeKnownHosts,
+		HostKeyAnnotation:       "windows-testing.k8s.io/ssh-host-key-fingerprints",
+		SSHKeepalive:            duration(30 * time.Second),
+		DNSConcurrency:          4,
+		DNSNodeTimeout:          duration(2 * time.Minute),
+		DNSRetries:              2,
+		DNSFailurePolicy:        dnsFailFast,
+		OutputFile:              "gmsa-spec-writer-output.txt",
+	}
+}
+
+// applyEnv sets the settings that have an environment variable.
+func (c *config) applyEnv(getenv func(string) string) {
+	set := func(p *string, env string) {
+		if v := getenv(env); v != "" {
+			*p = v
+		}
+	}
+	set(&c.Kubeconfig, "KUBECONFIG")
+	set(&c.KeyVaultURL, "GMSA_KEYVAULT_URL")
+	set(&c.GmsaID, "GMSA_ID")
+	set(&c.DNSIP, "GMSA_DNS_IP")
+	if keyfile := getenv("AZURE_SSH_PUBLIC_KEY_FILE"); keyfile != "" {
+		c.SSHKeyFile = strings.TrimSuffix(keyfile, ".pub")
+	}
+	set(&c.SSHKeyFile, "AZURE_SSH_KEY")
+}
+
+// options are the command line settings that are not configuration.
+type options struct {
+	configFile  string
+	printConfig bool
+	reset       bool
+}
+
+// loadConfig builds the configuration from the command line, the config file
+// and the environment.
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (*config, options, error) {
+	c := defaultConfig()
+	if home, err := os.UserHomeDir(); err == nil {
+		c.Kubeconfig = path.Join(home, ".kube", "config")
+		c.KnownHostsFile = path.Join(home, ".ssh", "known_hosts")
+	}
+	c.applyEnv(getenv)
+
+	// using a custom FlagSet here due to the dependency in controller-runtime that is already using this flag
+	// https://github.com/kubernetes-sigs/controller-runtime/blob/c7a98aa706379c4e5c79ea675c7f333192677971/pkg/client/config/config.go#L37-L41
+	fs := flag.NewFlagSet("configure", flag.ContinueOnError)
+	fs.SetOutput(output)
+	var opts options
+	fs.StringVar(&opts.configFile, "config", "", "YAML file with the settings; flags take precedence over it and it takes precedence over the environment")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Print the effective configuration and exit")
+	fs.BoolVar(&opts.reset, "reset", false, "Ignore the progress record and run all steps")
+	defaults := defaultConfig()
+	flagged := &config{}
+	for _, f := range configFlags {
+		fs.Var(f.value(flagged), f.name, f.usage)
+		if def := f.value(defaults).String(); def != "" && def != "0s" {
+			fs.Lookup(f.name).DefValue = def
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	if fs.NArg() > 0 {
+		return nil, opts, errors.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if opts.configFile != "" {
+		data, err := os.ReadFile(opts.configFile)
+		if err != nil {
+			return nil, opts, errors.Wrap(err, "reading config file")
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, opts, errors.Wrapf(err, "parsing config file %s", opts.configFile)
+		}
+	}
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range configFlags {
+			if f.name == fl.Name && err == nil {
+				err = f.value(c).Set(fl.Value.String())
+			}
+		}
+	})
+	if err != nil {
+		return nil, opts, err
+	}
+
+	c.complete()
+	return c, opts, c.validate()
+}
+
+// complete fills in the settings derived from others.
+func (c *config) complete() {
+	// use the cluster name as the namespace which is default in e2e tests
+	if c.Namespace == "" {
+		c.Namespace = c.Name
+	}
+	if c.ProgressFile == "" && c.Name != "" {
+		c.ProgressFile = fmt.Sprintf("gmsa-progress-%s-%s.json", c.Namespace, c.Name)
+	}
+	if c.SpecSecretName == "" && c.GmsaID != "" {
+		c.SpecSecretName = "gmsa-cred-spec-gmsa-e2e-" + c.GmsaID
+	}
+}
+
+func (c *config) validate() error {
+	var errs []error
+	for _, f := range []struct{ name, value string }{
+		{"name", c.Name},
+		{"dns-ip", c.DNSIP},
+	} {
+		if f.value == "" {
+			errs = append(errs, errors.Errorf("--%s is required", f.name))
+		}
+	}
+	errs = append(errs, c.validateSpecSource()...)
+	if c.DNSIP != "" {
+		if ip := net.ParseIP(c.DNSIP); ip == nil || ip.To4() == nil {
+			errs = append(errs, errors.Errorf("--dns-ip %q must be an IPv4 address", c.DNSIP))
+		}
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(c.Domain) {
+		errs = append(errs, errors.Errorf("--domain %q: %s", c.Domain, msg))
+	}
+	if key, value, ok := strings.Cut(c.NodeLabel, "="); !ok {
+		errs = append(errs, errors.Errorf("--node-label %q must be key=value", c.NodeLabel))
+	} else {
+		for _, msg := range append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...) {
+			errs = append(errs, errors.Errorf("--node-label %q: %s", c.NodeLabel, msg))
+		}
+	}
+	if c.SpecPath == "" || strings.ContainsAny(c.SpecPath, "'\"") {
+		errs = append(errs, errors.Errorf("--spec-path %q must be a path without quotes", c.SpecPath))
+	}
+	switch c.NodeExecutor {
+	case nodeExecutorSSH:
+		errs = append(errs, c.validateSSH()...)
+	case nodeExecutorHostProcess:
+		if c.HostProcessImage == "" {
+			errs = append(errs, errors.New("--hostprocess-image is required with --node-executor=hostprocess"))
+		}
+		for _, msg := range validation.IsDNS1123Label(c.HostProcessNamespace) {
+			errs = append(errs, errors.Errorf("--hostprocess-namespace %q: %s", c.HostProcessNamespace, msg))
+		}
+	default:
+		errs = append(errs, errors.Errorf("--node-executor %q must be one of %s", c.NodeExecutor, strings.Join(nodeExecutors, ", ")))
+	}
+	for _, sa := range c.GmsaServiceAccounts {
+		namespace, name, ok := strings.Cut(sa, "/")
+		if !ok || len(validation.IsDNS1123Label(namespace)) > 0 || len(validation.IsDNS1123Subdomain(name)) > 0 {
+			errs = append(errs, errors.Errorf("--gmsa-service-accounts entry %q must be namespace/name", sa))
+		}
+	}
+	if c.DNSConcurrency < 1 {
+		errs = append(errs, errors.Errorf("--dns-concurrency %d must be at least 1", c.DNSConcurrency))
+	}
+	if c.DNSRetries < 0 {
+		errs = append(errs, errors.Errorf("--dns-retries %d must not be negative", c.DNSRetries))
+	}
+	if c.DNSNodeTimeout <= 0 {
+		errs = append(errs, errors.New("--dns-node-timeout must be positive"))
+	}
+	if !slices.Contains(dnsFailurePolicies, c.DNSFailurePolicy) {
+		errs = append(errs, errors.Errorf("--dns-failure-policy %q must be one of %s", c.DNSFailurePolicy, strings.Join(dnsFailurePolicies, ", ")))
+	}
+	if c.SpecTimeout <= 0 || c.SpecPollInterval <= 0 {
+		errs = append(errs, errors.New("--spec-timeout and --spec-poll-interval must be positive"))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateSSH checks the settings of the ssh node executor.
+func (c *config) validateSSH() []error {
+	var errs []error
+	if port, err := strconv.Atoi(c.SSHPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, errors.Errorf("--ssh-port %q must be a port number", c.SSHPort))
+	}
+	if c.SSHUser == "" {
+		errs = append(errs, errors.New("--ssh-user is required"))
+	}
+	if !slices.Contains(hostKeyModes, c.SSHHostKeyMode) {
+		errs = append(errs, errors.Errorf("--ssh-host-key-mode %q must be one of %s", c.SSHHostKeyMode, strings.Join(hostKeyModes, ", ")))
+	}
+	if (c.SSHHostKeyMode == hostKeyModeKnownHosts || c.SSHHostKeyMode == hostKeyModeTOFU) && c.KnownHostsFile == "" {
+		errs = append(errs, errors.Errorf("--known-hosts-file is required with --ssh-host-key-mode=%s", c.SSHHostKeyMode))
+	}
+	if c.HostKeySecret != "" {
+		if namespace, name, ok := strings.Cut(c.HostKeySecret, "/"); !ok || namespace == "" || name == "" {
+			errs = append(errs, errors.Errorf("--host-key-secret %q must be namespace/name", c.HostKeySecret))
+		}
+	}
+	if c.SSHKeepalive < 0 {
+		errs = append(errs, errors.New("--ssh-keepalive must not be negative"))
+	}
+	return errs
+}
+
+// validateSpecSource checks the settings of the credential spec source.
+func (c *config) validateSpecSource() []error {
+	var errs []error
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, errors.Errorf("--%s is required with --spec-source=%s", name, c.SpecSource))
+		}
+	}
+	switch c.SpecSource {
+	case specSourceKeyVault:
+		required("keyvault-url", c.KeyVaultURL)
+		if c.SpecSecretName == "" {
+			errs = append(errs, errors.New("--gmsa-id or --spec-secret-name is required"))
+		}
+		if c.KeyVaultURL != "" {
+			if u, err := url.Parse(c.KeyVaultURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				errs = append(errs, errors.Errorf("--keyvault-url %q must be an https URL", c.KeyVaultURL))
+			}
+		}
+	case specSourceSecret:
+		if namespace, name, ok := strings.Cut(c.SpecKubernetesSecret, "/"); !ok || namespace == "" || name == "" {
+			errs = append(errs, errors.Errorf("--spec-kubernetes-secret %q must be namespace/name", c.SpecKubernetesSecret))
+		}
+		required("spec-kubernetes-secret-key", c.SpecKubernetesSecretKey)
+	case specSourceFile:
+		required("spec-file", c.SpecFile)
+	case specSourceHTTP:
+		required("spec-url", c.SpecURL)
+		if c.SpecURL != "" {
+			if u, err := url.Parse(c.SpecURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, errors.Errorf("--spec-url %q must be an http or https URL", c.SpecURL))
+			}
+		}
+	default:
+		errs = append(errs, errors.Errorf("--spec-source %q must be one of %s", c.SpecSource, strings.Join(specSources, ", ")))
+	}
+	return errs
+}
+
+// nodeLabel returns the key and value of the gMSA test node label.
+func (c *config) nodeLabel() (string, string) {
+	key, value, _ := strings.Cut(c.NodeLabel, "=")
+	return key, value
+}
+
+// dnsUpdateOptions returns the options of the node DNS updates.
+func (c *config) dnsUpdateOptions() dnsUpdateOptions {
+	return dnsUpdateOptions{
+		concurrency: c.DNSConcurrency,
+		timeout:     time.Duration(c.DNSNodeTimeout),
+		retries:     c.DNSRetries,
+		retryDelay:  10 * time.Second,
+		failFast:    c.DNSFailurePolicy == dnsFailFast,
+	}
+}
+
+// print writes the configuration as YAML.
+func (c *config) print(w io.Writer) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
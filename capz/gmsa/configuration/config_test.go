@@ -0,0 +1,222 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	env := testEnv(map[string]string{
+		"GMSA_KEYVAULT_URL":         "https://env.vault.azure.net/",
+		"GMSA_ID":                   "env",
+		"GMSA_DNS_IP":               "10.0.0.1",
+		"AZURE_SSH_PUBLIC_KEY_FILE": "/keys/.sshkey.pub",
+	})
+	file := writeConfigFile(t, `
+gmsaID: file
+dnsIP: 10.0.0.2
+domain: file.lan
+specTimeout: 5m
+`)
+
+	c, _, err := loadConfig([]string{"--name", "cluster", "--config", file, "--dns-ip", "10.0.0.3"}, env, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"env over default", c.KeyVaultURL, "https://env.vault.azure.net/"},
+		{"file over env", c.GmsaID, "file"},
+		{"flag over file", c.DNSIP, "10.0.0.3"},
+		{"file over default", c.Domain, "file.lan"},
+		{"file duration", time.Duration(c.SpecTimeout), 5 * time.Minute},
+		{"default", c.SSHUser, "capi"},
+		{"host keys verified by default", c.SSHHostKeyMode, "known-hosts"},
+		{"public key env", c.SSHKeyFile, "/keys/.sshkey"},
+		{"derived namespace", c.Namespace, "cluster"},
+		{"derived secret name", c.SpecSecretName, "gmsa-cred-spec-gmsa-e2e-file"},
+		{"derived progress file", c.ProgressFile, "gmsa-progress-cluster-cluster.json"},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	valid := []string{"--name", "c", "--keyvault-url", "https://v.vault.azure.net/", "--gmsa-id", "1", "--dns-ip", "10.0.0.1"}
+	tests := []struct {
+		name    string
+		args    []string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "missing required",
+			wantErr: "--name is required",
+		},
+		{
+			name:    "missing secret",
+			args:    []string{"--name", "c", "--keyvault-url", "https://v.vault.azure.net/", "--dns-ip", "10.0.0.1"},
+			wantErr: "--gmsa-id or --spec-secret-name is required",
+		},
+		{
+			name:    "insecure vault url",
+			args:    append(valid, "--keyvault-url", "http://v.vault.azure.net/"),
+			wantErr: "must be an https URL",
+		},
+		{
+			name:    "ipv6 dns",
+			args:    append(valid, "--dns-ip", "fd00::1"),
+			wantErr: "must be an IPv4 address",
+		},
+		{
+			name:    "invalid domain",
+			args:    append(valid, "--domain", "K8S_GMSA"),
+			wantErr: "--domain",
+		},
+		{
+			name:    "label without value",
+			args:    append(valid, "--node-label", "agentpool"),
+			wantErr: "must be key=value",
+		},
+		{
+			name:    "invalid duration flag",
+			args:    append(valid, "--spec-timeout", "soon"),
+			wantErr: "invalid value",
+		},
+		{
+			name:    "unknown host key mode",
+			args:    append(valid, "--ssh-host-key-mode", "ignore"),
+			wantErr: "--ssh-host-key-mode \"ignore\" must be one of",
+		},
+		{
+			name:    "host key secret without namespace",
+			args:    append(valid, "--ssh-host-key-mode", "cluster", "--host-key-secret", "keys"),
+			wantErr: "must be namespace/name",
+		},
+		{
+			name:    "unknown node executor",
+			args:    append(valid, "--node-executor", "winrm"),
+			wantErr: "--node-executor \"winrm\" must be one of",
+		},
+		{
+			name:    "invalid hostprocess namespace",
+			args:    append(valid, "--node-executor", "hostprocess", "--hostprocess-namespace", "Kube_System"),
+			wantErr: "--hostprocess-namespace",
+		},
+		{
+			name:    "unknown dns failure policy",
+			args:    append(valid, "--dns-failure-policy", "ignore"),
+			wantErr: "--dns-failure-policy \"ignore\" must be one of",
+		},
+		{
+			name:    "invalid dns concurrency",
+			args:    append(valid, "--dns-concurrency", "many"),
+			wantErr: "is not an integer",
+		},
+		{
+			name:    "spec file source without file",
+			args:    []string{"--name", "c", "--dns-ip", "10.0.0.1", "--spec-source", "file"},
+			wantErr: "--spec-file is required with --spec-source=file",
+		},
+		{
+			name:    "spec secret source without namespace",
+			args:    []string{"--name", "c", "--dns-ip", "10.0.0.1", "--spec-source", "secret", "--spec-kubernetes-secret", "credspec"},
+			wantErr: "must be namespace/name",
+		},
+		{
+			name:    "service account without namespace",
+			args:    append(valid, "--gmsa-service-accounts", "default/default,gmsa-user"),
+			wantErr: "entry \"gmsa-user\" must be namespace/name",
+		},
+		{
+			name:    "unknown file field",
+			args:    valid,
+			file:    "dnsServer: 10.0.0.1\n",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "numeric file duration",
+			args:    valid,
+			file:    "specTimeout: 10\n",
+			wantErr: "duration must be a string",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append(append([]string{}, args...), "--config", writeConfigFile(t, tc.file))
+			}
+			_, _, err := loadConfig(args, testEnv(nil), &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	args := []string{"--name", "c", "--keyvault-url", "https://v.vault.azure.net/", "--gmsa-id", "1", "--dns-ip", "10.0.0.1", "--print-config"}
+	c, opts, err := loadConfig(args, testEnv(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.printConfig {
+		t.Fatalf("expected --print-config to be set")
+	}
+	var out bytes.Buffer
+	if err := c.print(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "specTimeout: 15m0s") || !strings.Contains(out.String(), "nodeLabel: agentpool=windowsgmsa") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	// the printed configuration loads back to the same settings
+	reloaded, _, err := loadConfig([]string{"--config", writeConfigFile(t, out.String())}, testEnv(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded, c) {
+		t.Errorf("expected %+v, got %+v", c, reloaded)
+	}
+}
+
+func TestLoadConfigSpecSource(t *testing.T) {
+	// sources other than Key Vault need neither a vault nor a gMSA ID
+	args := []string{"--name", "c", "--dns-ip", "10.0.0.1", "--spec-source", "http", "--spec-url", "http://dc.k8sgmsa.lan/credspec"}
+	c, _, err := loadConfig(args, testEnv(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.SpecSource != specSourceHTTP || c.SpecURL != "http://dc.k8sgmsa.lan/credspec" {
+		t.Errorf("unexpected source %s %s", c.SpecSource, c.SpecURL)
+	}
+}
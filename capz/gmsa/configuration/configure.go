@@ -5,26 +5,24 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/onsi/gomega"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	capz "sigs.k8s.io/cluster-api-provider-azure/azure"
 	"sigs.k8s.io/cluster-api-provider-azure/test/e2e"
 
 	corev1 "k8s.io/api/core/v1"
@@ -40,175 +38,463 @@ func Fail(message string, _ ...int) {
 }
 
 func main() {
-	// needed for ginkgo
+	// the cluster-api test framework asserts with gomega
 	gomega.RegisterFailHandler(Fail)
 
-	// using a custom FlagSet here due to the dependency in controller-runtime that is already using this flag
-	// https://github.com/kubernetes-sigs/controller-runtime/blob/c7a98aa706379c4e5c79ea675c7f333192677971/pkg/client/config/config.go#L37-L41
-	fs := flag.NewFlagSet("logger", flag.ExitOnError)
-
-	// required flags
-	clustername := fs.String("name", "", "Name of the workload cluster to collect logs for")
-
-	// optional flags that default
-	namespace := fs.String("namespace", "", "namot include the command name. Must be called after all flags in the FlagSet are defined and before flags are accessed by the program. The return value will be ErrHelp if -help or -h were set buespace on management cluster to collect logs for")
-	kubeconfigPath := fs.String("kubeconfig", getKubeConfigPath(), "The kubeconfig for the management cluster")
-
-	if err := fs.Parse(os.Args[1:]); err != nil {
-		fmt.Println("Unable to parse command flags")
+	cfg, opts, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if opts.printConfig && cfg != nil {
+		if err := cfg.print(os.Stdout); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Printf("ERROR: invalid configuration: %v\n", err)
 		os.Exit(1)
 	}
-
-	// use the cluster name as the namespace which is default in e2e tests
-	if *namespace == "" {
-		namespace = clustername
+	if opts.printConfig {
+		return
 	}
 
-	bootstrapClusterProxy := e2e.NewAzureClusterProxy("bootstrap", *kubeconfigPath)
-	configureGmsa(context.Background(), bootstrapClusterProxy, *namespace, *clustername)
-}
-
-func getKubeConfigPath() string {
-	config := os.Getenv("KUBECONFIG")
-	if config == "" {
-		d, err := os.UserHomeDir()
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		return path.Join(d, ".kube", "config")
+	p, err := loadProgress(cfg.ProgressFile, cfg.Namespace, cfg.Name, opts.reset)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
 	}
 
-	return config
+	bootstrapClusterProxy := e2e.NewAzureClusterProxy("bootstrap", cfg.Kubeconfig)
+	incomplete, err := configureGmsa(context.Background(), bootstrapClusterProxy, cfg, p)
+	if err != nil {
+		fmt.Printf("ERROR: gMSA configuration failed: %v\n", err)
+		fmt.Printf("INFO: Rerun to continue from the failed step, progress is recorded in %s\n", cfg.ProgressFile)
+		os.Exit(1)
+	}
+	if len(incomplete) > 0 {
+		fmt.Printf("WARNING: gMSA configuration incomplete, steps %s did not finish\n", strings.Join(incomplete, ", "))
+		fmt.Printf("INFO: Rerun to run them again, progress is recorded in %s\n", cfg.ProgressFile)
+		os.Exit(exitIncomplete)
+	}
+	fmt.Printf("INFO: GMSA configuration complete\n")
 }
 
-func configureGmsa(ctx context.Context, bootstrapClusterProxy framework.ClusterProxy, namespace, clusterName string) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-	keyVaultClient, err := azsecrets.NewClient(os.Getenv("GMSA_KEYVAULT_URL"), cred, nil)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-	workloadProxy := bootstrapClusterProxy.GetWorkloadCluster(ctx, namespace, clusterName)
-
-	// Wait for the Domain to finish provisioning.  The existence of the spec file is the marker
-	gmsaSpecName := "gmsa-cred-spec-gmsa-e2e-" + os.Getenv("GMSA_ID")
-	fmt.Printf("INFO: Getting the gmsa gmsaSpecFile %s from %s\n", gmsaSpecName, os.Getenv("GMSA_KEYVAULT_URL"))
-	var gmsaSpecFile azsecrets.GetSecretResponse
-	gomega.Eventually(func() error {
-		// empty string for version gets the latest
-		version := ""
-		gmsaSpecFile, err = keyVaultClient.GetSecret(ctx, gmsaSpecName, version, nil)
-		if capz.ResourceNotFound(err) {
-			fmt.Printf("INFO: Waiting for gmsaSpecFile %s to be created by Domain controller\n", os.Getenv("GMSA_KEYVAULT_URL"))
-			return err
-		}
+// exitIncomplete is the exit status of a run that finished with incomplete
+// steps, so callers can tell it from a failed run.
+const exitIncomplete = 2
+
+// configurator holds the clusters being configured and the state shared by
+// the configuration steps. State is loaded on first use, so a step can run
+// when the steps before it were completed by an earlier run.
+type configurator struct {
+	cfg       *config
+	bootstrap framework.ClusterProxy
+	workload  framework.ClusterProxy
+
+	clusterHostName string
+	gmsaSpec        *string
+	gmsaNode        *corev1.Node
+	windowsNodes    []corev1.Node
+	executor        nodeExecutor
+}
 
-		if err != nil {
-			fmt.Printf("INFO: error when retrieving gmsaSpecFile %s\n", err)
-			return err
+// configureGmsa runs the configuration steps and returns the names of those
+// left incomplete.
+func configureGmsa(ctx context.Context, bootstrapClusterProxy framework.ClusterProxy, cfg *config, p *progress) ([]string, error) {
+	c := &configurator{
+		cfg:       cfg,
+		bootstrap: bootstrapClusterProxy,
+		workload:  bootstrapClusterProxy.GetWorkloadCluster(ctx, cfg.Namespace, cfg.Name),
+	}
+	defer func() {
+		if c.executor != nil {
+			c.executor.Close()
 		}
-		return nil
-	}, 10*time.Second, 15*time.Minute).Should(gomega.Succeed())
-	gomega.Expect(gmsaSpecFile.Value).ToNot(gomega.BeNil())
-
-	workloadCluster, err := util.GetClusterByName(ctx, bootstrapClusterProxy.GetClient(), namespace, clusterName)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
-	clusterHostName := workloadCluster.Spec.ControlPlaneEndpoint.Host
+	}()
+	return runSteps(ctx, c.steps(), p)
+}
 
-	gmsaNode, windowsNodes := labelGmsaTestNode(ctx, workloadProxy)
-	dropGmsaSpecOnTestNode(gmsaNode, clusterHostName, *gmsaSpecFile.Value)
-	configureCoreDNS(ctx, workloadProxy)
+// steps returns the configuration steps in the order they run.
+func (c *configurator) steps() []step {
+	var steps []step
+	if c.cfg.CheckGmsaWebhook {
+		steps = append(steps, step{name: "check-gmsa-webhook", run: c.checkGmsaWebhook})
+	}
+	steps = append(steps, []step{
+		{
+			name: "fetch-spec",
+			// The spec is only kept in memory and fetched again when a later
+			// step needs it, so there is nothing to check.
+			run: func(ctx context.Context) error {
+				_, err := c.credentialSpec(ctx)
+				return err
+			},
+		},
+		{
+			name: "label-node",
+			done: func(ctx context.Context) (bool, error) {
+				if err := c.loadWindowsNodes(ctx); err != nil {
+					return false, err
+				}
+				return c.gmsaNode != nil, nil
+			},
+			run: c.labelGmsaTestNode,
+		},
+		{
+			name: "write-spec",
+			// The spec file is replaced on every write, so only check that
+			// the node can be reached.
+			done: func(ctx context.Context) (bool, error) {
+				node, err := c.testNode(ctx)
+				if err != nil {
+					return false, err
+				}
+				if getHostName(node) == "" {
+					return false, errors.Errorf("node %s has no hostname address", node.Name)
+				}
+				return false, nil
+			},
+			run: c.dropGmsaSpecOnTestNode,
+		},
+	}...)
+	if c.cfg.ApplyCredSpec {
+		steps = append(steps, step{
+			name: "apply-credspec",
+			// Applying is idempotent, so there is nothing to check.
+			run: c.applyCredentialSpec,
+		})
+	}
+	return append(steps, []step{
+		{
+			name: "patch-coredns",
+			done: c.coreDNSConfigured,
+			run:  c.configureCoreDNS,
+		},
+		{
+			name: "update-node-dns",
+			// Nodes that already use the domain DNS server are left as they
+			// are by the command itself.
+			run: c.updateNodeDNS,
+		},
+	}...)
+}
 
-	for _, n := range windowsNodes.Items {
-		hostname := getHostName(&n)
-		// until https://github.com/kubernetes-sigs/cluster-api-provider-azure/issues/2182
-		updateWorkerNodeDNS(clusterHostName, hostname)
+// credentialSpec waits for the Domain to finish provisioning and returns the
+// credential spec it published in the --spec-source.
+func (c *configurator) credentialSpec(ctx context.Context) (string, error) {
+	if c.gmsaSpec != nil {
+		return *c.gmsaSpec, nil
+	}
+	src, err := c.specSource()
+	if err != nil {
+		return "", err
+	}
+	spec, err := waitForSpec(ctx, src, time.Duration(c.cfg.SpecPollInterval), time.Duration(c.cfg.SpecTimeout))
+	if err != nil {
+		return "", err
+	}
+	// catch a bad spec here, before it is written to the nodes and the cluster
+	credSpec, err := decodeCredentialSpec(spec)
+	if err != nil {
+		return "", errors.Wrapf(err, "gmsa spec %s", src)
+	}
+	if err := validateCredentialSpec(credSpec, c.cfg.Domain); err != nil {
+		return "", errors.Wrapf(err, "gmsa spec %s is invalid", src)
 	}
+	c.gmsaSpec = &spec
+	return spec, nil
+}
 
-	fmt.Printf("INFO: GMSA configuration complete\n")
+// specSource returns the configured credential spec source.
+func (c *configurator) specSource() (specSource, error) {
+	switch c.cfg.SpecSource {
+	case specSourceKeyVault:
+		return newKeyVaultSource(c.cfg.KeyVaultURL, c.cfg.SpecSecretName)
+	case specSourceSecret:
+		namespace, name, _ := strings.Cut(c.cfg.SpecKubernetesSecret, "/")
+		return &secretSource{client: c.bootstrap.GetClientSet(), namespace: namespace, name: name, key: c.cfg.SpecKubernetesSecretKey}, nil
+	case specSourceFile:
+		return &fileSource{path: c.cfg.SpecFile}, nil
+	case specSourceHTTP:
+		return &httpSource{url: c.cfg.SpecURL, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	default:
+		return nil, errors.Errorf("unknown credential spec source %q", c.cfg.SpecSource)
+	}
 }
 
-func updateWorkerNodeDNS(clusterHostName string, workerNodeHostName string) {
+// controlPlaneHost returns the control plane endpoint used as the SSH jump host.
+func (c *configurator) controlPlaneHost(ctx context.Context) (string, error) {
+	if c.clusterHostName != "" {
+		return c.clusterHostName, nil
+	}
+	workloadCluster, err := util.GetClusterByName(ctx, c.bootstrap.GetClient(), c.cfg.Namespace, c.cfg.Name)
+	if err != nil {
+		return "", errors.Wrapf(err, "getting cluster %s/%s", c.cfg.Namespace, c.cfg.Name)
+	}
+	c.clusterHostName = workloadCluster.Spec.ControlPlaneEndpoint.Host
+	return c.clusterHostName, nil
+}
 
-	fmt.Printf("INFO: Update node vm dns to %s\n", os.Getenv("GMSA_DNS_IP"))
-	dnsCmd := fmt.Sprintf("$currentDNS = (Get-DnsClientServerAddress -AddressFamily ipv4); Set-DnsClientServerAddress -InterfaceIndex $currentDNS[0].InterfaceIndex -ServerAddresses %s, $currentDNS[0].Address", os.Getenv("GMSA_DNS_IP"))
-	f, err := fileOnHost(filepath.Join("", "gmsa-spec-writer-output.txt"))
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+// updateNodeDNS adds the domain DNS server to every Windows node, several
+// nodes at a time, and prints the result of each node.
+func (c *configurator) updateNodeDNS(ctx context.Context) error {
+	if err := c.loadWindowsNodes(ctx); err != nil {
+		return err
+	}
+	executor, err := c.nodeExecutor(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := fileOnHost(c.cfg.OutputFile)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = f.Close()
 	}()
-	err = execOnHost(clusterHostName, workerNodeHostName, "22", f, dnsCmd)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	output := &syncWriter{w: f}
+
+	windowsNodes := map[string]*corev1.Node{}
+	var nodes []string
+	for i := range c.windowsNodes {
+		nodes = append(nodes, c.windowsNodes[i].Name)
+		windowsNodes[c.windowsNodes[i].Name] = &c.windowsNodes[i]
+	}
+	fmt.Printf("INFO: Update node vm dns to %s\n", c.cfg.DNSIP)
+	results, err := updateNodesDNS(ctx, nodes, c.cfg.dnsUpdateOptions(), func(ctx context.Context, node string) ([]string, []string, error) {
+		// until https://github.com/kubernetes-sigs/cluster-api-provider-azure/issues/2182
+		return updateWorkerNodeDNS(ctx, c.cfg, executor, output, windowsNodes[node])
+	})
+	if printErr := printDNSResults(os.Stdout, results); printErr != nil {
+		return printErr
+	}
+	if err != nil && c.cfg.DNSFailurePolicy == dnsBestEffort {
+		// continue with the other steps, but update the failed nodes on a rerun
+		return errors.Wrapf(errStepIncomplete, "continuing with nodes whose DNS was not updated: %v", err)
+	}
+	return err
 }
 
-func configureCoreDNS(ctx context.Context, workloadProxy framework.ClusterProxy) {
-	fmt.Printf("INFO: Update coredns with domain ip %s\n", os.Getenv("GMSA_DNS_IP"))
+// updateWorkerNodeDNS adds the domain DNS server to a node and returns its DNS
+// servers before and after.
+func updateWorkerNodeDNS(ctx context.Context, cfg *config, executor nodeExecutor, output io.StringWriter, node *corev1.Node) ([]string, []string, error) {
+	var out strings.Builder
+	if err := execOnHost(ctx, executor, node, &out, nodeDNSCommand(cfg.DNSIP)); err != nil {
+		return nil, nil, err
+	}
+	if _, err := output.WriteString(out.String()); err != nil {
+		return nil, nil, errors.Wrap(err, "writing output to file")
+	}
+	previous, current, err := parseNodeDNSOutput(out.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(current, cfg.DNSIP) {
+		return previous, current, errors.Errorf("DNS servers are %s after the update", dnsServers(current))
+	}
+	return previous, current, nil
+}
 
+func (c *configurator) getCoreDNSConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
 	corednsConfigMap := &corev1.ConfigMap{}
 	key := client.ObjectKey{
 		Namespace: "kube-system",
 		Name:      "coredns",
 	}
-	err := workloadProxy.GetClient().Get(ctx, key, corednsConfigMap)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	if err := c.workload.GetClient().Get(ctx, key, corednsConfigMap); err != nil {
+		return nil, errors.Wrap(err, "getting coredns ConfigMap")
+	}
+	if _, ok := corednsConfigMap.Data["Corefile"]; !ok {
+		return nil, errors.New("coredns ConfigMap has no Corefile")
+	}
+	return corednsConfigMap, nil
+}
 
-	corefile, ok := corednsConfigMap.Data["Corefile"]
-	gomega.Expect(ok).Should(gomega.BeTrue())
+// gmsaCorefile returns the Corefile with the server block forwarding the
+// domain to its DNS server, and whether that changed it.
+func (c *configurator) gmsaCorefile(corefile string) (string, bool, error) {
+	updated, changed, err := upsertForwardBlock(corefile, c.cfg.Domain, []string{c.cfg.DNSIP})
+	if err != nil {
+		return "", false, errors.Wrap(err, "parsing Corefile")
+	}
+	return updated, changed, nil
+}
+
+// corefileUpdatedAnnotation records on the coredns ConfigMap when the
+// configurator last changed the Corefile, so a rerun can tell whether coredns
+// was restarted after the change.
+const corefileUpdatedAnnotation = "windows-testing.k8s.io/corefile-updated-at"
+
+// coreDNSConfigured reports whether the Corefile already forwards the domain
+// to its DNS server and coredns was restarted after the Corefile changed.
+func (c *configurator) coreDNSConfigured(ctx context.Context) (bool, error) {
+	corednsConfigMap, err := c.getCoreDNSConfigMap(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, changed, err := c.gmsaCorefile(corednsConfigMap.Data["Corefile"])
+	if err != nil || changed {
+		return false, err
+	}
+	restartedAt, err := c.coreDNSRestartedAt(ctx)
+	if err != nil {
+		return false, err
+	}
+	return !coreDNSRestartPending(corednsConfigMap.Annotations[corefileUpdatedAnnotation], restartedAt), nil
+}
+
+// coreDNSRestartedAt returns the time of the last rollout restart of coredns,
+// or "" if it was never restarted.
+func (c *configurator) coreDNSRestartedAt(ctx context.Context) (string, error) {
+	deployment, err := c.workload.GetClientSet().AppsV1().Deployments("kube-system").Get(ctx, "coredns", v1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "getting coredns Deployment")
+	}
+	return deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"], nil
+}
+
+// coreDNSRestartPending reports whether coredns has to be restarted to load
+// the Corefile changed at updatedAt. A Corefile the configurator did not
+// change needs no restart; unreadable times do.
+func coreDNSRestartPending(updatedAt, restartedAt string) bool {
+	if updatedAt == "" {
+		return false
+	}
+	updated, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		return true
+	}
+	restarted, err := time.Parse(time.RFC3339, restartedAt)
+	if err != nil {
+		return true
+	}
+	return restarted.Before(updated)
+}
 
-	gmsaDNS := fmt.Sprintf(`k8sgmsa.lan:53 {
-	errors
-	cache 30
-	log
-	forward . %s
-}`, os.Getenv("GMSA_DNS_IP"))
+// configureCoreDNS updates the Corefile and restarts coredns. The two are
+// not atomic, so a rerun after a failed restart only restarts coredns.
+func (c *configurator) configureCoreDNS(ctx context.Context) error {
+	fmt.Printf("INFO: Update coredns with domain ip %s\n", c.cfg.DNSIP)
 
-	corefile += gmsaDNS
-	corednsConfigMap.Data["Corefile"] = corefile
-	err = workloadProxy.GetClient().Update(ctx, corednsConfigMap)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	corednsConfigMap, err := c.getCoreDNSConfigMap(ctx)
+	if err != nil {
+		return err
+	}
+	corefile, changed, err := c.gmsaCorefile(corednsConfigMap.Data["Corefile"])
+	if err != nil {
+		return err
+	}
+	if changed {
+		corednsConfigMap.Data["Corefile"] = corefile
+		if corednsConfigMap.Annotations == nil {
+			corednsConfigMap.Annotations = map[string]string{}
+		}
+		corednsConfigMap.Annotations[corefileUpdatedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		if err := c.workload.GetClient().Update(ctx, corednsConfigMap); err != nil {
+			return errors.Wrap(err, "updating coredns ConfigMap")
+		}
+	} else {
+		restartedAt, err := c.coreDNSRestartedAt(ctx)
+		if err != nil {
+			return err
+		}
+		if !coreDNSRestartPending(corednsConfigMap.Annotations[corefileUpdatedAnnotation], restartedAt) {
+			fmt.Printf("INFO: coredns already forwards the domain\n")
+			return nil
+		}
+		fmt.Printf("INFO: coredns was not restarted after the Corefile update\n")
+	}
 
-	// rollout restart to refresh the configuration
-	patch := []byte(`{"spec": {"template":{ "metadata": { "annotations": { "restartedBy": "gmsa" } } } } }`)
-	_, err = workloadProxy.GetClientSet().AppsV1().Deployments("kube-system").Patch(ctx, "coredns", types.MergePatchType, patch, v1.PatchOptions{})
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	// rollout restart to refresh the configuration, the same way kubectl does
+	patch := []byte(fmt.Sprintf(`{"spec": {"template":{ "metadata": { "annotations": { "kubectl.kubernetes.io/restartedAt": %q } } } } }`, time.Now().Format(time.RFC3339)))
+	_, err = c.workload.GetClientSet().AppsV1().Deployments("kube-system").Patch(ctx, "coredns", types.MergePatchType, patch, v1.PatchOptions{})
+	return errors.Wrap(err, "restarting coredns")
 }
 
-func dropGmsaSpecOnTestNode(gmsaNode *corev1.Node, clusterHostName string, value string) {
+func (c *configurator) dropGmsaSpecOnTestNode(ctx context.Context) error {
+	value, err := c.credentialSpec(ctx)
+	if err != nil {
+		return err
+	}
+	gmsaNode, err := c.testNode(ctx)
+	if err != nil {
+		return err
+	}
+	executor, err := c.nodeExecutor(ctx)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("INFO: Writing gmsa spec to disk\n")
-	f, err := fileOnHost(filepath.Join("", "gmsa-spec-writer-output.txt"))
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	f, err := fileOnHost(c.cfg.OutputFile)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = f.Close()
 	}()
-	hostname := getHostName(gmsaNode)
-
-	cmd := fmt.Sprintf("mkdir -force /gmsa; rm -force c:/gmsa/gmsa-cred-spec-gmsa-e2e.yml; $input='%s'; [System.Text.Encoding]::Unicode.GetString([System.Convert]::FromBase64String($input)) >> c:/gmsa/gmsa-cred-spec-gmsa-e2e.yml", value)
-	err = execOnHost(clusterHostName, hostname, "22", f, cmd)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	cmd := fmt.Sprintf("mkdir -force '%[1]s'; rm -force '%[2]s'; $input='%[3]s'; [System.Text.Encoding]::Unicode.GetString([System.Convert]::FromBase64String($input)) >> '%[2]s'", path.Dir(c.cfg.SpecPath), c.cfg.SpecPath, value)
+	return execOnHost(ctx, executor, gmsaNode, f, cmd)
 }
 
-func labelGmsaTestNode(ctx context.Context, workloadProxy framework.ClusterProxy) (*corev1.Node, *corev1.NodeList) {
-	windowsNodeOptions := v1.ListOptions{
+// loadWindowsNodes lists the Windows nodes and finds the one labeled for the
+// gMSA tests, if any.
+func (c *configurator) loadWindowsNodes(ctx context.Context) error {
+	windowsNodes, err := c.workload.GetClientSet().CoreV1().Nodes().List(ctx, v1.ListOptions{
 		LabelSelector: "kubernetes.io/os=windows",
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing Windows nodes")
 	}
+	if len(windowsNodes.Items) == 0 {
+		return errors.New("the workload cluster has no Windows nodes")
+	}
+	c.windowsNodes = windowsNodes.Items
+	c.gmsaNode = nil
+	key, value := c.cfg.nodeLabel()
+	for i := range c.windowsNodes {
+		if c.windowsNodes[i].Labels[key] == value {
+			c.gmsaNode = &c.windowsNodes[i]
+			break
+		}
+	}
+	return nil
+}
 
-	var gmsaNode *corev1.Node
-	var windowsNodes *corev1.NodeList
-	var err error
-	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		windowsNodes, err = workloadProxy.GetClientSet().CoreV1().Nodes().List(ctx, windowsNodeOptions)
+// testNode returns the node labeled for the gMSA tests.
+func (c *configurator) testNode(ctx context.Context) (*corev1.Node, error) {
+	if c.gmsaNode == nil {
+		if err := c.loadWindowsNodes(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if c.gmsaNode == nil {
+		return nil, errors.Errorf("no Windows node is labeled %s, rerun with --reset", c.cfg.NodeLabel)
+	}
+	return c.gmsaNode, nil
+}
+
+func (c *configurator) labelGmsaTestNode(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := c.loadWindowsNodes(ctx); err != nil {
+			return err
+		}
+		gmsaNode := c.windowsNodes[0].DeepCopy()
+		if gmsaNode.Labels == nil {
+			gmsaNode.Labels = map[string]string{}
+		}
+		key, value := c.cfg.nodeLabel()
+		gmsaNode.Labels[key] = value
+		fmt.Printf("INFO: Labeling node %s with %s\n", gmsaNode.Name, c.cfg.NodeLabel)
+		updated, err := c.workload.GetClientSet().CoreV1().Nodes().Update(ctx, gmsaNode, v1.UpdateOptions{})
 		if err != nil {
 			return err
 		}
-
-		gomega.Expect(len(windowsNodes.Items)).Should(gomega.BeNumerically(">", 0))
-		gmsaNode = &windowsNodes.Items[0]
-		gmsaNode.Labels["agentpool"] = "windowsgmsa"
-		fmt.Printf("INFO: Labeling node %s as 'windowsgmsa'\n", gmsaNode.Name)
-		_, err = workloadProxy.GetClientSet().CoreV1().Nodes().Update(ctx, gmsaNode, v1.UpdateOptions{})
-		return err
+		c.windowsNodes[0] = *updated
+		c.gmsaNode = &c.windowsNodes[0]
+		return nil
 	})
-	gomega.Expect(err).NotTo(gomega.HaveOccurred())
-	gomega.Expect(gmsaNode).NotTo(gomega.BeNil())
-	return gmsaNode, windowsNodes
 }
 
 func getHostName(gmsaNode *corev1.Node) string {
@@ -229,65 +515,85 @@ func fileOnHost(path string) (*os.File, error) {
 	return os.Create(path)
 }
 
-func execOnHost(controlPlaneEndpoint, hostname, port string, f io.StringWriter, command string,
-	args ...string) error {
-	config, err := newSSHConfig()
-	if err != nil {
-		return err
-	}
+// Node executors of --node-executor.
+const (
+	nodeExecutorSSH         = "ssh"
+	nodeExecutorHostProcess = "hostprocess"
+)
 
-	// Init a client connection to a control plane node via the public load balancer
-	lbClient, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", controlPlaneEndpoint, port), config)
-	if err != nil {
-		return errors.Wrapf(err, "dialing public load balancer at %s", controlPlaneEndpoint)
-	}
+var nodeExecutors = []string{nodeExecutorSSH, nodeExecutorHostProcess}
 
-	// Init a connection from the control plane to the target node
-	c, err := lbClient.Dial("tcp", fmt.Sprintf("%s:%s", hostname, port))
-	if err != nil {
-		return errors.Wrapf(err, "dialing from control plane to target node at %s", hostname)
-	}
+// nodeExecutor runs PowerShell commands on the Windows nodes, as chosen by
+// --node-executor.
+type nodeExecutor interface {
+	// run runs command on node and writes its output to output.
+	run(ctx context.Context, node *corev1.Node, command string, output io.Writer) error
+	// Close releases the resources of the executor.
+	Close()
+}
 
-	// Establish an authenticated SSH conn over the client -> control plane -> target transport
-	conn, chans, reqs, err := ssh.NewClientConn(c, hostname, config)
-	if err != nil {
-		return errors.Wrap(err, "getting a new SSH client connection")
-	}
-	client := ssh.NewClient(conn, chans, reqs)
-	session, err := client.NewSession()
+// sshExecutor runs commands over SSH through the control plane endpoint.
+type sshExecutor struct {
+	sessions        *sshManager
+	clusterHostName string
+}
+
+func (e *sshExecutor) run(ctx context.Context, node *corev1.Node, command string, output io.Writer) error {
+	stdout, err := e.sessions.run(ctx, e.clusterHostName, getHostName(node), command)
 	if err != nil {
-		return errors.Wrap(err, "opening SSH session")
+		return err
 	}
-	defer func() {
-		_ = session.Close()
-	}()
+	_, err = output.Write(stdout)
+	return errors.Wrap(err, "writing output")
+}
+
+func (e *sshExecutor) Close() {
+	e.sessions.Close()
+}
 
-	// Run the command and write the captured stdout to the file
-	var stdoutBuf bytes.Buffer
-	session.Stdout = &stdoutBuf
+func execOnHost(ctx context.Context, executor nodeExecutor, node *corev1.Node, f io.Writer, command string,
+	args ...string) error {
 	if len(args) > 0 {
 		command += " " + strings.Join(args, " ")
 	}
-	if err = session.Run(command); err != nil {
+	// Run the command and write its output to the file
+	if err := executor.run(ctx, node, command, f); err != nil {
 		return errors.Wrapf(err, "running command \"%s\"", command)
 	}
-	if _, err = f.WriteString(stdoutBuf.String()); err != nil {
-		return errors.Wrap(err, "writing output to file")
-	}
-
 	return nil
 }
 
-// newSSHConfig returns an SSH config for a workload cluster in the current e2e test run.
-func newSSHConfig() (*ssh.ClientConfig, error) {
-	// find private key file used for e2e workload cluster
-	keyfile := os.Getenv("AZURE_SSH_PUBLIC_KEY_FILE")
-	if len(keyfile) > 4 && strings.HasSuffix(keyfile, "pub") {
-		keyfile = keyfile[:(len(keyfile) - 4)]
+// nodeExecutor returns the executor of --node-executor. Over SSH, host keys
+// are verified as set by --ssh-host-key-mode.
+func (c *configurator) nodeExecutor(ctx context.Context) (nodeExecutor, error) {
+	if c.executor != nil {
+		return c.executor, nil
+	}
+	if c.cfg.NodeExecutor == nodeExecutorHostProcess {
+		c.executor = newHostProcessExecutor(c.workload.GetClientSet(), c.cfg.HostProcessNamespace, c.cfg.HostProcessImage)
+		return c.executor, nil
 	}
-	if keyfile == "" {
-		keyfile = ".sshkey"
+	clusterHostName, err := c.controlPlaneHost(ctx)
+	if err != nil {
+		return nil, err
 	}
+	hostKeyCallback, err := c.hostKeyCallback(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := newSSHConfig(c.cfg, hostKeyCallback)
+	if err != nil {
+		return nil, err
+	}
+	sessions := newSSHManager(config, c.cfg.SSHPort, time.Duration(c.cfg.SSHKeepalive))
+	c.executor = &sshExecutor{sessions: sessions, clusterHostName: clusterHostName}
+	return c.executor, nil
+}
+
+// newSSHConfig returns an SSH config for a workload cluster in the current e2e test run.
+func newSSHConfig(cfg *config, hostKeyCallback ssh.HostKeyCallback) (*ssh.ClientConfig, error) {
+	// find private key file used for e2e workload cluster
+	keyfile := cfg.SSHKeyFile
 	if _, err := os.Stat(keyfile); os.IsNotExist(err) {
 		if !filepath.IsAbs(keyfile) {
 			// current working directory may be test/e2e, so look in the project root
@@ -300,8 +606,8 @@ func newSSHConfig() (*ssh.ClientConfig, error) {
 		return nil, err
 	}
 	sshConfig := ssh.ClientConfig{
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		User:            "capi",
+		HostKeyCallback: hostKeyCallback,
+		User:            cfg.SSHUser,
 		Auth:            []ssh.AuthMethod{pubkey},
 	}
 	return &sshConfig, nil
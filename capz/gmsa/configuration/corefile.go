@@ -0,0 +1,182 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// serverBlock is a top-level server block of a Corefile, such as
+// "example.lan:53 { ... }".
+type serverBlock struct {
+	// keys are the zones the block serves, as written.
+	keys []string
+	// start and end are the offsets of the block in the Corefile, from the
+	// first key to the closing brace.
+	start, end int
+	// keysEnd is the offset of the opening brace.
+	keysEnd int
+}
+
+// parseCorefile returns the server blocks of a Corefile. Comments and quoted
+// strings are skipped, so braces in them do not count. The text between the
+// blocks is left to the caller.
+func parseCorefile(corefile string) ([]serverBlock, error) {
+	var blocks []serverBlock
+	var current serverBlock
+	depth := 0
+	inKeys := false
+	line := 1
+	for i := 0; i < len(corefile); i++ {
+		switch ch := corefile[i]; {
+		case ch == '\n':
+			line++
+			// Keys continue on the next line only after a comma. Other
+			// lines at the top level, such as imports, are not blocks.
+			if depth == 0 && inKeys && !strings.HasSuffix(strings.TrimRight(corefile[current.start:i], " \t\r"), ",") {
+				inKeys = false
+			}
+		case ch == '#':
+			// comments run to the end of the line
+			for i+1 < len(corefile) && corefile[i+1] != '\n' {
+				i++
+			}
+		case ch == '"' || ch == '`':
+			end := strings.IndexByte(corefile[i+1:], ch)
+			if end < 0 {
+				return nil, errors.Errorf("line %d: unterminated quote", line)
+			}
+			line += strings.Count(corefile[i:i+1+end], "\n")
+			i += end + 1
+		case ch == '{':
+			if depth == 0 {
+				if !inKeys {
+					return nil, errors.Errorf("line %d: server block without a zone", line)
+				}
+				current.keysEnd = i
+				current.keys = strings.FieldsFunc(corefile[current.start:i], func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+				})
+				inKeys = false
+			}
+			depth++
+		case ch == '}':
+			if depth == 0 {
+				return nil, errors.Errorf("line %d: unexpected '}'", line)
+			}
+			depth--
+			if depth == 0 {
+				current.end = i + 1
+				blocks = append(blocks, current)
+				current = serverBlock{}
+			}
+		case ch == ' ' || ch == '\t' || ch == '\r':
+		default:
+			if depth == 0 && !inKeys {
+				current.start = i
+				inKeys = true
+			}
+		}
+	}
+	if depth > 0 {
+		return nil, errors.New("unexpected end of Corefile, missing '}'")
+	}
+	return blocks, nil
+}
+
+// normalizeZone returns a server block key in a comparable form: lower case,
+// without the dns:// scheme and trailing dot, and with the default port.
+func normalizeZone(key string) string {
+	key = strings.ToLower(strings.TrimPrefix(key, "dns://"))
+	host, port := key, "53"
+	if i := strings.LastIndexByte(key, ':'); i >= 0 {
+		host, port = key[:i], key[i+1:]
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		host = "."
+	}
+	return host + ":" + port
+}
+
+// forwardBlock renders the server block forwarding zone to the DNS servers.
+func forwardBlock(zone string, servers []string) string {
+	return fmt.Sprintf(`%s:53 {
+	errors
+	cache 30
+	log
+	forward . %s
+}`, zone, strings.Join(servers, " "))
+}
+
+// upsertForwardBlock returns the Corefile with one server block forwarding
+// zone to the DNS servers, and whether it differs from corefile. Existing
+// blocks for the zone, including duplicates and blocks with stale forwarders,
+// are replaced by that block at the position of the first one. If the zone
+// shares a block with other zones, it is removed from that block.
+func upsertForwardBlock(corefile, zone string, servers []string) (string, bool, error) {
+	blocks, err := parseCorefile(corefile)
+	if err != nil {
+		return "", false, err
+	}
+	want := normalizeZone(zone)
+	block := forwardBlock(zone, servers)
+
+	var b strings.Builder
+	last := 0
+	inserted := false
+	for _, sb := range blocks {
+		var other []string
+		matched := false
+		for _, k := range sb.keys {
+			if normalizeZone(k) == want {
+				matched = true
+			} else {
+				other = append(other, k)
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if len(other) > 0 {
+			// keep the block of the other zones
+			b.WriteString(corefile[last:sb.start])
+			b.WriteString(strings.Join(other, " "))
+			b.WriteString(" ")
+			last = sb.keysEnd
+			continue
+		}
+		if !inserted {
+			b.WriteString(corefile[last:sb.start])
+			// start on a new line if an earlier run appended the block
+			// right after another one
+			if before := strings.TrimRight(b.String(), " \t"); before != "" && !strings.HasSuffix(before, "\n") {
+				b.WriteString("\n")
+			}
+			b.WriteString(block)
+			inserted = true
+		} else {
+			// drop the duplicate and the blank space before it
+			b.WriteString(strings.TrimRight(corefile[last:sb.start], " \t\r\n"))
+		}
+		last = sb.end
+	}
+	b.WriteString(corefile[last:])
+	out := b.String()
+
+	if !inserted {
+		out = strings.TrimRight(out, "\n") + "\n" + block + "\n"
+		if strings.TrimSpace(corefile) == "" {
+			out = block + "\n"
+		}
+	}
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out, out != corefile, nil
+}
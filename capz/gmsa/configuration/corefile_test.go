@@ -0,0 +1,154 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestUpsertForwardBlockGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "corefile", "*.in"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test inputs found")
+	}
+	for _, in := range inputs {
+		name := strings.TrimSuffix(filepath.Base(in), ".in")
+		t.Run(name, func(t *testing.T) {
+			corefile, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, changed, err := upsertForwardBlock(string(corefile), "k8sgmsa.lan", []string{"10.1.0.4"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			golden := strings.TrimSuffix(in, ".in") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("unexpected Corefile, run with -update to regenerate:\n%s", got)
+			}
+			if changed != (got != string(corefile)) {
+				t.Errorf("expected changed %v", !changed)
+			}
+
+			// a rerun leaves the Corefile as it is
+			again, changed, err := upsertForwardBlock(got, "k8sgmsa.lan", []string{"10.1.0.4"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed || again != got {
+				t.Errorf("expected the upsert to be idempotent, got:\n%s", again)
+			}
+			blocks, err := parseCorefile(again)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			n := 0
+			for _, b := range blocks {
+				for _, k := range b.keys {
+					if normalizeZone(k) == "k8sgmsa.lan:53" {
+						n++
+					}
+				}
+			}
+			if n != 1 {
+				t.Errorf("expected one block for the zone, got %d", n)
+			}
+		})
+	}
+}
+
+func TestParseCorefileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		corefile string
+		wantErr  string
+	}{
+		{
+			name:     "unclosed block",
+			corefile: ".:53 {\n    forward . 8.8.8.8\n",
+			wantErr:  "missing '}'",
+		},
+		{
+			name:     "unexpected close",
+			corefile: ".:53 {\n}\n}\n",
+			wantErr:  "line 3: unexpected '}'",
+		},
+		{
+			name:     "no zone",
+			corefile: "{\n}\n",
+			wantErr:  "line 1: server block without a zone",
+		},
+		{
+			name:     "unterminated quote",
+			corefile: ".:53 {\n    log \"{remote}\n}\n",
+			wantErr:  "line 2: unterminated quote",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseCorefile(tc.corefile)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeZone(t *testing.T) {
+	tests := map[string]string{
+		"k8sgmsa.lan":          "k8sgmsa.lan:53",
+		"k8sgmsa.lan.:53":      "k8sgmsa.lan:53",
+		"dns://K8SGMSA.LAN:53": "k8sgmsa.lan:53",
+		"k8sgmsa.lan:5353":     "k8sgmsa.lan:5353",
+		".:53":                 ".:53",
+		".":                    ".:53",
+	}
+	for key, want := range tests {
+		if got := normalizeZone(key); got != want {
+			t.Errorf("normalizeZone(%q): expected %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestCoreDNSRestartPending(t *testing.T) {
+	tests := []struct {
+		name        string
+		updatedAt   string
+		restartedAt string
+		want        bool
+	}{
+		{name: "not updated", restartedAt: "", want: false},
+		{name: "never restarted", updatedAt: "2026-03-01T10:00:00Z", want: true},
+		{name: "restarted before the update", updatedAt: "2026-03-01T10:00:00Z", restartedAt: "2026-03-01T09:00:00Z", want: true},
+		{name: "restarted after the update", updatedAt: "2026-03-01T10:00:00Z", restartedAt: "2026-03-01T11:00:05+01:00", want: false},
+		{name: "restarted in the same second", updatedAt: "2026-03-01T10:00:00Z", restartedAt: "2026-03-01T10:00:00Z", want: false},
+		{name: "unreadable restart", updatedAt: "2026-03-01T10:00:00Z", restartedAt: "yesterday", want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := coreDNSRestartPending(tc.updatedAt, tc.restartedAt); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	credSpecGroup    = "windows.k8s.io"
+	credSpecResource = "gmsacredentialspecs"
+	// fieldOwner owns the fields the configurator applies.
+	fieldOwner = "gmsa-configurator"
+	// gmsaWebhookSuffix ends the names of the webhooks of
+	// https://github.com/kubernetes-sigs/windows-gmsa.
+	gmsaWebhookSuffix = ".windows-gmsa.sigs.k8s.io"
+)
+
+var credSpecGVK = schema.GroupVersionKind{Group: credSpecGroup, Version: "v1", Kind: "GMSACredentialSpec"}
+
+// decodeCredentialSpec returns the GMSACredentialSpec manifest published by
+// the domain: YAML or JSON, UTF-16LE encoded and then base64 encoded. A bare
+// credential spec is rejected because it does not name the object.
+func decodeCredentialSpec(payload string) (*unstructured.Unstructured, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "credential spec is not base64")
+	}
+	manifest, err := decodeUTF16LE(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		return nil, errors.Wrap(err, "parsing credential spec manifest")
+	}
+	if _, ok := obj.Object["CmsPlugins"]; ok && obj.GetKind() == "" {
+		return nil, errors.Errorf("credential spec is a bare credential spec, publish it as the credspec of a %s manifest", credSpecGVK)
+	}
+	if obj.Object == nil || obj.GroupVersionKind() != credSpecGVK {
+		return nil, errors.Errorf("credential spec manifest is a %s, not a %s", obj.GroupVersionKind(), credSpecGVK)
+	}
+	if obj.GetName() == "" {
+		return nil, errors.New("credential spec manifest has no name")
+	}
+	return obj, nil
+}
+
+var (
+	// domainSIDPattern matches the SID of an Active Directory domain.
+	domainSIDPattern = regexp.MustCompile(`^S-1-5-21(-[0-9]+){3}$`)
+	guidPattern      = regexp.MustCompile(`^\{?[0-9a-fA-F]{8}(-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}\}?$`)
+)
+
+// validateCredentialSpec checks the credspec of a GMSACredentialSpec against
+// the credential spec schema, and that it belongs to the domain of --domain.
+func validateCredentialSpec(obj *unstructured.Unstructured, domain string) error {
+	var errs field.ErrorList
+	root := field.NewPath("credspec")
+	spec, ok := obj.Object["credspec"].(map[string]interface{})
+	if !ok {
+		return field.ErrorList{field.Required(root, "the GMSACredentialSpec has no credspec")}.ToAggregate()
+	}
+
+	plugins, _, err := unstructured.NestedStringSlice(spec, "CmsPlugins")
+	switch {
+	case err != nil:
+		errs = append(errs, field.Invalid(root.Child("CmsPlugins"), spec["CmsPlugins"], "must be a list of strings"))
+	case !slices.Contains(plugins, "ActiveDirectory"):
+		errs = append(errs, field.Invalid(root.Child("CmsPlugins"), plugins, `must contain "ActiveDirectory"`))
+	}
+
+	join := root.Child("DomainJoinConfig")
+	joinConfig := map[string]string{}
+	for _, name := range []string{"Sid", "MachineAccountName", "Guid", "DnsTreeName", "DnsName", "NetBiosName"} {
+		value, found, err := unstructured.NestedString(spec, "DomainJoinConfig", name)
+		if err != nil {
+			errs = append(errs, field.Invalid(join.Child(name), nil, "must be a string"))
+		} else if !found || value == "" {
+			errs = append(errs, field.Required(join.Child(name), ""))
+		}
+		joinConfig[name] = value
+	}
+	if v := joinConfig["Sid"]; v != "" && !domainSIDPattern.MatchString(v) {
+		errs = append(errs, field.Invalid(join.Child("Sid"), v, "must be a domain SID such as S-1-5-21-1-2-3"))
+	}
+	if v := joinConfig["Guid"]; v != "" && !guidPattern.MatchString(v) {
+		errs = append(errs, field.Invalid(join.Child("Guid"), v, "must be a GUID"))
+	}
+	if v := joinConfig["MachineAccountName"]; len(strings.TrimSuffix(v, "$")) > 15 {
+		errs = append(errs, field.TooLong(join.Child("MachineAccountName"), v, 15))
+	}
+	for _, name := range []string{"DnsTreeName", "DnsName"} {
+		if v := joinConfig[name]; v != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(v)) {
+				errs = append(errs, field.Invalid(join.Child(name), v, msg))
+			}
+		}
+	}
+	if v := joinConfig["NetBiosName"]; len(v) > 15 || strings.ContainsAny(v, `\/:*?"<>|. `) {
+		errs = append(errs, field.Invalid(join.Child("NetBiosName"), v, "must be at most 15 characters, without \\/:*?\"<>|., or spaces"))
+	}
+	if v := joinConfig["DnsName"]; v != "" && !strings.EqualFold(v, domain) {
+		errs = append(errs, field.Invalid(join.Child("DnsName"), v, fmt.Sprintf("does not match --domain %q: the spec belongs to another domain, or --domain is wrong", domain)))
+	}
+
+	accountsPath := root.Child("ActiveDirectoryConfig", "GroupManagedServiceAccounts")
+	value, _, _ := unstructured.NestedFieldNoCopy(spec, "ActiveDirectoryConfig", "GroupManagedServiceAccounts")
+	accounts, isList := value.([]interface{})
+	if value != nil && !isList {
+		errs = append(errs, field.Invalid(accountsPath, value, "must be a list"))
+	} else if len(accounts) == 0 {
+		errs = append(errs, field.Required(accountsPath, "at least one gMSA is required"))
+	}
+	machineAccountListed := false
+	for i, a := range accounts {
+		account, ok := a.(map[string]interface{})
+		if !ok {
+			errs = append(errs, field.Invalid(accountsPath.Index(i), a, "must be an object with Name and Scope"))
+			continue
+		}
+		name, _, _ := unstructured.NestedString(account, "Name")
+		scope, _, _ := unstructured.NestedString(account, "Scope")
+		if name == "" {
+			errs = append(errs, field.Required(accountsPath.Index(i).Child("Name"), ""))
+		}
+		if scope == "" {
+			errs = append(errs, field.Required(accountsPath.Index(i).Child("Scope"), ""))
+		} else if !strings.EqualFold(scope, joinConfig["DnsName"]) && !strings.EqualFold(scope, joinConfig["NetBiosName"]) {
+			errs = append(errs, field.Invalid(accountsPath.Index(i).Child("Scope"), scope, "must be the DnsName or NetBiosName of DomainJoinConfig"))
+		}
+		if strings.EqualFold(name, strings.TrimSuffix(joinConfig["MachineAccountName"], "$")) {
+			machineAccountListed = true
+		}
+	}
+	if len(accounts) > 0 && joinConfig["MachineAccountName"] != "" && !machineAccountListed {
+		errs = append(errs, field.Invalid(accountsPath, joinConfig["MachineAccountName"], "must include the MachineAccountName of DomainJoinConfig"))
+	}
+	return errs.ToAggregate()
+}
+
+// decodeUTF16LE decodes the text written by PowerShell's
+// [System.Text.Encoding]::Unicode, with or without a byte order mark.
+func decodeUTF16LE(data []byte) (string, error) {
+	if len(data)%2 != 0 {
+		return "", errors.Errorf("credential spec is not UTF-16LE, it has an odd length of %d bytes", len(data))
+	}
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		u = append(u, uint16(data[i])|uint16(data[i+1])<<8)
+	}
+	return strings.TrimPrefix(string(utf16.Decode(u)), "\ufeff"), nil
+}
+
+// credSpecRBAC returns the ClusterRole allowing to use the credential spec
+// and a RoleBinding granting it to each service account. GMSACredentialSpec
+// is cluster scoped, so a namespaced Role cannot refer to it.
+func credSpecRBAC(credSpecName string, serviceAccounts []string) (*rbacv1.ClusterRole, []*rbacv1.RoleBinding) {
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   v1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: v1.ObjectMeta{Name: credSpecName + "-user"},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{credSpecGroup},
+			Resources:     []string{credSpecResource},
+			Verbs:         []string{"use"},
+			ResourceNames: []string{credSpecName},
+		}},
+	}
+	var bindings []*rbacv1.RoleBinding
+	for _, sa := range serviceAccounts {
+		namespace, name, _ := strings.Cut(sa, "/")
+		bindings = append(bindings, &rbacv1.RoleBinding{
+			TypeMeta:   v1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: fmt.Sprintf("%s-use-%s", credSpecName, name)},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: namespace, Name: name}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role.Name},
+		})
+	}
+	return role, bindings
+}
+
+// applyCredentialSpec creates or updates the GMSACredentialSpec of the domain
+// in the workload cluster, and the RBAC letting the e2e service accounts use it.
+func (c *configurator) applyCredentialSpec(ctx context.Context) error {
+	payload, err := c.credentialSpec(ctx)
+	if err != nil {
+		return err
+	}
+	credSpec, err := decodeCredentialSpec(payload)
+	if err != nil {
+		return err
+	}
+	role, bindings := credSpecRBAC(credSpec.GetName(), c.cfg.GmsaServiceAccounts)
+
+	cl := c.workload.GetClient()
+	fmt.Printf("INFO: Applying GMSACredentialSpec %s\n", credSpec.GetName())
+	if err := cl.Patch(ctx, credSpec, client.Apply, client.FieldOwner(fieldOwner), client.ForceOwnership); err != nil {
+		if meta.IsNoMatchError(err) {
+			return errors.Errorf("the GMSACredentialSpec CRD is not installed, install the gMSA webhook from https://github.com/kubernetes-sigs/windows-gmsa or run without --apply-credspec")
+		}
+		return errors.Wrapf(err, "applying GMSACredentialSpec %s", credSpec.GetName())
+	}
+	if err := cl.Patch(ctx, role, client.Apply, client.FieldOwner(fieldOwner), client.ForceOwnership); err != nil {
+		return errors.Wrapf(err, "applying ClusterRole %s", role.Name)
+	}
+	for _, binding := range bindings {
+		fmt.Printf("INFO: Allowing service account %s/%s to use GMSACredentialSpec %s\n", binding.Namespace, binding.Subjects[0].Name, credSpec.GetName())
+		if err := cl.Patch(ctx, binding, client.Apply, client.FieldOwner(fieldOwner), client.ForceOwnership); err != nil {
+			return errors.Wrapf(err, "applying RoleBinding %s/%s", binding.Namespace, binding.Name)
+		}
+	}
+	return nil
+}
+
+// checkGmsaWebhook verifies that the mutating and validating webhooks of the
+// gMSA admission webhook are installed, since without them pods referring to
+// a GMSACredentialSpec get no credential spec.
+func (c *configurator) checkGmsaWebhook(ctx context.Context) error {
+	if err := checkGmsaWebhook(ctx, c.workload.GetClientSet()); err != nil {
+		return err
+	}
+	fmt.Printf("INFO: The gMSA admission webhook is installed\n")
+	return nil
+}
+
+func checkGmsaWebhook(ctx context.Context, clientset kubernetes.Interface) error {
+	admission := clientset.AdmissionregistrationV1()
+	mutating, err := admission.MutatingWebhookConfigurations().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing mutating webhooks")
+	}
+	validating, err := admission.ValidatingWebhookConfigurations().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing validating webhooks")
+	}
+
+	var mutatingNames, validatingNames []string
+	for _, config := range mutating.Items {
+		for _, webhook := range config.Webhooks {
+			mutatingNames = append(mutatingNames, webhook.Name)
+		}
+	}
+	for _, config := range validating.Items {
+		for _, webhook := range config.Webhooks {
+			validatingNames = append(validatingNames, webhook.Name)
+		}
+	}
+	if !hasGmsaWebhook(mutatingNames) {
+		return errors.Errorf("the gMSA admission webhook is not installed: no mutating webhook named *%s", gmsaWebhookSuffix)
+	}
+	if !hasGmsaWebhook(validatingNames) {
+		return errors.Errorf("the gMSA admission webhook is not installed: no validating webhook named *%s", gmsaWebhookSuffix)
+	}
+
+	if _, err := clientset.Discovery().ServerResourcesForGroupVersion(credSpecGVK.GroupVersion().String()); err != nil {
+		if apierrors.IsNotFound(err) {
+			return errors.New("the GMSACredentialSpec CRD is not installed")
+		}
+		return errors.Wrap(err, "discovering the GMSACredentialSpec CRD")
+	}
+	return nil
+}
+
+func hasGmsaWebhook(names []string) bool {
+	for _, name := range names {
+		if strings.HasSuffix(name, gmsaWebhookSuffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCredSpecManifest is a manifest as rendered by cred-spec.ps1 in domain.init.tmpl.
+const testCredSpecManifest = `apiVersion: windows.k8s.io/v1
+kind: GMSACredentialSpec
+metadata:
+  name: gmsa-e2e
+credspec:
+  CmsPlugins:
+  - ActiveDirectory
+  DomainJoinConfig:
+    Sid: S-1-5-21-2126449477-2524075714-3094792973
+    MachineAccountName: gmsa-e2e
+    Guid: 244818ae-87ac-4fcd-92ec-e79e5252348a
+    DnsTreeName: k8sgmsa.lan
+    DnsName: k8sgmsa.lan
+    NetBiosName: K8SGMSA
+  ActiveDirectoryConfig:
+    GroupManagedServiceAccounts:
+    - Name: gmsa-e2e
+      Scope: k8sgmsa.lan
+    - Name: gmsa-e2e
+      Scope: K8SGMSA
+    HostAccountConfig:
+      PortableCcgVersion: "1"
+      PluginGUID: '{CCC2A336-D7F3-4818-A213-272B7924213E}'
+      PluginInput: ObjectId=00000000-0000-0000-0000-000000000000;SecretUri=https://gmsa.vault.azure.net/secrets/gmsa-e2e
+`
+
+// encodeCredSpec encodes a manifest the way domain.init.tmpl does with
+// [Convert]::ToBase64String([System.Text.Encoding]::Unicode.GetBytes($spec)).
+func encodeCredSpec(manifest string) string {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(manifest)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestDecodeCredentialSpec(t *testing.T) {
+	for name, payload := range map[string]string{
+		"published":            encodeCredSpec(testCredSpecManifest),
+		"with byte order mark": encodeCredSpec("\ufeff" + testCredSpecManifest),
+	} {
+		t.Run(name, func(t *testing.T) {
+			obj, err := decodeCredentialSpec(payload + "\n")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if obj.GetName() != "gmsa-e2e" || obj.GroupVersionKind() != credSpecGVK {
+				t.Errorf("unexpected object %s %s", obj.GroupVersionKind(), obj.GetName())
+			}
+			dnsName, _, _ := unstructured.NestedString(obj.Object, "credspec", "DomainJoinConfig", "DnsName")
+			if dnsName != "k8sgmsa.lan" {
+				t.Errorf("expected the credspec to be kept, got DnsName %q", dnsName)
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{"not base64", "gmsa-e2e", "not base64"},
+		{"not UTF-16", base64.StdEncoding.EncodeToString([]byte("kind: GMSACredentialSpec\n")), "odd length"},
+		{"other kind", encodeCredSpec("apiVersion: v1\nkind: Secret\nmetadata:\n  name: gmsa-e2e\n"), "is a /v1, Kind=Secret"},
+		{"no name", encodeCredSpec("apiVersion: windows.k8s.io/v1\nkind: GMSACredentialSpec\n"), "has no name"},
+		{"bare credspec", encodeCredSpec(`{"CmsPlugins":["ActiveDirectory"],"DomainJoinConfig":{"DnsName":"k8sgmsa.lan"}}`), "bare credential spec"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decodeCredentialSpec(tc.payload); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCredSpecRBAC(t *testing.T) {
+	role, bindings := credSpecRBAC("gmsa-e2e", []string{"default/default", "e2e/gmsa-user"})
+	wantRule := rbacv1.PolicyRule{
+		APIGroups:     []string{"windows.k8s.io"},
+		Resources:     []string{"gmsacredentialspecs"},
+		Verbs:         []string{"use"},
+		ResourceNames: []string{"gmsa-e2e"},
+	}
+	if role.Name != "gmsa-e2e-user" || len(role.Rules) != 1 || !reflect.DeepEqual(role.Rules[0], wantRule) {
+		t.Errorf("unexpected ClusterRole %+v", role)
+	}
+	if len(bindings) != 2 {
+		t.Fatalf("expected 2 RoleBindings, got %d", len(bindings))
+	}
+	b := bindings[1]
+	if b.Namespace != "e2e" || b.Name != "gmsa-e2e-use-gmsa-user" || b.RoleRef.Kind != "ClusterRole" || b.RoleRef.Name != role.Name {
+		t.Errorf("unexpected RoleBinding %+v", b)
+	}
+	if want := (rbacv1.Subject{Kind: "ServiceAccount", Namespace: "e2e", Name: "gmsa-user"}); !reflect.DeepEqual(b.Subjects, []rbacv1.Subject{want}) {
+		t.Errorf("unexpected subjects %+v", b.Subjects)
+	}
+}
+
+func TestCheckGmsaWebhook(t *testing.T) {
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "gmsa-webhook"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "admission-webhook.windows-gmsa.sigs.k8s.io"}},
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "gmsa-webhook"},
+		Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "admission-webhook.windows-gmsa.sigs.k8s.io"}},
+	}
+	crd := &v1.APIResourceList{GroupVersion: "windows.k8s.io/v1", APIResources: []v1.APIResource{{Name: "gmsacredentialspecs", Kind: "GMSACredentialSpec"}}}
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		resources []*v1.APIResourceList
+		wantErr   string
+	}{
+		{name: "installed", objects: []runtime.Object{mutating, validating}, resources: []*v1.APIResourceList{crd}},
+		{name: "no webhooks", resources: []*v1.APIResourceList{crd}, wantErr: "no mutating webhook"},
+		{name: "no validating webhook", objects: []runtime.Object{mutating}, resources: []*v1.APIResourceList{crd}, wantErr: "no validating webhook"},
+		{name: "no CRD", objects: []runtime.Object{mutating, validating}, wantErr: "CRD is not installed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tc.objects...)
+			clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = tc.resources
+			err := checkGmsaWebhook(context.Background(), clientset)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCredentialSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		replace  []string
+		domain   string
+		wantErrs []string
+	}{
+		{name: "published"},
+		{name: "domain in other case", domain: "K8SGMSA.LAN"},
+		{
+			name:     "other domain",
+			domain:   "contoso.com",
+			wantErrs: []string{`credspec.DomainJoinConfig.DnsName: Invalid value: "k8sgmsa.lan": does not match --domain "contoso.com"`},
+		},
+		{
+			name:     "no Active Directory plugin",
+			replace:  []string{"- ActiveDirectory\n", "- Kerberos\n"},
+			wantErrs: []string{`credspec.CmsPlugins: Invalid value`},
+		},
+		{
+			name:     "missing fields",
+			replace:  []string{"    Sid: S-1-5-21-2126449477-2524075714-3094792973\n", "", "    NetBiosName: K8SGMSA\n", ""},
+			wantErrs: []string{"credspec.DomainJoinConfig.Sid: Required value", "credspec.DomainJoinConfig.NetBiosName: Required value"},
+		},
+		{
+			name:     "malformed identifiers",
+			replace:  []string{"S-1-5-21-2126449477-2524075714-3094792973", "S-1-5-32-544", "244818ae-87ac-4fcd-92ec-e79e5252348a", "gmsa-e2e-guid"},
+			wantErrs: []string{"credspec.DomainJoinConfig.Sid: Invalid value", "credspec.DomainJoinConfig.Guid: Invalid value"},
+		},
+		{
+			name:     "long NetBIOS name",
+			replace:  []string{"NetBiosName: K8SGMSA", "NetBiosName: K8SGMSA-WINDOWS-TESTING"},
+			wantErrs: []string{"credspec.DomainJoinConfig.NetBiosName: Invalid value", "credspec.ActiveDirectoryConfig.GroupManagedServiceAccounts[1].Scope: Invalid value"},
+		},
+		{
+			name:     "no accounts",
+			replace:  []string{"    - Name: gmsa-e2e\n      Scope: k8sgmsa.lan\n    - Name: gmsa-e2e\n      Scope: K8SGMSA\n", ""},
+			wantErrs: []string{"credspec.ActiveDirectoryConfig.GroupManagedServiceAccounts: Required value"},
+		},
+		{
+			name:     "other account",
+			replace:  []string{"- Name: gmsa-e2e\n      Scope: k8sgmsa.lan", "- Name: gmsa-other\n      Scope: k8sgmsa.lan", "- Name: gmsa-e2e\n      Scope: K8SGMSA", "- Name: gmsa-other\n      Scope: K8SGMSA"},
+			wantErrs: []string{"credspec.ActiveDirectoryConfig.GroupManagedServiceAccounts: Invalid value"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			manifest := strings.NewReplacer(tc.replace...).Replace(testCredSpecManifest)
+			obj, err := decodeCredentialSpec(encodeCredSpec(manifest))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			domain := tc.domain
+			if domain == "" {
+				domain = "k8sgmsa.lan"
+			}
+			err = validateCredentialSpec(obj, domain)
+			if len(tc.wantErrs) == 0 && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			for _, want := range tc.wantErrs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error containing %q, got %v", want, err)
+				}
+			}
+		})
+	}
+
+	obj, _ := decodeCredentialSpec(encodeCredSpec("apiVersion: windows.k8s.io/v1\nkind: GMSACredentialSpec\nmetadata:\n  name: gmsa-e2e\n"))
+	if err := validateCredentialSpec(obj, "k8sgmsa.lan"); err == nil || !strings.Contains(err.Error(), "has no credspec") {
+		t.Errorf("expected a missing credspec to fail, got %v", err)
+	}
+}
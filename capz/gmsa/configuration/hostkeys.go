@@ -0,0 +1,194 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Host key verification modes of --ssh-host-key-mode.
+const (
+	// hostKeyModeKnownHosts only accepts hosts listed in --known-hosts-file.
+	hostKeyModeKnownHosts = "known-hosts"
+	// hostKeyModeCluster only accepts the fingerprints published in the
+	// workload cluster, on node annotations or in a Secret.
+	hostKeyModeCluster = "cluster"
+	// hostKeyModeTOFU accepts and records the key of a host that is not in
+	// --known-hosts-file yet, and rejects changed keys.
+	hostKeyModeTOFU = "tofu"
+	// hostKeyModeInsecure accepts any host key.
+	hostKeyModeInsecure = "insecure"
+)
+
+var hostKeyModes = []string{hostKeyModeKnownHosts, hostKeyModeCluster, hostKeyModeTOFU, hostKeyModeInsecure}
+
+// hostKeyCallback returns the host key verification of the configured mode.
+func (c *configurator) hostKeyCallback(ctx context.Context) (ssh.HostKeyCallback, error) {
+	switch c.cfg.SSHHostKeyMode {
+	case hostKeyModeKnownHosts:
+		callback, err := knownhosts.New(c.cfg.KnownHostsFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading known hosts, use --ssh-host-key-mode=%s to record the keys of new hosts", hostKeyModeTOFU)
+		}
+		return callback, nil
+	case hostKeyModeCluster:
+		pinned, err := c.pinnedHostKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return pinned.callback, nil
+	case hostKeyModeTOFU:
+		return trustOnFirstUse(c.cfg.KnownHostsFile)
+	case hostKeyModeInsecure:
+		fmt.Printf("WARNING: SSH host keys are not verified\n")
+		return ssh.InsecureIgnoreHostKey(), nil
+	default:
+		return nil, errors.Errorf("unknown SSH host key mode %q", c.cfg.SSHHostKeyMode)
+	}
+}
+
+// trustOnFirstUse returns a callback that verifies host keys against the
+// known hosts file and appends the keys of hosts that are not in it.
+func trustOnFirstUse(path string) (ssh.HostKeyCallback, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating known hosts file")
+	}
+	_ = f.Close()
+
+	var mu sync.Mutex
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// read the file again to see the keys recorded by earlier connections
+		known, err := knownhosts.New(path)
+		if err != nil {
+			return errors.Wrap(err, "reading known hosts")
+		}
+		err = known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			// a known key, or a changed key that must not be replaced silently
+			return err
+		}
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return errors.Wrap(err, "recording host key")
+		}
+		defer f.Close()
+		if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			return errors.Wrap(err, "recording host key")
+		}
+		fmt.Printf("INFO: Trusting host key %s of %s on first use\n", ssh.FingerprintSHA256(key), hostname)
+		return nil
+	}, nil
+}
+
+// pinnedHostKeys are the SHA256 fingerprints accepted for each host.
+type pinnedHostKeys map[string][]string
+
+// add pins the fingerprints in value, separated by commas or whitespace, for host.
+func (p pinnedHostKeys) add(host, value string) error {
+	for _, fp := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		if !strings.HasPrefix(fp, "SHA256:") {
+			return errors.Errorf("fingerprint %q of %s must be in the SHA256:... form of ssh-keygen -l", fp, host)
+		}
+		p[strings.ToLower(host)] = append(p[strings.ToLower(host)], fp)
+	}
+	return nil
+}
+
+func (p pinnedHostKeys) callback(hostname string, _ net.Addr, key ssh.PublicKey) error {
+	host := hostname
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		host = h
+	}
+	want, ok := p[strings.ToLower(host)]
+	if !ok {
+		return errors.Errorf("no host key fingerprint is pinned for %s", host)
+	}
+	got := ssh.FingerprintSHA256(key)
+	for _, fp := range want {
+		if fp == got {
+			return nil
+		}
+	}
+	return errors.Errorf("host key %s of %s does not match the pinned fingerprints %s", got, host, strings.Join(want, ", "))
+}
+
+// pinnedHostKeys reads the host key fingerprints published in the workload
+// cluster. A node annotated with --host-key-annotation pins its fingerprints
+// for its addresses, and control plane nodes also for the control plane
+// endpoint. Each key of the --host-key-secret Secret is a host, with its
+// fingerprints as the value.
+func (c *configurator) pinnedHostKeys(ctx context.Context) (pinnedHostKeys, error) {
+	clusterHostName, err := c.controlPlaneHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pinned := pinnedHostKeys{}
+
+	nodes, err := c.workload.GetClientSet().CoreV1().Nodes().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+	for _, node := range nodes.Items {
+		value, ok := node.Annotations[c.cfg.HostKeyAnnotation]
+		if !ok {
+			continue
+		}
+		hosts := []string{node.Name}
+		for _, address := range node.Status.Addresses {
+			hosts = append(hosts, address.Address)
+		}
+		if _, ok := node.Labels["node-role.kubernetes.io/control-plane"]; ok {
+			hosts = append(hosts, clusterHostName)
+		}
+		for _, host := range hosts {
+			if err := pinned.add(host, value); err != nil {
+				return nil, errors.Wrapf(err, "node %s", node.Name)
+			}
+		}
+	}
+
+	if c.cfg.HostKeySecret != "" {
+		namespace, name, _ := strings.Cut(c.cfg.HostKeySecret, "/")
+		secret, err := c.workload.GetClientSet().CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting host key Secret %s", c.cfg.HostKeySecret)
+		}
+		for host, value := range secret.Data {
+			if err := pinned.add(host, string(value)); err != nil {
+				return nil, errors.Wrapf(err, "Secret %s", c.cfg.HostKeySecret)
+			}
+		}
+	}
+
+	if len(pinned) == 0 {
+		return nil, errors.Errorf("no host key fingerprints found on nodes annotated %s or in --host-key-secret", c.cfg.HostKeyAnnotation)
+	}
+	hosts := make([]string, 0, len(pinned))
+	for host := range pinned {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	fmt.Printf("INFO: Pinned SSH host keys for %s\n", strings.Join(hosts, ", "))
+	return pinned, nil
+}
@@ -0,0 +1,111 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	callback, err := trustOnFirstUse(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.1.0.4"), Port: 22}
+	key := newHostKey(t)
+
+	if err := callback("win-node:22", remote, key); err != nil {
+		t.Fatalf("expected the first key to be trusted, got %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "win-node ssh-ed25519 ") {
+		t.Errorf("expected the key to be recorded for win-node, got %q", data)
+	}
+
+	if err := callback("win-node:22", remote, key); err != nil {
+		t.Errorf("expected the recorded key to be accepted, got %v", err)
+	}
+	if err := callback("win-node:22", remote, newHostKey(t)); err == nil {
+		t.Errorf("expected a changed key to be rejected")
+	}
+	if err := callback("other-node:2222", remote, newHostKey(t)); err != nil {
+		t.Errorf("expected the key of another host to be trusted, got %v", err)
+	}
+
+	// a new run only trusts the recorded keys in the known-hosts mode
+	c := &configurator{cfg: &config{SSHHostKeyMode: hostKeyModeKnownHosts, KnownHostsFile: path}}
+	known, err := c.hostKeyCallback(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := known("win-node:22", remote, key); err != nil {
+		t.Errorf("expected the recorded key to be known, got %v", err)
+	}
+	if err := known("new-node:22", remote, newHostKey(t)); err == nil {
+		t.Errorf("expected an unknown host to be rejected")
+	}
+}
+
+func TestPinnedHostKeys(t *testing.T) {
+	key := newHostKey(t)
+	other := newHostKey(t)
+	pinned := pinnedHostKeys{}
+	if err := pinned.add("Win-Node", ssh.FingerprintSHA256(other)+", "+ssh.FingerprintSHA256(key)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		key      ssh.PublicKey
+		wantErr  string
+	}{
+		{name: "pinned key", hostname: "win-node:22", key: key},
+		{name: "second pinned key", hostname: "win-node:22", key: other},
+		{name: "without port", hostname: "win-node", key: key},
+		{name: "other key", hostname: "win-node:22", key: newHostKey(t), wantErr: "does not match the pinned fingerprints"},
+		{name: "unpinned host", hostname: "10.1.0.5:22", key: key, wantErr: "no host key fingerprint is pinned for 10.1.0.5"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pinned.callback(tc.hostname, nil, tc.key)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if err := pinned.add("win-node", "AAAAC3NzaC1lZDI1NTE5"); err == nil {
+		t.Errorf("expected a fingerprint without the SHA256: prefix to be rejected")
+	}
+}
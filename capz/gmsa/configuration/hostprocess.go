@@ -0,0 +1,162 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hostProcessContainer is the name of the container running the command.
+const hostProcessContainer = "command"
+
+// hostProcessExecutor runs commands on a node in a short-lived HostProcess
+// pod, streaming their output from the pod logs. It needs neither SSH nor the
+// node private key, only the workload cluster API.
+type hostProcessExecutor struct {
+	client    kubernetes.Interface
+	namespace string
+	image     string
+	// pollInterval is how often the pod is checked until it starts and
+	// completes.
+	pollInterval time.Duration
+}
+
+func newHostProcessExecutor(client kubernetes.Interface, namespace, image string) *hostProcessExecutor {
+	return &hostProcessExecutor{client: client, namespace: namespace, image: image, pollInterval: 2 * time.Second}
+}
+
+// run runs command in a HostProcess pod on node and writes its output to
+// output as it arrives. The pod is deleted when the command completes, fails
+// or ctx is done.
+func (e *hostProcessExecutor) run(ctx context.Context, node *corev1.Node, command string, output io.Writer) error {
+	pod, err := e.client.CoreV1().Pods(e.namespace).Create(ctx, e.pod(node, command), v1.CreateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "creating HostProcess pod on node %s", node.Name)
+	}
+	defer e.delete(pod.Name)
+
+	if _, err := e.wait(ctx, pod.Name, false); err != nil {
+		return errors.Wrapf(err, "starting HostProcess pod %s/%s on node %s", e.namespace, pod.Name, node.Name)
+	}
+	// keep the output for the error of a failed command
+	var logs bytes.Buffer
+	if err := e.followLogs(ctx, pod.Name, io.MultiWriter(output, &logs)); err != nil {
+		return errors.Wrapf(err, "reading the logs of HostProcess pod %s/%s", e.namespace, pod.Name)
+	}
+	exitCode, err := e.wait(ctx, pod.Name, true)
+	if err != nil {
+		return errors.Wrapf(err, "running HostProcess pod %s/%s on node %s", e.namespace, pod.Name, node.Name)
+	}
+	if exitCode != 0 {
+		return errors.Errorf("command exited with %d on node %s: %s", exitCode, node.Name, strings.TrimSpace(logs.String()))
+	}
+	return nil
+}
+
+// pod returns a pod running command in PowerShell on the host of node.
+func (e *hostProcessExecutor) pod(node *corev1.Node, command string) *corev1.Pod {
+	hostProcess := true
+	runAsUser := "NT AUTHORITY\\SYSTEM"
+	return &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("gmsa-exec-%s", utilrand.String(8)),
+			Namespace: e.namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": fieldOwner},
+		},
+		Spec: corev1.PodSpec{
+			// bypass the scheduler, the node may be cordoned or tainted
+			NodeName:      node.Name,
+			NodeSelector:  map[string]string{"kubernetes.io/os": "windows"},
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			RestartPolicy: corev1.RestartPolicyNever,
+			HostNetwork:   true,
+			SecurityContext: &corev1.PodSecurityContext{
+				WindowsOptions: &corev1.WindowsSecurityContextOptions{
+					HostProcess:   &hostProcess,
+					RunAsUserName: &runAsUser,
+				},
+			},
+			Containers: []corev1.Container{{
+				Name:    hostProcessContainer,
+				Image:   e.image,
+				Command: []string{"powershell.exe", "-NoLogo", "-NonInteractive", "-Command", command},
+			}},
+		},
+	}
+}
+
+// wait waits for the container of the command to start, or to complete if
+// completed is set, and returns the exit code of a completed command.
+func (e *hostProcessExecutor) wait(ctx context.Context, name string, completed bool) (int32, error) {
+	var exitCode int32
+	err := wait.PollImmediateUntilWithContext(ctx, e.pollInterval, func(ctx context.Context) (bool, error) {
+		pod, err := e.client.CoreV1().Pods(e.namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != hostProcessContainer {
+				continue
+			}
+			if terminated := status.State.Terminated; terminated != nil {
+				exitCode = terminated.ExitCode
+				return true, nil
+			}
+			if status.State.Running != nil && !completed {
+				return true, nil
+			}
+			if waiting := status.State.Waiting; waiting != nil {
+				switch waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerError", "CreateContainerConfigError":
+					return false, errors.Errorf("%s: %s", waiting.Reason, waiting.Message)
+				}
+			}
+		}
+		if pod.Status.Phase == corev1.PodFailed {
+			// rejected by the kubelet before the container started
+			return false, errors.Errorf("pod failed: %s %s", pod.Status.Reason, pod.Status.Message)
+		}
+		return false, nil
+	})
+	return exitCode, err
+}
+
+// followLogs copies the output of the command to w until the container
+// terminates.
+func (e *hostProcessExecutor) followLogs(ctx context.Context, name string, w io.Writer) error {
+	stream, err := e.client.CoreV1().Pods(e.namespace).GetLogs(name, &corev1.PodLogOptions{Container: hostProcessContainer, Follow: true}).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(w, stream)
+	return err
+}
+
+// delete deletes the pod, even when the context of the command is done.
+func (e *hostProcessExecutor) delete(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var gracePeriod int64
+	err := e.client.CoreV1().Pods(e.namespace).Delete(ctx, name, v1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+	if err != nil && !apierrors.IsNotFound(err) {
+		fmt.Printf("WARNING: Failed to delete HostProcess pod %s/%s: %v\n", e.namespace, name, err)
+	}
+}
+
+// Close does nothing, every pod is deleted when its command completes.
+func (e *hostProcessExecutor) Close() {}
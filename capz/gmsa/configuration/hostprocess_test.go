@@ -0,0 +1,133 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestHostProcessExecutor(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "win-1"}}
+	tests := []struct {
+		name    string
+		state   corev1.ContainerState
+		phase   corev1.PodPhase
+		wantErr string
+	}{
+		{name: "succeeded", state: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}, phase: corev1.PodSucceeded},
+		{name: "command failed", state: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}, phase: corev1.PodFailed, wantErr: "command exited with 1 on node win-1: fake logs"},
+		{name: "image not pulled", state: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"}}, phase: corev1.PodPending, wantErr: "ImagePullBackOff: not found"},
+		{name: "rejected", phase: corev1.PodFailed, wantErr: "pod failed: NodeAffinity"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			var created *corev1.Pod
+			client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				created = action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+				created.Status.Phase = tc.phase
+				if tc.name == "rejected" {
+					created.Status.Reason = "NodeAffinity"
+				} else {
+					created.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: hostProcessContainer, State: tc.state}}
+				}
+				// let the tracker store the pod with its status
+				return false, nil, nil
+			})
+			e := newHostProcessExecutor(client, "kube-system", "hpc:v1")
+			e.pollInterval = time.Millisecond
+
+			var out bytes.Buffer
+			err := e.run(context.Background(), node, "Get-DnsClientServerAddress", &out)
+			if tc.wantErr == "" && (err != nil || out.String() != "fake logs") {
+				t.Errorf("expected the logs, got %q, %v", out.String(), err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+
+			spec := created.Spec
+			if spec.NodeName != "win-1" || !*spec.SecurityContext.WindowsOptions.HostProcess || !spec.HostNetwork || spec.RestartPolicy != corev1.RestartPolicyNever {
+				t.Errorf("expected a HostProcess pod on win-1, got %+v", spec)
+			}
+			if command := spec.Containers[0].Command; command[len(command)-1] != "Get-DnsClientServerAddress" {
+				t.Errorf("unexpected command %q", command)
+			}
+			pods, err := client.CoreV1().Pods("kube-system").List(context.Background(), v1.ListOptions{})
+			if err != nil || len(pods.Items) != 0 {
+				t.Errorf("expected the pod to be deleted, got %d pods, %v", len(pods.Items), err)
+			}
+		})
+	}
+}
+
+func TestHostProcessExecutorFollowsLogs(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: hostProcessContainer, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}
+		return false, nil, nil
+	})
+	var follow bool
+	client.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "log" {
+			return false, nil, nil
+		}
+		follow = action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions).Follow
+		// the command completes while its logs are followed
+		podsResource := corev1.SchemeGroupVersion.WithResource("pods")
+		pods, err := client.Tracker().List(podsResource, corev1.SchemeGroupVersion.WithKind("Pod"), "kube-system")
+		if err != nil {
+			return true, nil, err
+		}
+		for _, pod := range pods.(*corev1.PodList).Items {
+			pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}
+			if err := client.Tracker().Update(podsResource, &pod, "kube-system"); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, nil
+	})
+	e := newHostProcessExecutor(client, "kube-system", "hpc:v1")
+	e.pollInterval = time.Millisecond
+
+	var out bytes.Buffer
+	if err := e.run(context.Background(), &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "win-1"}}, "hostname", &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !follow {
+		t.Errorf("expected the logs to be followed once the container runs")
+	}
+	if out.String() != "fake logs" {
+		t.Errorf("expected the logs, got %q", out.String())
+	}
+}
+
+func TestHostProcessExecutorCanceled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := newHostProcessExecutor(client, "kube-system", "hpc:v1")
+	e.pollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// the pod stays pending
+	if err := e.run(ctx, &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "win-1"}}, "hostname", io.Discard); err == nil {
+		t.Fatalf("expected an error")
+	}
+	pods, err := client.CoreV1().Pods("kube-system").List(context.Background(), v1.ListOptions{})
+	if err != nil || len(pods.Items) != 0 {
+		t.Errorf("expected the pod to be deleted, got %d pods, %v", len(pods.Items), err)
+	}
+}
@@ -0,0 +1,189 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Failure policies of --dns-failure-policy.
+const (
+	// dnsFailFast stops updating nodes at the first node that fails.
+	dnsFailFast = "fail-fast"
+	// dnsBestEffort updates every node and reports the failures without
+	// failing the run.
+	dnsBestEffort = "best-effort"
+)
+
+var dnsFailurePolicies = []string{dnsFailFast, dnsBestEffort}
+
+// dnsUpdateOptions control how the DNS servers of the nodes are updated.
+type dnsUpdateOptions struct {
+	// concurrency is the number of nodes updated at once.
+	concurrency int
+	// timeout bounds each attempt on a node.
+	timeout time.Duration
+	// retries is the number of attempts after the first one.
+	retries int
+	// retryDelay is the wait before the first retry, doubled for each next one.
+	retryDelay time.Duration
+	// failFast cancels the remaining nodes when a node fails.
+	failFast bool
+}
+
+// nodeDNSUpdate updates the DNS servers of a node and returns its servers
+// before and after.
+type nodeDNSUpdate func(ctx context.Context, node string) (previous, current []string, err error)
+
+// nodeDNSResult is the outcome of the update of a node.
+type nodeDNSResult struct {
+	node     string
+	attempts int
+	previous []string
+	current  []string
+	err      error
+}
+
+// updateNodesDNS runs update on the nodes with bounded concurrency, retrying
+// each node on failure, and returns a result per node in the order of nodes.
+// The error lists the failed nodes.
+func updateNodesDNS(ctx context.Context, nodes []string, opts dnsUpdateOptions, update nodeDNSUpdate) ([]nodeDNSResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]nodeDNSResult, len(nodes))
+	sem := make(chan struct{}, max(opts.concurrency, 1))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		results[i].node = node
+		// nodes start in order as slots free up
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].err = errors.Wrap(ctx.Err(), "not started")
+			continue
+		}
+		wg.Add(1)
+		go func(r *nodeDNSResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			updateNodeDNSWithRetry(ctx, r, opts, update)
+			if r.err != nil && opts.failFast {
+				cancel()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, errors.Wrapf(r.err, "node %s", r.node))
+		}
+	}
+	return results, utilerrors.NewAggregate(errs)
+}
+
+func updateNodeDNSWithRetry(ctx context.Context, r *nodeDNSResult, opts dnsUpdateOptions, update nodeDNSUpdate) {
+	delay := opts.retryDelay
+	for {
+		r.attempts++
+		attemptCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		r.previous, r.current, r.err = update(attemptCtx, r.node)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if timedOut && r.err != nil {
+			r.err = errors.Wrapf(r.err, "timed out after %s", opts.timeout)
+		}
+		if r.err == nil || r.attempts > opts.retries || ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("INFO: Updating DNS of node %s failed, retrying in %s: %v\n", r.node, delay, r.err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+	}
+}
+
+// printDNSResults writes the results as a table.
+func printDNSResults(w io.Writer, results []nodeDNSResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tRESULT\tATTEMPTS\tPREVIOUS DNS\tNEW DNS")
+	for _, r := range results {
+		result := "ok"
+		if r.err != nil {
+			result = "failed: " + r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", r.node, result, r.attempts, dnsServers(r.previous), dnsServers(r.current))
+	}
+	return tw.Flush()
+}
+
+func dnsServers(servers []string) string {
+	if len(servers) == 0 {
+		return "-"
+	}
+	return strings.Join(servers, ",")
+}
+
+// nodeDNSCommand adds dnsIP in front of the DNS servers of the first IPv4
+// interface, unless it is already there, and prints the servers before and
+// after as "previous=" and "current=" lines.
+func nodeDNSCommand(dnsIP string) string {
+	return fmt.Sprintf("$currentDNS = (Get-DnsClientServerAddress -AddressFamily ipv4); "+
+		"Write-Output ('previous=' + ($currentDNS[0].ServerAddresses -join ',')); "+
+		"if ($currentDNS[0].ServerAddresses -notcontains '%[1]s') { Set-DnsClientServerAddress -InterfaceIndex $currentDNS[0].InterfaceIndex -ServerAddresses %[1]s, $currentDNS[0].Address }; "+
+		"Write-Output ('current=' + ((Get-DnsClientServerAddress -InterfaceIndex $currentDNS[0].InterfaceIndex -AddressFamily ipv4).ServerAddresses -join ','))", dnsIP)
+}
+
+// parseNodeDNSOutput returns the DNS servers printed by nodeDNSCommand.
+func parseNodeDNSOutput(out string) (previous, current []string, err error) {
+	var seenPrevious, seenCurrent bool
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "previous="); ok {
+			previous, seenPrevious = splitServers(v), true
+		} else if v, ok := strings.CutPrefix(line, "current="); ok {
+			current, seenCurrent = splitServers(v), true
+		}
+	}
+	if !seenPrevious || !seenCurrent {
+		return nil, nil, errors.Errorf("unexpected output %q", out)
+	}
+	return previous, current, nil
+}
+
+func splitServers(v string) []string {
+	var servers []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// syncWriter serializes the writes of concurrent node updates.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.StringWriter
+}
+
+func (w *syncWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.WriteString(s)
+}
@@ -0,0 +1,155 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func testDNSOptions() dnsUpdateOptions {
+	return dnsUpdateOptions{concurrency: 2, timeout: time.Second, retries: 1, retryDelay: time.Millisecond, failFast: false}
+}
+
+func TestUpdateNodesDNSConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	update := func(ctx context.Context, node string) ([]string, []string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return []string{"168.63.129.16"}, []string{"10.0.0.4", "168.63.129.16"}, nil
+	}
+
+	nodes := []string{"win-1", "win-2", "win-3", "win-4", "win-5"}
+	results, err := updateNodesDNS(context.Background(), nodes, testDNSOptions(), update)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("expected at most 2 nodes updated at once, got %d", got)
+	}
+	for i, r := range results {
+		if r.node != nodes[i] || r.attempts != 1 || r.err != nil {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestUpdateNodesDNSRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	update := func(ctx context.Context, node string) ([]string, []string, error) {
+		mu.Lock()
+		calls[node]++
+		n := calls[node]
+		mu.Unlock()
+		switch {
+		case node == "flaky" && n == 1:
+			return nil, nil, errors.New("connection reset")
+		case node == "slow" && n == 1:
+			<-ctx.Done()
+			return nil, nil, ctx.Err()
+		case node == "broken":
+			return nil, nil, errors.New("access denied")
+		}
+		return []string{"168.63.129.16"}, []string{"10.0.0.4", "168.63.129.16"}, nil
+	}
+
+	opts := testDNSOptions()
+	opts.timeout = 50 * time.Millisecond
+	results, err := updateNodesDNS(context.Background(), []string{"flaky", "slow", "broken"}, opts, update)
+	if err == nil || !strings.Contains(err.Error(), "node broken: access denied") {
+		t.Fatalf("expected the broken node to fail, got %v", err)
+	}
+	want := []struct {
+		attempts int
+		failed   bool
+	}{{2, false}, {2, false}, {2, true}}
+	for i, r := range results {
+		if r.attempts != want[i].attempts || (r.err != nil) != want[i].failed {
+			t.Errorf("node %s: expected %d attempts and failed=%v, got %d attempts and %v", r.node, want[i].attempts, want[i].failed, r.attempts, r.err)
+		}
+	}
+}
+
+func TestUpdateNodesDNSFailFast(t *testing.T) {
+	update := func(ctx context.Context, node string) ([]string, []string, error) {
+		if node == "broken" {
+			return nil, nil, errors.New("access denied")
+		}
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+
+	opts := testDNSOptions()
+	opts.failFast = true
+	opts.retries = 3
+	opts.timeout = time.Minute
+	start := time.Now()
+	results, err := updateNodesDNS(context.Background(), []string{"waiting", "broken", "queued"}, opts, update)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the other nodes to be canceled")
+	}
+	if results[1].attempts != 4 {
+		t.Errorf("expected the broken node to be retried, got %d attempts", results[1].attempts)
+	}
+	if err := results[0].err; err == nil || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the running node to be canceled, got %v", err)
+	}
+	if err := results[2].err; err == nil || !strings.Contains(err.Error(), "not started") || results[2].attempts != 0 {
+		t.Errorf("expected the queued node not to start, got %d attempts and %v", results[2].attempts, err)
+	}
+}
+
+func TestPrintDNSResults(t *testing.T) {
+	var out bytes.Buffer
+	err := printDNSResults(&out, []nodeDNSResult{
+		{node: "win-1", attempts: 1, previous: []string{"168.63.129.16"}, current: []string{"10.0.0.4", "168.63.129.16"}},
+		{node: "win-22", attempts: 3, err: errors.New("access denied")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `NODE    RESULT                 ATTEMPTS  PREVIOUS DNS   NEW DNS
+win-1   ok                     1         168.63.129.16  10.0.0.4,168.63.129.16
+win-22  failed: access denied  3         -              -
+`
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
+
+func TestParseNodeDNSOutput(t *testing.T) {
+	previous, current, err := parseNodeDNSOutput("previous=168.63.129.16\r\ncurrent=10.0.0.4,168.63.129.16\r\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(previous, []string{"168.63.129.16"}) || !reflect.DeepEqual(current, []string{"10.0.0.4", "168.63.129.16"}) {
+		t.Errorf("unexpected servers %v and %v", previous, current)
+	}
+
+	if previous, _, err := parseNodeDNSOutput("previous=\ncurrent=10.0.0.4\n"); err != nil || previous != nil {
+		t.Errorf("expected no previous servers, got %v, %v", previous, err)
+	}
+	if _, _, err := parseNodeDNSOutput("Set-DnsClientServerAddress : Access is denied\n"); err == nil {
+		t.Errorf("expected an error for unexpected output")
+	}
+}
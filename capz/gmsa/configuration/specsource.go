@@ -0,0 +1,195 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	capz "sigs.k8s.io/cluster-api-provider-azure/azure"
+)
+
+// Credential spec sources of --spec-source.
+const (
+	specSourceKeyVault = "keyvault"
+	specSourceSecret   = "secret"
+	specSourceFile     = "file"
+	specSourceHTTP     = "http"
+)
+
+var specSources = []string{specSourceKeyVault, specSourceSecret, specSourceFile, specSourceHTTP}
+
+// errSpecNotFound is returned by a specSource until the domain publishes the
+// credential spec.
+var errSpecNotFound = errors.New("credential spec not found")
+
+// specSource is where the domain publishes its credential spec. Every source
+// holds the same value: a windows.k8s.io/v1 GMSACredentialSpec manifest with
+// the credential spec, UTF-16LE encoded and then base64 encoded. See
+// decodeCredentialSpec.
+type specSource interface {
+	// fetch returns the credential spec, or errSpecNotFound if it is not
+	// published yet.
+	fetch(ctx context.Context) (string, error)
+	// String describes the source in logs.
+	String() string
+}
+
+// waitForSpec polls src until the credential spec appears. Errors other than
+// errSpecNotFound are logged and retried, since the domain may still be
+// setting the source up.
+func waitForSpec(ctx context.Context, src specSource, interval, timeout time.Duration) (string, error) {
+	// The existence of the spec is the marker that the Domain is provisioned
+	fmt.Printf("INFO: Getting the gmsa spec from %s\n", src)
+	var spec string
+	err := wait.PollImmediateWithContext(ctx, interval, timeout, func(ctx context.Context) (bool, error) {
+		var err error
+		spec, err = src.fetch(ctx)
+		if errors.Is(err, errSpecNotFound) {
+			fmt.Printf("INFO: Waiting for gmsa spec %s to be created by Domain controller\n", src)
+			return false, nil
+		}
+		if err != nil {
+			fmt.Printf("INFO: error when retrieving gmsa spec %s\n", err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "waiting for gmsa spec %s", src)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return "", errors.Errorf("gmsa spec %s is empty", src)
+	}
+	return strings.TrimSpace(spec), nil
+}
+
+// keyVaultSource reads the credential spec from an Azure Key Vault secret.
+type keyVaultSource struct {
+	url    string
+	name   string
+	client *azsecrets.Client
+}
+
+func newKeyVaultSource(url, name string) (*keyVaultSource, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating Azure credential")
+	}
+	client, err := azsecrets.NewClient(url, cred, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating Key Vault client")
+	}
+	return &keyVaultSource{url: url, name: name, client: client}, nil
+}
+
+func (s *keyVaultSource) fetch(ctx context.Context) (string, error) {
+	// empty string for version gets the latest
+	version := ""
+	resp, err := s.client.GetSecret(ctx, s.name, version, nil)
+	if capz.ResourceNotFound(err) {
+		return "", errSpecNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", errors.Errorf("secret %s has no value", s.name)
+	}
+	return *resp.Value, nil
+}
+
+func (s *keyVaultSource) String() string {
+	return fmt.Sprintf("Key Vault secret %s in %s", s.name, s.url)
+}
+
+// secretSource reads the credential spec from a key of a Kubernetes Secret.
+type secretSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+}
+
+func (s *secretSource) fetch(ctx context.Context) (string, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", errSpecNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[s.key]
+	if !ok {
+		// the Secret may be created before the domain fills it in
+		return "", errSpecNotFound
+	}
+	return string(value), nil
+}
+
+func (s *secretSource) String() string {
+	return fmt.Sprintf("key %s of Secret %s/%s", s.key, s.namespace, s.name)
+}
+
+// fileSource reads the credential spec from a local file.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) fetch(context.Context) (string, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", errSpecNotFound
+	}
+	return string(data), err
+}
+
+func (s *fileSource) String() string {
+	return "file " + s.path
+}
+
+// httpSource reads the credential spec from the body of a GET request. A 404
+// response means the spec is not published yet.
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errSpecNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("GET %s: %s", s.url, resp.Status)
+	}
+	// a credential spec is a few kilobytes
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return string(body), err
+}
+
+func (s *httpSource) String() string {
+	return "URL " + s.url
+}
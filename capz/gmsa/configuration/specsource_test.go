@@ -0,0 +1,113 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testSpec is a base64 encoded UTF-16LE credential spec, as the domain publishes it.
+const testSpec = "ewAiAEMAbQBzAFAAbAB1AGcAaQBuAHMAIgA6AFsAIgBBAGMAdABpAHYAZQBEAGkAcgBlAGMAdABvAHIAeQAiAF0AfQA="
+
+func TestWaitForSpec(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credspec")
+	src := &fileSource{path: path}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = os.WriteFile(path, []byte(testSpec+"\n"), 0o600)
+	}()
+
+	spec, err := waitForSpec(context.Background(), src, 5*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec != testSpec {
+		t.Errorf("expected the spec without the trailing newline, got %q", spec)
+	}
+
+	_, err = waitForSpec(context.Background(), &fileSource{path: filepath.Join(dir, "missing")}, 5*time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "waiting for gmsa spec file") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := waitForSpec(context.Background(), &fileSource{path: empty}, 5*time.Millisecond, time.Second); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("expected an empty spec to fail, got %v", err)
+	}
+}
+
+func TestSecretSource(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	src := &secretSource{client: client, namespace: "gmsa", name: "credspec", key: "credspec"}
+	ctx := context.Background()
+
+	if _, err := src.fetch(ctx); !errors.Is(err, errSpecNotFound) {
+		t.Errorf("expected a missing Secret not to be found, got %v", err)
+	}
+	secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Namespace: "gmsa", Name: "credspec"}}
+	if _, err := client.CoreV1().Secrets("gmsa").Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := src.fetch(ctx); !errors.Is(err, errSpecNotFound) {
+		t.Errorf("expected a Secret without the key not to be found, got %v", err)
+	}
+	secret.Data = map[string][]byte{"credspec": []byte(testSpec)}
+	if _, err := client.CoreV1().Secrets("gmsa").Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec, err := src.fetch(ctx); err != nil || spec != testSpec {
+		t.Errorf("expected the spec, got %q, %v", spec, err)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			http.NotFound(w, r)
+		case 2:
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte(testSpec))
+		}
+	}))
+	defer server.Close()
+	src := &httpSource{url: server.URL + "/credspec", client: server.Client()}
+	ctx := context.Background()
+
+	if _, err := src.fetch(ctx); !errors.Is(err, errSpecNotFound) {
+		t.Errorf("expected 404 not to be found, got %v", err)
+	}
+	if _, err := src.fetch(ctx); err == nil || errors.Is(err, errSpecNotFound) || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected a 503 error, got %v", err)
+	}
+	if spec, err := src.fetch(ctx); err != nil || spec != testSpec {
+		t.Errorf("expected the spec, got %q, %v", spec, err)
+	}
+
+	// errors other than not found are retried while waiting
+	requests.Store(0)
+	spec, err := waitForSpec(ctx, src, 5*time.Millisecond, 5*time.Second)
+	if err != nil || spec != testSpec {
+		t.Errorf("expected the spec after retries, got %q, %v", spec, err)
+	}
+}
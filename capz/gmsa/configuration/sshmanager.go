@@ -0,0 +1,300 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveRequest is the global request OpenSSH clients send as keepalive.
+// Servers reply to it even though they do not implement it.
+const keepaliveRequest = "keepalive@openssh.com"
+
+// sshTarget identifies a node reached through a jump host. The connection to
+// the jump host itself is keyed by an empty host.
+type sshTarget struct {
+	jump string
+	host string
+}
+
+// sshManager keeps one SSH connection per jump host and per node reached
+// through it, and runs commands in sessions multiplexed over them. Broken
+// connections are dropped and dialed again on the next use.
+type sshManager struct {
+	config *ssh.ClientConfig
+	port   string
+	// keepalive is the interval of keepalive requests. A connection that
+	// does not answer within the interval is closed. Zero disables them.
+	keepalive time.Duration
+
+	mu      sync.Mutex
+	clients map[sshTarget]*ssh.Client
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newSSHManager(config *ssh.ClientConfig, port string, keepalive time.Duration) *sshManager {
+	return &sshManager{
+		config:    config,
+		port:      port,
+		keepalive: keepalive,
+		clients:   map[sshTarget]*ssh.Client{},
+		done:      make(chan struct{}),
+	}
+}
+
+// run runs command on host through jump and returns its standard output. A
+// connection that broke since its last use is dialed again once.
+func (m *sshManager) run(ctx context.Context, jump, host, command string) ([]byte, error) {
+	key := sshTarget{jump: jump, host: host}
+	for attempt := 0; ; attempt++ {
+		client, err := m.client(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			m.drop(key, client)
+			if attempt == 0 && ctx.Err() == nil {
+				continue
+			}
+			return nil, errors.Wrap(err, "opening SSH session")
+		}
+		defer func() {
+			_ = session.Close()
+		}()
+
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		errc := make(chan error, 1)
+		go func() { errc <- session.Run(command) }()
+		select {
+		case err := <-errc:
+			return stdout.Bytes(), err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// client returns the connection to key, dialing it and its jump host
+// connection if needed.
+func (m *sshManager) client(ctx context.Context, key sshTarget) (*ssh.Client, error) {
+	if client, err := m.cached(key); client != nil || err != nil {
+		return client, err
+	}
+	jumpKey := sshTarget{jump: key.jump}
+	if key == jumpKey {
+		return m.dialJump(ctx, key)
+	}
+	jump, err := m.client(ctx, jumpKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Init a connection from the control plane to the target node
+	addr := net.JoinHostPort(key.host, m.port)
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil && !m.alive(ctx, jump) {
+		// the jump host connection broke since its last use
+		m.drop(jumpKey, jump)
+		if jump, err = m.client(ctx, jumpKey); err != nil {
+			return nil, err
+		}
+		conn, err = jump.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing from control plane to target node at %s", key.host)
+	}
+	// Establish an authenticated SSH conn over the client -> control plane -> target transport.
+	// The host key of the target is verified for addr, not the control plane it is reached through.
+	client, err := m.handshake(ctx, conn, addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting a new SSH client connection to %s", key.host)
+	}
+	return m.store(key, client)
+}
+
+// dialJump connects to a control plane node via the public load balancer.
+func (m *sshManager) dialJump(ctx context.Context, key sshTarget) (*ssh.Client, error) {
+	addr := net.JoinHostPort(key.jump, m.port)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing public load balancer at %s", key.jump)
+	}
+	client, err := m.handshake(ctx, conn, addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to public load balancer at %s", key.jump)
+	}
+	return m.store(key, client)
+}
+
+// handshake establishes an SSH connection over conn, giving up when ctx is done.
+func (m *sshManager) handshake(ctx context.Context, conn net.Conn, addr string) (*ssh.Client, error) {
+	type result struct {
+		conn  ssh.Conn
+		chans <-chan ssh.NewChannel
+		reqs  <-chan *ssh.Request
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, m.config)
+		done <- result{c, chans, reqs, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			_ = conn.Close()
+			return nil, r.err
+		}
+		return ssh.NewClient(r.conn, r.chans, r.reqs), nil
+	case <-ctx.Done():
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (m *sshManager) cached(key sshTarget) (*ssh.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.New("SSH connections are closed")
+	}
+	return m.clients[key], nil
+}
+
+// store keeps client for key, unless another caller dialed it meanwhile, and
+// watches it until it is closed.
+func (m *sshManager) store(key sshTarget, client *ssh.Client) (*ssh.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		_ = client.Close()
+		return nil, errors.New("SSH connections are closed")
+	}
+	if existing, ok := m.clients[key]; ok {
+		_ = client.Close()
+		return existing, nil
+	}
+	m.clients[key] = client
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		// tunneled connections end with their jump host connection
+		_ = client.Wait()
+		m.forget(key, client)
+	}()
+	if m.keepalive > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.keepAlive(client)
+		}()
+	}
+	return client, nil
+}
+
+// keepAlive sends keepalive requests on client until it is closed, and closes
+// it when a request is not answered in time.
+func (m *sshManager) keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(m.keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			if err == nil {
+				continue
+			}
+		case <-time.After(m.keepalive):
+		case <-m.done:
+			return
+		}
+		_ = client.Close()
+		return
+	}
+}
+
+// drop closes client and forgets it.
+func (m *sshManager) drop(key sshTarget, client *ssh.Client) {
+	_ = client.Close()
+	m.forget(key, client)
+}
+
+func (m *sshManager) forget(key sshTarget, client *ssh.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.clients[key] == client {
+		delete(m.clients, key)
+	}
+}
+
+// Close closes the node connections, then the jump host connections, and
+// waits for their keepalives to stop.
+func (m *sshManager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.done)
+	clients := m.clients
+	m.clients = map[sshTarget]*ssh.Client{}
+	m.mu.Unlock()
+
+	for key, client := range clients {
+		if key.host != "" {
+			_ = client.Close()
+		}
+	}
+	for key, client := range clients {
+		if key.host == "" {
+			_ = client.Close()
+		}
+	}
+	m.wg.Wait()
+}
+
+// alive reports whether client answers a keepalive request before ctx is
+// done and, if keepalives are enabled, within the keepalive interval. A
+// connection that does not answer in time is treated as broken.
+func (m *sshManager) alive(ctx context.Context, client *ssh.Client) bool {
+	if m.keepalive > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.keepalive)
+		defer cancel()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		return err == nil
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,354 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an SSH server that runs exec requests by echoing the
+// command and forwards direct-tcpip channels, so it serves as both the jump
+// host and the node.
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+
+	accepted   atomic.Int32
+	keepalives atomic.Int32
+	// stall stops the server from answering keepalive requests.
+	stall atomic.Bool
+
+	mu    sync.Mutex
+	conns map[*ssh.ServerConn]bool
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &testSSHServer{listener: listener, config: config, hostKey: signer.PublicKey(), conns: map[*ssh.ServerConn]bool{}}
+	go s.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.disconnect()
+	})
+	return s
+}
+
+func (s *testSSHServer) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		go s.handle(nc)
+	}
+}
+
+func (s *testSSHServer) handle(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			if req.Type == keepaliveRequest {
+				s.keepalives.Add(1)
+				if s.stall.Load() {
+					continue
+				}
+			}
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}()
+	for ch := range chans {
+		switch ch.ChannelType() {
+		case "session":
+			go s.session(ch)
+		case "direct-tcpip":
+			go s.forward(ch)
+		default:
+			_ = ch.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testSSHServer) session(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		_ = ssh.Unmarshal(req.Payload, &payload)
+		_ = req.Reply(true, nil)
+		_, _ = io.WriteString(ch, "ran: "+payload.Command)
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func (s *testSSHServer) forward(newCh ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10)))
+	if err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		_ = target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		_, _ = io.Copy(target, ch)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(ch, target)
+	_ = ch.Close()
+}
+
+// active returns the number of open server connections.
+func (s *testSSHServer) active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// disconnect closes all server connections, as a restarted node would.
+func (s *testSSHServer) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func newTestSSHManager(t *testing.T, s *testSSHServer, keepalive time.Duration) *sshManager {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            "capi",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+	}
+	m := newSSHManager(config, s.port(), keepalive)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// eventually polls cond until it holds or a second passes.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSSHManagerReusesConnections(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestSSHManager(t, s, 0)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname")
+			if err == nil && string(out) != "ran: hostname" {
+				t.Errorf("unexpected output %q", out)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// one connection to the jump host and one tunneled to the node; a
+	// concurrent dial of the same target may be discarded
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eventually(t, "discarded connections to close", func() bool { return s.active() == 2 })
+}
+
+func TestSSHManagerReconnects(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestSSHManager(t, s, 0)
+	ctx := context.Background()
+
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	accepted := s.accepted.Load()
+	s.disconnect()
+	eventually(t, "connections to close", func() bool { return s.active() == 0 })
+
+	out, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "second")
+	if err != nil {
+		t.Fatalf("expected the broken connections to be dialed again, got %v", err)
+	}
+	if string(out) != "ran: second" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if got := s.accepted.Load() - accepted; got != 2 {
+		t.Errorf("expected 2 new connections, got %d", got)
+	}
+}
+
+func TestSSHManagerKeepalive(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestSSHManager(t, s, 20*time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eventually(t, "keepalives", func() bool { return s.keepalives.Load() >= 4 })
+	if s.active() != 2 {
+		t.Errorf("expected answered keepalives to keep 2 connections, got %d", s.active())
+	}
+
+	// connections that stop answering are closed and dialed again on use
+	s.stall.Store(true)
+	eventually(t, "unanswered connections to close", func() bool { return s.active() == 0 })
+	s.stall.Store(false)
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSSHManagerAliveDeadline(t *testing.T) {
+	s := newTestSSHServer(t)
+	ctx := context.Background()
+
+	for _, keepalive := range []time.Duration{0, 20 * time.Millisecond} {
+		m := newTestSSHManager(t, s, keepalive)
+		jump, err := m.client(ctx, sshTarget{jump: "127.0.0.1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !m.alive(ctx, jump) {
+			t.Errorf("expected an answering connection to be alive")
+		}
+
+		s.stall.Store(true)
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		start := time.Now()
+		if m.alive(timeout, jump) {
+			t.Errorf("expected a stalled connection not to be alive")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected alive to return at its deadline, took %s", elapsed)
+		}
+		cancel()
+		s.stall.Store(false)
+		m.Close()
+	}
+}
+
+func TestSSHManagerClose(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestSSHManager(t, s, 20*time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Close()
+	eventually(t, "connections to close", func() bool { return s.active() == 0 })
+
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("expected an error after Close, got %v", err)
+	}
+	m.Close()
+}
+
+func TestSSHManagerCanceled(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestSSHManager(t, s, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := m.run(ctx, "127.0.0.1", "127.0.0.1", "hostname"); err == nil {
+		t.Errorf("expected an error for a canceled context")
+	}
+}
@@ -0,0 +1,128 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// step is one stage of the gMSA configuration.
+type step struct {
+	name string
+	// done reports whether the effect of the step is already in place. It
+	// returns an error if a precondition of the step is not met.
+	done func(ctx context.Context) (bool, error)
+	run  func(ctx context.Context) error
+}
+
+// errStepIncomplete is returned by a step that did what it could but has to
+// run again on a rerun. It is not recorded, and the steps after it still run.
+var errStepIncomplete = errors.New("step incomplete")
+
+// progress is the persisted record of the steps completed for a cluster.
+type progress struct {
+	Namespace string               `json:"namespace"`
+	Cluster   string               `json:"cluster"`
+	Completed map[string]time.Time `json:"completed"`
+
+	path string
+}
+
+// loadProgress reads the progress record at path. A missing record, or one of
+// another cluster, starts from the first step. reset removes the record first.
+func loadProgress(path, namespace, cluster string, reset bool) (*progress, error) {
+	p := &progress{Namespace: namespace, Cluster: cluster, Completed: map[string]time.Time{}, path: path}
+	if reset {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "removing progress record")
+		}
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading progress record")
+	}
+	saved := &progress{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, errors.Wrapf(err, "parsing progress record %s", path)
+	}
+	if saved.Namespace != namespace || saved.Cluster != cluster {
+		fmt.Printf("INFO: Ignoring progress record %s of cluster %s/%s\n", path, saved.Namespace, saved.Cluster)
+		return p, nil
+	}
+	if saved.Completed != nil {
+		p.Completed = saved.Completed
+	}
+	return p, nil
+}
+
+// complete records that the named step finished and saves the record.
+func (p *progress) complete(name string) error {
+	p.Completed[name] = time.Now().UTC()
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), os.ModePerm); err != nil {
+		return err
+	}
+	// write and rename so an interrupted run never leaves a truncated record
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "writing progress record")
+	}
+	return errors.Wrap(os.Rename(tmp, p.path), "writing progress record")
+}
+
+// runSteps runs the steps in order, skipping those completed by an earlier run
+// or already in place, and stops at the first failure. It returns the names of
+// the steps left incomplete. A rerun continues at the failed step, and runs
+// incomplete steps again.
+func runSteps(ctx context.Context, steps []step, p *progress) (incomplete []string, err error) {
+	for i, s := range steps {
+		prefix := fmt.Sprintf("[%d/%d] %s", i+1, len(steps), s.name)
+		if at, ok := p.Completed[s.name]; ok {
+			fmt.Printf("INFO: %s: completed at %s, skipping\n", prefix, at.Format(time.RFC3339))
+			continue
+		}
+		if s.done != nil {
+			done, err := s.done(ctx)
+			if err != nil {
+				return incomplete, errors.Wrapf(err, "step %s", s.name)
+			}
+			if done {
+				fmt.Printf("INFO: %s: already in place, skipping\n", prefix)
+				if err := p.complete(s.name); err != nil {
+					return incomplete, err
+				}
+				continue
+			}
+		}
+
+		fmt.Printf("INFO: %s: running\n", prefix)
+		start := time.Now()
+		if err := s.run(ctx); errors.Is(err, errStepIncomplete) {
+			fmt.Printf("WARNING: %s: incomplete after %s, a rerun runs it again: %v\n", prefix, time.Since(start).Round(time.Second), err)
+			incomplete = append(incomplete, s.name)
+			continue
+		} else if err != nil {
+			fmt.Printf("ERROR: %s: failed after %s\n", prefix, time.Since(start).Round(time.Second))
+			return incomplete, errors.Wrapf(err, "step %s", s.name)
+		}
+		fmt.Printf("INFO: %s: done in %s\n", prefix, time.Since(start).Round(time.Second))
+		if err := p.complete(s.name); err != nil {
+			return incomplete, err
+		}
+	}
+	return incomplete, nil
+}
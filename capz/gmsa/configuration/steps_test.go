@@ -0,0 +1,237 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// recordedSteps returns steps that append their name to ran when they run.
+// done reports the steps in inPlace as already done, and the steps in failing
+// fail.
+func recordedSteps(ran *[]string, inPlace, failing map[string]bool, names ...string) []step {
+	var steps []step
+	for _, name := range names {
+		steps = append(steps, step{
+			name: name,
+			done: func(ctx context.Context) (bool, error) { return inPlace[name], nil },
+			run: func(ctx context.Context) error {
+				*ran = append(*ran, name)
+				if failing[name] {
+					return errors.New("boom")
+				}
+				return nil
+			},
+		})
+	}
+	return steps
+}
+
+func completedSteps(p *progress) []string {
+	var names []string
+	for name := range p.Completed {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestRunSteps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress", "gmsa-progress.json")
+	p, err := loadProgress(path, "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ran []string
+	steps := recordedSteps(&ran, map[string]bool{"b": true}, map[string]bool{"c": true}, "a", "b", "c", "d")
+	_, err = runSteps(context.Background(), steps, p)
+	if err == nil || !strings.Contains(err.Error(), "step c: boom") {
+		t.Fatalf("expected step c to fail, got %v", err)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("expected steps %v to run, got %v", want, ran)
+	}
+	if _, ok := p.Completed["b"]; !ok {
+		t.Errorf("expected the step already in place to be recorded, got %v", completedSteps(p))
+	}
+
+	// a rerun continues at the failed step
+	p, err = loadProgress(path, "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ran = nil
+	steps = recordedSteps(&ran, nil, nil, "a", "b", "c", "d")
+	if incomplete, err := runSteps(context.Background(), steps, p); err != nil || len(incomplete) != 0 {
+		t.Fatalf("expected all steps to complete, got %v and %v", incomplete, err)
+	}
+	if want := []string{"c", "d"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("expected steps %v to run, got %v", want, ran)
+	}
+	if len(p.Completed) != 4 {
+		t.Errorf("expected all steps to be recorded, got %v", completedSteps(p))
+	}
+}
+
+func TestRunStepsIncomplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gmsa-progress.json")
+	p, err := loadProgress(path, "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ran []string
+	steps := recordedSteps(&ran, nil, nil, "a", "b")
+	steps[0].run = func(ctx context.Context) error {
+		ran = append(ran, "a")
+		return errors.Wrap(errStepIncomplete, "node win-2 failed")
+	}
+	incomplete, err := runSteps(context.Background(), steps, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(incomplete, want) {
+		t.Errorf("expected steps %v to be incomplete, got %v", want, incomplete)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("expected steps %v to run, got %v", want, ran)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(completedSteps(p), want) {
+		t.Errorf("expected only %v to be recorded, got %v", want, completedSteps(p))
+	}
+
+	// a rerun runs the incomplete step again
+	p, err = loadProgress(path, "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ran = nil
+	steps = recordedSteps(&ran, nil, nil, "a", "b")
+	if incomplete, err := runSteps(context.Background(), steps, p); err != nil || len(incomplete) != 0 {
+		t.Fatalf("expected all steps to complete, got %v and %v", incomplete, err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("expected steps %v to run, got %v", want, ran)
+	}
+}
+
+func TestRunStepsDoneError(t *testing.T) {
+	p, err := loadProgress(filepath.Join(t.TempDir(), "gmsa-progress.json"), "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ran []string
+	steps := []step{{
+		name: "a",
+		done: func(ctx context.Context) (bool, error) { return false, errors.New("no gMSA node") },
+		run: func(ctx context.Context) error {
+			ran = append(ran, "a")
+			return nil
+		},
+	}}
+	_, err = runSteps(context.Background(), steps, p)
+	if err == nil || !strings.Contains(err.Error(), "no gMSA node") {
+		t.Errorf("expected the precondition error, got %v", err)
+	}
+	if len(ran) != 0 || len(p.Completed) != 0 {
+		t.Errorf("expected no step to run or be recorded, got %v and %v", ran, completedSteps(p))
+	}
+}
+
+func TestConfiguratorSteps(t *testing.T) {
+	stepNames := func(cfg *config) []string {
+		var names []string
+		for _, s := range (&configurator{cfg: cfg}).steps() {
+			names = append(names, s.name)
+		}
+		return names
+	}
+	want := []string{"fetch-spec", "label-node", "write-spec", "patch-coredns", "update-node-dns"}
+	if got := stepNames(&config{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected steps %v, got %v", want, got)
+	}
+	want = []string{"check-gmsa-webhook", "fetch-spec", "label-node", "write-spec", "apply-credspec", "patch-coredns", "update-node-dns"}
+	if got := stepNames(&config{CheckGmsaWebhook: true, ApplyCredSpec: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected steps %v, got %v", want, got)
+	}
+}
+
+func TestLoadProgress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gmsa-progress.json")
+	p, err := loadProgress(path, "default", "capz-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.complete("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary record to be renamed, got %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		cluster   string
+		want      []string
+	}{
+		{
+			name:      "same cluster",
+			namespace: "default",
+			cluster:   "capz-1",
+			want:      []string{"a"},
+		},
+		{
+			name:      "other cluster",
+			namespace: "default",
+			cluster:   "capz-2",
+		},
+		{
+			name:      "other namespace",
+			namespace: "e2e",
+			cluster:   "capz-1",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := loadProgress(path, tc.namespace, tc.cluster, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := completedSteps(p); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected completed steps %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		p, err := loadProgress(path, "default", "capz-1", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(p.Completed) != 0 {
+			t.Errorf("expected no completed steps, got %v", completedSteps(p))
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected the record to be removed, got %v", err)
+		}
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.json")
+		if err := os.WriteFile(invalid, []byte("{"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadProgress(invalid, "default", "capz-1", false); err == nil || !strings.Contains(err.Error(), "parsing progress record") {
+			t.Errorf("expected a parse error, got %v", err)
+		}
+	})
+}
@@ -710,7 +710,10 @@ wait_for_nodes() {
         fi
 
         pushd  "$SCRIPT_ROOT"/gmsa/configuration
-        go run --tags e2e configure.go --name "${CLUSTER_NAME}" --namespace default
+        # the cluster is new, so record its host keys for this run only
+        go run --tags e2e . --name "${CLUSTER_NAME}" --namespace default \
+            --ssh-host-key-mode tofu --known-hosts-file "${ARTIFACTS}/gmsa-known-hosts" \
+            --progress-file "${ARTIFACTS}/gmsa-progress.json"
         popd
         export KUBECONFIG="$SCRIPT_ROOT"/"${CLUSTER_NAME}".kubeconfig
     fi
@@ -97,3 +97,13 @@ Get the certificate issuer name
 {{- printf "%s-webhook-selfsigned-issuer" .Values.webhookType }}
 {{- end }}
 {{- end }}
+
+{{/*
+Whether the Hyper-V webhook gets a rules file, rendered from mutationRules and
+mutationMatchConditions
+*/}}
+{{- define "webhook.rulesFile" -}}
+{{- if and (eq .Values.webhookType "hyperv") (or .Values.mutationRules .Values.mutationMatchConditions) }}
+{{- true }}
+{{- end }}
+{{- end }}
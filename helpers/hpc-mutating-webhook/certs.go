@@ -0,0 +1,175 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"windows.k8s.io/webhook-common/certs"
+)
+
+// selfManagedCertOptions configures the built-in certificate management used
+// when cert-manager is not installed.
+type selfManagedCertOptions struct {
+	certs.Options
+	leaderElectionID string
+}
+
+// runSelfManagedCerts keeps store loaded from the certificate Secret on every
+// replica, and on the elected leader creates, rotates and injects the
+// certificates. It blocks until ctx is done.
+func runSelfManagedCerts(ctx context.Context, clientset kubernetes.Interface, o *selfManagedCertOptions, store *certs.Store) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("getting hostname for leader election: %w", err)
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, o.SecretNamespace, o.leaderElectionID,
+		clientset.CoreV1(), clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return fmt.Errorf("creating leader election lock: %w", err)
+	}
+
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := loadServingCert(ctx, clientset, o, store); err != nil {
+			klog.Infof("serving certificate not available from secret %s/%s: %v", o.SecretNamespace, o.SecretName, err)
+		}
+	}, 10*time.Second)
+
+	// Keep campaigning for leadership until ctx is done; a lost lease only
+	// stops rotation on this replica.
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.Infof("acquired certificate leader lease %s/%s", o.SecretNamespace, o.leaderElectionID)
+					wait.UntilWithContext(ctx, func(ctx context.Context) {
+						if err := reconcileCerts(ctx, clientset, o, time.Now()); err != nil {
+							klog.Errorf("unable to reconcile webhook certificates: %v", err)
+						}
+					}, o.SyncInterval)
+				},
+				OnStoppedLeading: func() {
+					klog.Info("lost certificate leader lease")
+				},
+			},
+		})
+	}, time.Second)
+	return nil
+}
+
+func loadServingCert(ctx context.Context, clientset kubernetes.Interface, o *selfManagedCertOptions, store *certs.Store) error {
+	secret, err := clientset.CoreV1().Secrets(o.SecretNamespace).Get(ctx, o.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changed, err := store.Set(secret.Data)
+	if changed {
+		klog.Info("loaded serving certificate")
+	}
+	return err
+}
+
+// reconcileCerts creates or rotates the certificates stored in the Secret and
+// injects the CA bundle into the MutatingWebhookConfiguration. The caBundle is
+// reapplied on every sync so a helm upgrade that resets the field heals.
+func reconcileCerts(ctx context.Context, clientset kubernetes.Interface, o *selfManagedCertOptions, now time.Time) error {
+	secrets := clientset.CoreV1().Secrets(o.SecretNamespace)
+	secret, err := secrets.Get(ctx, o.SecretName, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("getting secret %s/%s: %w", o.SecretNamespace, o.SecretName, err)
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: o.SecretName, Namespace: o.SecretNamespace},
+			Type:       corev1.SecretTypeTLS,
+		}
+	}
+
+	// The caBundle is read first, so a new CA is only used for the serving
+	// certificate once the apiserver trusts it.
+	mwcs := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	mwc, mwcErr := mwcs.Get(ctx, o.WebhookConfigName, metav1.GetOptions{})
+	var trusted [][]byte
+	if mwcErr == nil {
+		for _, wh := range mwc.Webhooks {
+			trusted = append(trusted, wh.ClientConfig.CABundle)
+		}
+	}
+
+	data, changed, err := certs.Ensure(secret.Data, trusted, &o.Options, now)
+	if err != nil {
+		return err
+	}
+	if changed {
+		secret.Data = data
+		if exists {
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		} else {
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("storing secret %s/%s: %w", o.SecretNamespace, o.SecretName, err)
+		}
+		klog.Infof("stored rotated webhook certificates in secret %s/%s", o.SecretNamespace, o.SecretName)
+	}
+
+	if mwcErr != nil {
+		return fmt.Errorf("getting MutatingWebhookConfiguration %s: %w", o.WebhookConfigName, mwcErr)
+	}
+	var patch []map[string]interface{}
+	for i, wh := range mwc.Webhooks {
+		if !bytes.Equal(wh.ClientConfig.CABundle, data[certs.CABundleKey]) {
+			patch = append(patch, map[string]interface{}{
+				"op":    "add",
+				"path":  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
+				"value": data[certs.CABundleKey],
+			})
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if _, err := mwcs.Patch(ctx, o.WebhookConfigName, types.JSONPatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("patching caBundle of MutatingWebhookConfiguration %s: %w", o.WebhookConfigName, err)
+	}
+	klog.Infof("injected caBundle into MutatingWebhookConfiguration %s", o.WebhookConfigName)
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"windows.k8s.io/webhook-common/certs"
+)
+
+func TestReconcileCerts(t *testing.T) {
+	o := &selfManagedCertOptions{Options: certs.Options{
+		SecretName:        "hpc-webhook-tls",
+		SecretNamespace:   "hpc-webhook",
+		WebhookConfigName: "hpc-webhook",
+		ServiceName:       "hpc-webhook",
+		CAValidity:        100 * 24 * time.Hour,
+		CertValidity:      10 * 24 * time.Hour,
+		RefreshBefore:     2 * 24 * time.Hour,
+	}}
+	clientset := fake.NewClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: o.WebhookConfigName},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "hpc.windows.k8s.io"}},
+	})
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := reconcileCerts(ctx, clientset, o, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(o.SecretNamespace).Get(ctx, o.SecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected secret to be created: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data[certs.CABundleKey]) {
+		t.Fatal("no CA certificates in bundle")
+	}
+	serving := certs.ValidCerts(secret.Data[corev1.TLSCertKey], now)
+	if len(serving) != 1 {
+		t.Fatalf("expected one serving certificate, got %d", len(serving))
+	}
+	if _, err := serving[0].Verify(x509.VerifyOptions{DNSName: "hpc-webhook.hpc-webhook.svc", Roots: roots, CurrentTime: now}); err != nil {
+		t.Errorf("serving certificate does not verify: %v", err)
+	}
+
+	mwc, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, o.WebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(mwc.Webhooks[0].ClientConfig.CABundle, secret.Data[certs.CABundleKey]) {
+		t.Error("expected caBundle to be injected")
+	}
+
+	// Close to expiry the serving certificate is reissued by the same CA.
+	later := now.Add(9 * 24 * time.Hour)
+	if err := reconcileCerts(ctx, clientset, o, later); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rotated, err := clientset.CoreV1().Secrets(o.SecretNamespace).Get(ctx, o.SecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(rotated.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Error("expected the serving certificate to be rotated")
+	}
+	if !bytes.Equal(rotated.Data[certs.CAKeyKey], secret.Data[certs.CAKeyKey]) {
+		t.Error("expected the CA to be kept while it is valid")
+	}
+
+	store := &certs.Store{}
+	if store.Ready() == nil {
+		t.Error("expected store to be unready before loading")
+	}
+	if err := loadServingCert(ctx, clientset, o, store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Ready(); err != nil {
+		t.Errorf("expected store to be ready: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/urfave/cli/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	"windows.k8s.io/webhook-common/loadgen"
+)
+
+// inProcessAdmit sends reviews to admit without a server.
+func inProcessAdmit(admit admitFunc) loadgen.AdmitFunc {
+	return func(ctx context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+		return admit(ctx, review), nil
+	}
+}
+
+// loadgenCommand sends synthesized or recorded AdmissionReviews to a webhook
+// endpoint, or in-process to mutateHPCPod, and reports throughput, errors and
+// latency percentiles. Its flags are shared with the Hyper-V webhook and
+// parsed by the loadgen package.
+func loadgenCommand() *cli.Command {
+	return &cli.Command{
+		Name:            "loadgen",
+		Usage:           "Send AdmissionReviews to the webhook and report throughput and latency.",
+		SkipFlagParsing: true,
+		Action: func(c *cli.Context) error {
+			return loadgen.Main(c.App.Name, c.Args().Slice(), c.App.Writer, inProcessAdmit(mutateHPCPod))
+		},
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"windows.k8s.io/webhook-common/loadgen"
+)
+
+func TestLoadgenInProcess(t *testing.T) {
+	var out bytes.Buffer
+	app := newApp()
+	app.Writer = &out
+	if err := app.Run([]string{"hpc-mutating-webhook", "loadgen", "--requests=50", "--concurrency=4", "--json"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := loadgen.Report{}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("expected a JSON report, got %q: %v", out.String(), err)
+	}
+	if report.Requests != 50 || report.Errors != 0 || report.Denied != 0 {
+		t.Errorf("expected 50 admitted requests, got %+v", report)
+	}
+	// Only the HPC agnhost pod of the five synthesized pods is mutated.
+	if report.Patched != 10 {
+		t.Errorf("expected 10 patched requests, got %d", report.Patched)
+	}
+}
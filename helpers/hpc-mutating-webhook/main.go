@@ -17,6 +17,8 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,24 +26,63 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	jsonpatch "gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"k8s.io/klog/v2"
+	"windows.k8s.io/webhook-common/certs"
+	"windows.k8s.io/webhook-common/recording"
+	"windows.k8s.io/webhook-common/tracing"
 )
 
 // agnhostCmdRegex matches "agnhost" followed by whitespace or end of string
 var agnhostCmdRegex = regexp.MustCompile(`^agnhost(\s+|$)`)
 
+// tracingService is the service.name of the exported spans.
+const tracingService = "hpc-mutating-webhook"
+
 type Flags struct {
-	certFile string
-	keyFile  string
-	port     int
+	certFile         string
+	keyFile          string
+	port             int
+	selfManagedCerts bool
+	certs            selfManagedCertOptions
+
+	recordPath          string
+	recordMaxSizeMB     int64
+	recordMaxFiles      int
+	recordRedactEnv     bool
+	recordRedactPattern string
+
+	matchConditionsFile string
+
+	tracing tracing.Options
+}
+
+// newRecorder returns the recorder configured by the --record-* flags, or nil
+// if recording is disabled.
+func (f *Flags) newRecorder() (*recording.Recorder, error) {
+	if f.recordPath == "" {
+		return nil, nil
+	}
+	redact, err := recording.NewRedaction(f.recordRedactEnv, f.recordRedactPattern)
+	if err != nil {
+		return nil, err
+	}
+	out := &recording.RotatingFile{Path: recording.FilePath(f.recordPath), MaxBytes: f.recordMaxSizeMB << 20, MaxFiles: f.recordMaxFiles}
+	return &recording.Recorder{Webhook: recorderWebhookName, Redact: redact, Out: out}, nil
 }
 
 func main() {
@@ -58,13 +99,11 @@ func newApp() *cli.App {
 			Name:        "tls-cert-file",
 			Usage:       "File containing the default x509 Certificate for HTTPS. (CA cert, if any, concatenated after server cert).",
 			Destination: &flags.certFile,
-			Required:    true,
 		},
 		&cli.StringFlag{
 			Name:        "tls-private-key-file",
 			Usage:       "File containing the default x509 private key matching --tls-cert-file.",
 			Destination: &flags.keyFile,
-			Required:    true,
 		},
 		&cli.IntFlag{
 			Name:        "port",
@@ -72,6 +111,112 @@ func newApp() *cli.App {
 			Value:       443,
 			Destination: &flags.port,
 		},
+		&cli.BoolFlag{
+			Name:        "self-managed-certs",
+			Usage:       "Generate and rotate the CA and serving certificate in-process instead of reading --tls-cert-file and --tls-private-key-file. Certificates are stored in --cert-secret-name and injected into --webhook-configuration-name.",
+			Destination: &flags.selfManagedCerts,
+		},
+		&cli.StringFlag{
+			Name:        "cert-secret-name",
+			Usage:       "The Secret storing self-managed certificates.",
+			Destination: &flags.certs.SecretName,
+		},
+		&cli.StringFlag{
+			Name:        "cert-secret-namespace",
+			Usage:       "The namespace of --cert-secret-name, the webhook Service and the leader election Lease.",
+			EnvVars:     []string{"POD_NAMESPACE"},
+			Destination: &flags.certs.SecretNamespace,
+		},
+		&cli.StringFlag{
+			Name:        "webhook-configuration-name",
+			Usage:       "The MutatingWebhookConfiguration whose caBundle is kept in sync with self-managed certificates.",
+			Destination: &flags.certs.WebhookConfigName,
+		},
+		&cli.StringFlag{
+			Name:        "service-name",
+			Usage:       "The webhook Service name used as the serving certificate DNS name.",
+			Destination: &flags.certs.ServiceName,
+		},
+		&cli.StringFlag{
+			Name:        "leader-election-id",
+			Usage:       "The name of the Lease used to elect the replica that rotates self-managed certificates.",
+			Value:       "hpc-webhook.windows.k8s.io",
+			Destination: &flags.certs.leaderElectionID,
+		},
+		&cli.DurationFlag{
+			Name:        "ca-validity",
+			Usage:       "Lifetime of a self-managed CA.",
+			Value:       10 * 365 * 24 * time.Hour,
+			Destination: &flags.certs.CAValidity,
+		},
+		&cli.DurationFlag{
+			Name:        "cert-validity",
+			Usage:       "Lifetime of a self-managed serving certificate.",
+			Value:       365 * 24 * time.Hour,
+			Destination: &flags.certs.CertValidity,
+		},
+		&cli.DurationFlag{
+			Name:        "cert-refresh-before",
+			Usage:       "Rotate a self-managed serving certificate when it expires within this duration.",
+			Value:       30 * 24 * time.Hour,
+			Destination: &flags.certs.RefreshBefore,
+		},
+		&cli.DurationFlag{
+			Name:        "cert-sync-interval",
+			Usage:       "How often self-managed certificates and the caBundle are checked.",
+			Value:       time.Minute,
+			Destination: &flags.certs.SyncInterval,
+		},
+		&cli.StringFlag{
+			Name:        "record-path",
+			Usage:       "File or directory to which every AdmissionReview request, decision and response patch is appended as JSON Lines. Disabled when empty.",
+			Destination: &flags.recordPath,
+		},
+		&cli.Int64Flag{
+			Name:        "record-max-size-mb",
+			Usage:       "Size in megabytes at which the recording is rotated.",
+			Value:       100,
+			Destination: &flags.recordMaxSizeMB,
+		},
+		&cli.IntFlag{
+			Name:        "record-max-files",
+			Usage:       "Number of rotated recordings kept.",
+			Value:       5,
+			Destination: &flags.recordMaxFiles,
+		},
+		&cli.BoolFlag{
+			Name:        "record-redact-env-values",
+			Usage:       "Replace all env var values and container command lines in recordings.",
+			Value:       true,
+			Destination: &flags.recordRedactEnv,
+		},
+		&cli.StringFlag{
+			Name:        "record-redact-pattern",
+			Usage:       "Regular expression of env var names, annotation keys and command line flags whose values are replaced in recordings.",
+			Value:       recording.DefaultRedactPattern,
+			Destination: &flags.recordRedactPattern,
+		},
+		&cli.StringFlag{
+			Name:        "match-conditions-file",
+			Usage:       "YAML file with CEL matchConditions. Pods are only mutated if all conditions are true.",
+			Destination: &flags.matchConditionsFile,
+		},
+		&cli.StringFlag{
+			Name:        "tracing-endpoint",
+			Usage:       "The host:port of an OTLP gRPC collector admission spans are exported to. Tracing is disabled when empty.",
+			Destination: &flags.tracing.Endpoint,
+		},
+		&cli.BoolFlag{
+			Name:        "tracing-insecure",
+			Usage:       "Connect to the tracing collector without TLS.",
+			Destination: &flags.tracing.Insecure,
+		},
+		&cli.Float64Flag{
+			Name:        "tracing-sampling-ratio",
+			Usage:       "Fraction of requests without a trace context from the API server that are traced.",
+			Value:       1,
+			Destination: &flags.tracing.SamplingRatio,
+		},
 	}
 	// Additional flags can be added here if needed
 
@@ -81,28 +226,106 @@ func newApp() *cli.App {
 		ArgsUsage:       " ",
 		HideHelpCommand: true,
 		Flags:           cliFlags,
+		Commands:        []*cli.Command{replayCommand(), loadgenCommand()},
 		Before: func(c *cli.Context) error {
+			// Subcommands do not serve, so the server flags are not
+			// required.
+			if c.App.Command(c.Args().First()) != nil {
+				return nil
+			}
 			if c.Args().Len() > 0 {
 				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
 			}
+			if flags.selfManagedCerts {
+				return flags.certs.Validate()
+			}
+			if flags.certFile == "" || flags.keyFile == "" {
+				return fmt.Errorf("--tls-cert-file and --tls-private-key-file are required unless --self-managed-certs is set")
+			}
 			return nil
 		},
 		Action: func(c *cli.Context) error {
+			rec, err := flags.newRecorder()
+			if err != nil {
+				return err
+			}
+			if rec != nil {
+				defer rec.Out.Close()
+			}
+			shutdownTracing, err := tracing.Setup(c.Context, tracingService, flags.tracing)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := shutdownTracing(context.Background()); err != nil {
+					klog.Errorf("unable to flush traces: %v", err)
+				}
+			}()
+
+			var admit admitFunc = mutateHPCPod
+			if flags.matchConditionsFile != "" {
+				conditions, err := loadMatchConditionsFile(flags.matchConditionsFile)
+				if err != nil {
+					return err
+				}
+				// namespaceObject is null without a client; conditions
+				// using it fail and the pod is admitted unmodified.
+				var clientset kubernetes.Interface
+				if config, err := rest.InClusterConfig(); err != nil {
+					klog.Warningf("namespaceObject is unavailable to match conditions: %v", err)
+				} else if clientset, err = kubernetes.NewForConfig(config); err != nil {
+					return err
+				}
+				admit = withMatchConditions(conditions, clientset, admit)
+			}
+			if rec != nil {
+				admit = recordingAdmit(rec, admit)
+			}
+
+			if !flags.selfManagedCerts {
+				server := &http.Server{
+					Handler: newMux(nil, admit),
+					Addr:    fmt.Sprintf(":%d", flags.port),
+				}
+				klog.Infof("starting webhook server on %s", server.Addr)
+				return server.ListenAndServeTLS(flags.certFile, flags.keyFile)
+			}
+
+			config, err := rest.InClusterConfig()
+			if err != nil {
+				return fmt.Errorf("loading in-cluster config for self-managed certificates: %w", err)
+			}
+			clientset, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			store := &certs.Store{}
+			go func() {
+				if err := runSelfManagedCerts(c.Context, clientset, &flags.certs, store); err != nil {
+					klog.Fatal(err)
+				}
+			}()
+
 			server := &http.Server{
-				Handler: newMux(),
-				Addr:    fmt.Sprintf(":%d", flags.port),
+				Handler:   newMux(store.Ready, admit),
+				Addr:      fmt.Sprintf(":%d", flags.port),
+				TLSConfig: &tls.Config{GetCertificate: store.GetCertificate},
 			}
-			klog.Infof("starting webhook server on %s", server.Addr)
-			return server.ListenAndServeTLS(flags.certFile, flags.keyFile)
+			klog.Infof("starting webhook server on %s with self-managed certificates", server.Addr)
+			return server.ListenAndServeTLS("", "")
 		},
 	}
 
 	return app
 }
 
-func newMux() *http.ServeMux {
+// newMux returns the webhook handlers, serving admit on /mutate. When ready is
+// non-nil, /readyz reports its error until it returns nil.
+func newMux(ready func() error, admit admitFunc) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate", serveHPCPodMutation)
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, admit)
+	})
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
 		_, err := w.Write([]byte("ok"))
 		if err != nil {
@@ -111,6 +334,12 @@ func newMux() *http.ServeMux {
 		}
 	})
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if ready != nil {
+			if err := ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
 		_, err := w.Write([]byte("ok"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -120,13 +349,19 @@ func newMux() *http.ServeMux {
 	return mux
 }
 
-func serveHPCPodMutation(w http.ResponseWriter, r *http.Request) {
-	serve(w, r, mutateHPCPod)
-}
+// admitFunc decides an admission request.
+type admitFunc func(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
 
 // serve handles the http portion of a request prior to handing to an admit
-// function.
-func serve(w http.ResponseWriter, r *http.Request, admit func(admissionv1.AdmissionReview) *admissionv1.AdmissionResponse) {
+// function. The request continues the trace of the API server if it carries
+// one.
+func serve(w http.ResponseWriter, r *http.Request, admit admitFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Tracer(tracingService).Start(ctx, "admission", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	_, decodeSpan := tracing.StartSpan(ctx, tracingService, "decode")
+	defer decodeSpan.End()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		klog.Error(err)
@@ -152,9 +387,12 @@ func serve(w http.ResponseWriter, r *http.Request, admit func(admissionv1.Admiss
 		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
+	decodeSpan.End()
+	span.SetAttributes(tracing.RequestAttributes(requestedAdmissionReview.Request)...)
+
 	responseAdmissionReview := &admissionv1.AdmissionReview{}
 	responseAdmissionReview.SetGroupVersionKind(requestedAdmissionReview.GroupVersionKind())
-	responseAdmissionReview.Response = admit(*requestedAdmissionReview)
+	responseAdmissionReview.Response = admit(ctx, *requestedAdmissionReview)
 	if responseAdmissionReview.Response == nil {
 		responseAdmissionReview.Response = &admissionv1.AdmissionResponse{
 			Allowed: false,
@@ -165,6 +403,13 @@ func serve(w http.ResponseWriter, r *http.Request, admit func(admissionv1.Admiss
 		}
 	}
 	responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
+	span.SetAttributes(
+		attribute.Bool("admission.allowed", responseAdmissionReview.Response.Allowed),
+		attribute.Int("admission.patch_bytes", len(responseAdmissionReview.Response.Patch)),
+	)
+
+	_, encodeSpan := tracing.StartSpan(ctx, tracingService, "encode")
+	defer encodeSpan.End()
 
 	klog.V(2).Infof("sending response: %v", responseAdmissionReview)
 	respBytes, err := json.Marshal(responseAdmissionReview)
@@ -195,8 +440,10 @@ func readAdmissionReview(data []byte) (*admissionv1.AdmissionReview, error) {
 }
 
 // mutateHPCPod mutates pod specifications for HPC containers
-func mutateHPCPod(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func mutateHPCPod(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	klog.V(2).Info("processing HPC pod mutation")
+	_, span := tracing.StartSpan(ctx, tracingService, "decide")
+	defer func() { span.End() }()
 
 	// Only handle Pod resources
 	if ar.Request.Resource.Group != "" || ar.Request.Resource.Resource != "pods" {
@@ -205,6 +452,18 @@ func mutateHPCPod(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
 		}
 	}
 
+	// Most pods are not HPC agnhost pods. Decide those from a partial decode
+	// before decoding the full pod; decoding errors are reported by
+	// extractPod.
+	if ar.Request.Object.Raw != nil {
+		if sparse, err := precheckRaw(ar.Request.Object.Raw); err == nil && !shouldMutateHPCPod(sparse) {
+			klog.V(2).Info("Pod does not require HPC mutations")
+			return &admissionv1.AdmissionResponse{
+				Allowed: true,
+			}
+		}
+	}
+
 	pod, err := extractPod(ar)
 	if err != nil {
 		klog.Error(err)
@@ -226,6 +485,8 @@ func mutateHPCPod(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
 	}
 
 	klog.V(2).Infof("Mutating HPC pod: %s/%s", pod.Namespace, pod.Name)
+	span.End()
+	_, span = tracing.StartSpan(ctx, tracingService, "patch")
 
 	// Apply HPC-specific mutations
 	mutatedPod := pod.DeepCopy()
@@ -0,0 +1,96 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+	"windows.k8s.io/webhook-common/matchconditions"
+	"windows.k8s.io/webhook-common/tracing"
+)
+
+// namespaceObjectGetter returns a lookup of the namespace with the in-cluster
+// client.
+func namespaceObjectGetter(ctx context.Context, clientset kubernetes.Interface, namespace string) func() (*corev1.Namespace, error) {
+	return func() (*corev1.Namespace, error) {
+		if clientset == nil {
+			return nil, fmt.Errorf("no client to read namespace %s", namespace)
+		}
+		ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting namespace %s: %w", namespace, err)
+		}
+		return ns, nil
+	}
+}
+
+// matchConditionConfig is the format of the --match-conditions-file.
+type matchConditionConfig struct {
+	MatchConditions []matchconditions.Condition `json:"matchConditions"`
+}
+
+func loadMatchConditionsFile(path string) ([]matchconditions.Compiled, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := matchConditionConfig{}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	conditions, err := matchconditions.Compile(cfg.MatchConditions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return conditions, nil
+}
+
+// withMatchConditions returns an admit function that only returns the patch
+// of admit if all conditions are true. Like the precheck, this keeps the
+// pods admit does not mutate cheap: the conditions are only evaluated for
+// pods with a patch. Pods are admitted unmodified if a condition cannot be
+// evaluated.
+func withMatchConditions(conditions []matchconditions.Compiled, clientset kubernetes.Interface, admit admitFunc) admitFunc {
+	return func(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		resp := admit(ctx, ar)
+		if resp == nil || len(resp.Patch) == 0 {
+			return resp
+		}
+		ctx, span := tracing.StartSpan(ctx, tracingService, "matchConditions")
+		defer span.End()
+		name, err := matchconditions.Eval(ctx, conditions, ar.Request, namespaceObjectGetter(ctx, clientset, ar.Request.Namespace))
+		if err != nil {
+			klog.Errorf("unable to evaluate match conditions, admitting pod %s/%s unmodified: %v", ar.Request.Namespace, ar.Request.Name, err)
+			return &admissionv1.AdmissionResponse{Allowed: true}
+		}
+		if name != "" {
+			klog.V(2).Infof("Pod %s/%s skipped by match condition %s", ar.Request.Namespace, ar.Request.Name, name)
+			span.SetAttributes(attribute.String("admission.skip_reason", "matchCondition:"+name))
+			return &admissionv1.AdmissionResponse{Allowed: true}
+		}
+		return resp
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func writeMatchConditions(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "conditions.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestLoadMatchConditionsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: "matchConditions:\n- {name: a, expression: 'true'}\n- {name: b, expression: 'object != null'}\n",
+			want:    2,
+		},
+		{
+			name:    "unknown field",
+			content: "conditions:\n- {name: a, expression: 'true'}\n",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "not bool",
+			content: "matchConditions:\n- {name: a, expression: '1 + 1'}\n",
+			wantErr: "must evaluate to bool",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conditions, err := loadMatchConditionsFile(writeMatchConditions(t, tc.content))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(conditions) != tc.want {
+				t.Errorf("expected %d conditions, got %d", tc.want, len(conditions))
+			}
+		})
+	}
+}
+
+func TestWithMatchConditions(t *testing.T) {
+	conditions, err := loadMatchConditionsFile(writeMatchConditions(t, `
+matchConditions:
+- name: not-opted-out
+  expression: "!has(object.metadata.labels) || !('example.com/no-hpc-wrapper' in object.metadata.labels)"
+- name: test-namespace
+  expression: "namespaceObject == null || (has(namespaceObject.metadata.labels) && 'e2e-run' in namespaceObject.metadata.labels)"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "e2e", Labels: map[string]string{"e2e-run": "1"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	)
+	pod := strings.Replace(hpcPod, `{"spec":`, `{"metadata":{"name":"hpc"},"spec":`, 1)
+	optedOut := strings.Replace(hpcPod, `{"spec":`, `{"metadata":{"name":"hpc","labels":{"example.com/no-hpc-wrapper":"true"}},"spec":`, 1)
+
+	tests := []struct {
+		name      string
+		pod       string
+		namespace string
+		clientset kubernetes.Interface
+		wantPatch bool
+	}{
+		{
+			name:      "all true",
+			pod:       pod,
+			namespace: "e2e",
+			clientset: clientset,
+			wantPatch: true,
+		},
+		{
+			name:      "label opt-out",
+			pod:       optedOut,
+			namespace: "e2e",
+			clientset: clientset,
+		},
+		{
+			name:      "namespace object",
+			pod:       pod,
+			namespace: "default",
+			clientset: clientset,
+		},
+		{
+			name:      "namespace lookup error",
+			pod:       pod,
+			namespace: "e2e",
+		},
+		{
+			name:      "not mutated",
+			pod:       e2ePod,
+			namespace: "e2e",
+			clientset: clientset,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ar := podReview(tc.pod)
+			ar.Request.Operation, ar.Request.Namespace = admissionv1.Create, tc.namespace
+			resp := withMatchConditions(conditions, tc.clientset, mutateHPCPod)(context.Background(), ar)
+			if !resp.Allowed || (resp.Patch != nil) != tc.wantPatch {
+				t.Errorf("expected allowed with patch %v, got %+v", tc.wantPatch, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// precheckPod mirrors the subset of the pod JSON that shouldMutateHPCPod
+// reads. Unmarshaling into it skips every other field, which is much cheaper
+// than decoding a full corev1.Pod.
+type precheckPod struct {
+	Spec struct {
+		HostNetwork     bool `json:"hostNetwork"`
+		SecurityContext *struct {
+			WindowsOptions *precheckWindowsOptions `json:"windowsOptions"`
+		} `json:"securityContext"`
+		Containers []precheckContainer `json:"containers"`
+	} `json:"spec"`
+}
+
+type precheckContainer struct {
+	Image           string   `json:"image"`
+	Command         []string `json:"command"`
+	SecurityContext *struct {
+		WindowsOptions *precheckWindowsOptions `json:"windowsOptions"`
+	} `json:"securityContext"`
+}
+
+type precheckWindowsOptions struct {
+	HostProcess *bool `json:"hostProcess"`
+}
+
+// precheckRaw decodes only the fields shouldMutateHPCPod needs from the raw
+// pod and returns them as a sparse Pod.
+func precheckRaw(rawObject []byte) (*corev1.Pod, error) {
+	p := &precheckPod{}
+	if err := json.Unmarshal(rawObject, p); err != nil {
+		return nil, err
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{HostNetwork: p.Spec.HostNetwork}}
+	if p.Spec.SecurityContext != nil && p.Spec.SecurityContext.WindowsOptions != nil {
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{
+			WindowsOptions: &corev1.WindowsSecurityContextOptions{HostProcess: p.Spec.SecurityContext.WindowsOptions.HostProcess},
+		}
+	}
+	if len(p.Spec.Containers) > 0 {
+		pod.Spec.Containers = make([]corev1.Container, len(p.Spec.Containers))
+		for i, c := range p.Spec.Containers {
+			pod.Spec.Containers[i].Image = c.Image
+			pod.Spec.Containers[i].Command = c.Command
+			if c.SecurityContext != nil && c.SecurityContext.WindowsOptions != nil {
+				pod.Spec.Containers[i].SecurityContext = &corev1.SecurityContext{
+					WindowsOptions: &corev1.WindowsSecurityContextOptions{HostProcess: c.SecurityContext.WindowsOptions.HostProcess},
+				}
+			}
+		}
+	}
+	return pod, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// e2ePod is shaped like the pods the e2e suite creates in bulk, with the env,
+// volumes and probes that make full decoding expensive. It is not an HPC pod.
+const e2ePod = `{
+  "apiVersion": "v1", "kind": "Pod",
+  "metadata": {"generateName": "netserver-", "namespace": "nettest-1234",
+    "labels": {"selector-0": "true", "pod-template-hash": "abc"}},
+  "spec": {
+    "nodeSelector": {"kubernetes.io/os": "windows"},
+    "containers": [{
+      "name": "webserver", "image": "registry.k8s.io/e2e-test-images/agnhost:2.47",
+      "args": ["netexec", "--http-port=8083", "--udp-port=8081"],
+      "env": [{"name": "A", "value": "1"}, {"name": "B", "value": "2"}, {"name": "C", "value": "3"},
+        {"name": "POD_IP", "valueFrom": {"fieldRef": {"fieldPath": "status.podIP"}}}],
+      "ports": [{"name": "http", "containerPort": 8083}, {"name": "udp", "containerPort": 8081, "protocol": "UDP"}],
+      "livenessProbe": {"httpGet": {"path": "/healthz", "port": 8083}, "initialDelaySeconds": 10, "periodSeconds": 10},
+      "readinessProbe": {"httpGet": {"path": "/healthz", "port": 8083}, "initialDelaySeconds": 10, "periodSeconds": 10},
+      "resources": {"requests": {"cpu": "100m", "memory": "64Mi"}, "limits": {"cpu": "200m", "memory": "128Mi"}},
+      "volumeMounts": [{"name": "kube-api-access", "mountPath": "/var/run/secrets/kubernetes.io/serviceaccount", "readOnly": true}]
+    }],
+    "volumes": [{"name": "kube-api-access", "projected": {"sources": [
+      {"serviceAccountToken": {"expirationSeconds": 3607, "path": "token"}},
+      {"configMap": {"name": "kube-root-ca.crt", "items": [{"key": "ca.crt", "path": "ca.crt"}]}},
+      {"downwardAPI": {"items": [{"path": "namespace", "fieldRef": {"apiVersion": "v1", "fieldPath": "metadata.namespace"}}]}}]}}],
+    "tolerations": [{"key": "node.kubernetes.io/not-ready", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 300}]
+  }
+}`
+
+const hpcPod = `{"spec":{"hostNetwork":true,"securityContext":{"windowsOptions":{"hostProcess":true}},` +
+	`"containers":[{"name":"c","image":"registry.k8s.io/e2e-test-images/agnhost:2.47","command":["agnhost"],"args":["netexec"]}]}}`
+
+func podReview(raw string) admissionv1.AdmissionReview {
+	return admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:   runtime.RawExtension{Raw: []byte(raw)},
+	}}
+}
+
+func TestPrecheckMatchesFullDecode(t *testing.T) {
+	pods := []string{
+		e2ePod,
+		hpcPod,
+		`{"spec":{"hostNetwork":true,"containers":[{"name":"c","image":"agnhost","securityContext":{"windowsOptions":{"hostProcess":true}}}]}}`,
+		`{"spec":{"hostNetwork":true,"securityContext":{"windowsOptions":{"hostProcess":true}},"containers":[{"name":"c","image":"agnhost","command":["pause"]}]}}`,
+		`{"spec":{"securityContext":{"windowsOptions":{"hostProcess":true}},"containers":[{"name":"c","image":"agnhost"}]}}`,
+		`{"metadata":{"name":"p"}}`,
+	}
+
+	for _, raw := range pods {
+		full := &corev1.Pod{}
+		if err := json.Unmarshal([]byte(raw), full); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sparse, err := precheckRaw([]byte(raw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := shouldMutateHPCPod(sparse), shouldMutateHPCPod(full); got != want {
+			t.Errorf("precheck decision %v, full decode %v for %s", got, want, raw)
+		}
+	}
+}
+
+func TestMutateHPCPodFastPath(t *testing.T) {
+	if resp := mutateHPCPod(context.Background(), podReview(e2ePod)); !resp.Allowed || resp.Patch != nil {
+		t.Errorf("expected non-HPC pod to be admitted unmodified, got %+v", resp)
+	}
+	if resp := mutateHPCPod(context.Background(), podReview(hpcPod)); !resp.Allowed || resp.Patch == nil {
+		t.Errorf("expected HPC pod to be patched, got %+v", resp)
+	}
+	if resp := mutateHPCPod(context.Background(), podReview(`{"spec":`)); resp.Allowed {
+		t.Error("expected malformed pod to be rejected")
+	}
+}
+
+// fullDecodeDecision is the decision path without the precheck, kept for
+// comparison in benchmarks.
+func fullDecodeDecision(ar admissionv1.AdmissionReview) bool {
+	pod, err := extractPod(ar)
+	if err != nil {
+		panic(err)
+	}
+	return shouldMutateHPCPod(pod)
+}
+
+func BenchmarkSkipDecision(b *testing.B) {
+	ar := podReview(e2ePod)
+
+	b.Run("precheck", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sparse, err := precheckRaw(ar.Request.Object.Raw)
+			if err != nil || shouldMutateHPCPod(sparse) {
+				b.Fatal("expected the pod to be skipped")
+			}
+		}
+	})
+	b.Run("full-decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if fullDecodeDecision(ar) {
+				b.Fatal("expected the pod to be skipped")
+			}
+		}
+	})
+}
+
+// BenchmarkMutateHPCPodUnderLoad runs skipped admissions in parallel and
+// reports the p99 latency next to the mean.
+func BenchmarkMutateHPCPodUnderLoad(b *testing.B) {
+	ar := podReview(e2ePod)
+
+	b.Run("precheck", func(b *testing.B) {
+		benchmarkP99(b, func() {
+			if resp := mutateHPCPod(context.Background(), ar); !resp.Allowed || resp.Patch != nil {
+				b.Fatal("expected the pod to be admitted unmodified")
+			}
+		})
+	})
+	b.Run("full-decode", func(b *testing.B) {
+		benchmarkP99(b, func() {
+			if fullDecodeDecision(ar) {
+				b.Fatal("expected the pod to be skipped")
+			}
+		})
+	})
+}
+
+func benchmarkP99(b *testing.B, fn func()) {
+	b.ReportAllocs()
+	var mu sync.Mutex
+	var latencies []time.Duration
+	b.RunParallel(func(pb *testing.PB) {
+		var local []time.Duration
+		for pb.Next() {
+			start := time.Now()
+			fn()
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+}
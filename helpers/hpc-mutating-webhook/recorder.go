@@ -0,0 +1,62 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog/v2"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// recorderWebhookName identifies records written by this webhook.
+const recorderWebhookName = "hpc"
+
+// recordingAdmit returns an admit function that records every request and
+// response of admit. Failing to record never fails the admission request.
+func recordingAdmit(r *recording.Recorder, admit admitFunc) admitFunc {
+	return func(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		resp, rec, err := admitRecorded(ctx, admit, ar)
+		if err == nil {
+			err = r.Record(rec)
+		}
+		if err != nil {
+			klog.Errorf("unable to record admission request %s: %v", ar.Request.UID, err)
+		}
+		return resp
+	}
+}
+
+// admitRecorded runs admit and returns its response with the unredacted
+// record of the request.
+func admitRecorded(ctx context.Context, admit admitFunc, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, recording.Record, error) {
+	start := time.Now()
+	resp := admit(ctx, ar)
+	rec := recording.Record{
+		Time:          start.UTC(),
+		Request:       ar.Request,
+		LatencyMicros: time.Since(start).Microseconds(),
+	}
+	if resp == nil {
+		return resp, rec, fmt.Errorf("admission handler returned nil response")
+	}
+	err := rec.SetResponse(resp, nil)
+	return resp, rec, err
+}
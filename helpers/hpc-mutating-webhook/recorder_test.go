@@ -0,0 +1,157 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+const hpcPodWithSecrets = `{"metadata":{"name":"hpc","namespace":"e2e"},"spec":{"hostNetwork":true,` +
+	`"securityContext":{"windowsOptions":{"hostProcess":true}},"containers":[{"name":"c",` +
+	`"image":"registry.k8s.io/e2e-test-images/agnhost:2.47","args":["netexec"],` +
+	`"env":[{"name":"PLAIN","value":"visible"},{"name":"API_TOKEN","value":"hunter2"}]}]}}`
+
+// recordReviews posts pods to a recording webhook and returns the recording
+// directory.
+func recordReviews(t *testing.T, redactEnv bool, pods ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	flags := &Flags{recordPath: dir + "/", recordMaxSizeMB: 1, recordMaxFiles: 2, recordRedactEnv: redactEnv, recordRedactPattern: recording.DefaultRedactPattern}
+	rec, err := flags.newRecorder()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rec.Out.Close()
+	srv := httptest.NewServer(newMux(nil, recordingAdmit(rec, mutateHPCPod)))
+	defer srv.Close()
+
+	for i, pod := range pods {
+		ar := podReview(pod)
+		ar.Request.UID = types.UID(string(rune('a' + i)))
+		ar.Request.Operation, ar.Request.Namespace = admissionv1.Create, "e2e"
+		body, _ := json.Marshal(ar)
+		resp, err := http.Post(srv.URL+"/mutate", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	}
+	return dir
+}
+
+func TestRecorder(t *testing.T) {
+	dir := recordReviews(t, false, hpcPodWithSecrets, e2ePod, `{"spec":"invalid"}`)
+
+	records, err := recording.Read([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []struct {
+		uid      types.UID
+		decision string
+	}{
+		{"a", recording.DecisionMutated},
+		{"b", recording.DecisionAdmitted},
+		{"c", recording.DecisionDenied},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(records))
+	}
+	for i, w := range want {
+		rec := records[i]
+		if rec.Webhook != recorderWebhookName || rec.Request.UID != w.uid || rec.Decision != w.decision {
+			t.Errorf("record %d: expected %s %s, got %s %s %s", i, w.uid, w.decision, rec.Webhook, rec.Request.UID, rec.Decision)
+		}
+	}
+	if len(records[0].Patch) == 0 {
+		t.Error("expected the response patch to be recorded")
+	}
+
+	object := records[0].Request.Object.Raw
+	if bytes.Contains(object, []byte("hunter2")) {
+		t.Errorf("expected API_TOKEN to be redacted: %s", object)
+	}
+	if !bytes.Contains(object, []byte("visible")) {
+		t.Errorf("expected PLAIN to be kept with --record-redact-env-values=false: %s", object)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := recordReviews(t, true, hpcPodWithSecrets, e2ePod)
+	path := filepath.Join(dir, "admissions.jsonl")
+
+	var out bytes.Buffer
+	if err := replay(t, &out, "--fail-on-diff", dir); err != nil {
+		t.Fatalf("expected an unchanged replay, got %v:\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "2 unchanged, 0 changed, 0 skipped") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+
+	// Pretend the first pod was admitted unmodified when it was recorded, and
+	// add a record of the Hyper-V webhook.
+	records, err := recording.Read([]string{path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records[0].Decision, records[0].Patch = recording.DecisionAdmitted, nil
+	records = append(records, recording.Record{Kind: recording.Kind, Webhook: "hyperv", Request: &admissionv1.AdmissionRequest{UID: "x"}})
+	var lines []byte
+	for _, rec := range records {
+		line, _ := json.Marshal(rec)
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := os.WriteFile(path, lines, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out.Reset()
+	if err := replay(t, &out, "--fail-on-diff", path); err == nil {
+		t.Error("expected the changed outcome to fail the replay")
+	}
+	for _, want := range []string{
+		"CHANGED a CREATE e2e/-",
+		"decision: admitted -> mutated",
+		"+ add /spec/containers/0/command",
+		"1 unchanged, 1 changed, 1 skipped",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected replay output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func replay(t *testing.T, out *bytes.Buffer, args ...string) error {
+	t.Helper()
+	app := newApp()
+	app.Writer = out
+	return app.Run(append([]string{"hpc-mutating-webhook", "replay"}, args...))
+}
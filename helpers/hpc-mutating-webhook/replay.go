@@ -0,0 +1,64 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// replayCommand feeds recorded admissions back through mutateHPCPod and
+// prints the requests whose outcome changed.
+func replayCommand() *cli.Command {
+	var failOnDiff bool
+	return &cli.Command{
+		Name:      "replay",
+		Usage:     "Replay recorded admissions through the current mutator and diff the outcomes.",
+		ArgsUsage: "RECORDING...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "fail-on-diff",
+				Usage:       "Exit with an error if any outcome changed.",
+				Destination: &failOnDiff,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("at least one recording is required")
+			}
+			records, err := recording.Read(c.Args().Slice())
+			if err != nil {
+				return err
+			}
+			results, skipped, err := recording.Replay(recorderWebhookName, records, func(req *admissionv1.AdmissionRequest) (recording.Record, error) {
+				_, rec, err := admitRecorded(context.Background(), mutateHPCPod, admissionv1.AdmissionReview{Request: req})
+				return rec, err
+			})
+			if err != nil {
+				return err
+			}
+			if changed := recording.PrintResults(c.App.Writer, results, skipped); changed > 0 && failOnDiff {
+				return fmt.Errorf("%d of %d replayed admissions changed", changed, len(results))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+	"windows.k8s.io/webhook-common/tracing"
+)
+
+// recordSpans installs a tracer provider recording every span in memory for
+// the duration of the test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	if _, err := tracing.Setup(context.Background(), tracingService, tracing.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanAttribute(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	parent, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		traceparent string
+		wantSpans   []string
+		wantPatch   bool
+	}{
+		{
+			name:        "mutated",
+			body:        hpcPod,
+			traceparent: traceparent,
+			wantSpans:   []string{"decode", "decide", "patch", "encode", "admission"},
+			wantPatch:   true,
+		},
+		{
+			name:        "not mutated",
+			body:        e2ePod,
+			traceparent: traceparent,
+			wantSpans:   []string{"decode", "decide", "encode", "admission"},
+		},
+		{
+			name:      "new trace",
+			body:      hpcPod,
+			wantSpans: []string{"decode", "decide", "patch", "encode", "admission"},
+			wantPatch: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := recordSpans(t)
+			ar := podReview(tc.body)
+			ar.Request.UID = "uid-1"
+			ar.Request.Operation, ar.Request.Namespace = admissionv1.Create, "e2e"
+			body, err := json.Marshal(ar)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.traceparent != "" {
+				r.Header.Set("traceparent", tc.traceparent)
+			}
+			w := httptest.NewRecorder()
+			newMux(nil, mutateHPCPod).ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			spans := exporter.GetSpans()
+			var names []string
+			for _, s := range spans {
+				names = append(names, s.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tc.wantSpans, ",") {
+				t.Fatalf("expected spans %v, got %v", tc.wantSpans, names)
+			}
+
+			root := spans[len(spans)-1]
+			for _, s := range spans[:len(spans)-1] {
+				if s.Parent.SpanID() != root.SpanContext.SpanID() {
+					t.Errorf("expected span %s to be a child of the admission span", s.Name)
+				}
+			}
+			if tc.traceparent != "" {
+				if root.SpanContext.TraceID() != parent || !root.Parent.IsRemote() {
+					t.Errorf("expected the admission span to continue trace %s, got %s", parent, root.SpanContext.TraceID())
+				}
+			} else if root.Parent.IsValid() {
+				t.Errorf("expected a new trace, got parent %v", root.Parent)
+			}
+			if root.SpanKind != trace.SpanKindServer {
+				t.Errorf("expected a server span, got %v", root.SpanKind)
+			}
+			if got := spanAttribute(root, "admission.uid").AsString(); got != "uid-1" {
+				t.Errorf("expected uid attribute uid-1, got %q", got)
+			}
+			if !spanAttribute(root, "admission.allowed").AsBool() {
+				t.Errorf("expected the request to be allowed")
+			}
+			if got := spanAttribute(root, "admission.patch_bytes").AsInt64() > 0; got != tc.wantPatch {
+				t.Errorf("expected patch %v, got %v", tc.wantPatch, got)
+			}
+		})
+	}
+}
+
+func TestTracingMalformedRequest(t *testing.T) {
+	exporter := recordSpans(t)
+	r := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("{"))
+	r.Header.Set("Content-Type", "application/json")
+	newMux(nil, mutateHPCPod).ServeHTTP(httptest.NewRecorder(), r)
+
+	var names []string
+	for _, s := range exporter.GetSpans() {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "decode,admission" {
+		t.Errorf("expected spans [decode admission], got %v", names)
+	}
+}
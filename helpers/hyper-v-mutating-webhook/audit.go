@@ -0,0 +1,307 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// driftConditionType is the pod condition set by the drift auditor. It is
+	// True while the pod's isolation does not match the webhook policy.
+	driftConditionType corev1.PodConditionType = "HyperVIsolationDrift"
+
+	// Drift reasons, used for the condition, Events and metrics.
+	driftMissingHyperV    = "MissingHyperVIsolation"
+	driftUnexpectedHyperV = "UnexpectedHyperVIsolation"
+	driftNone             = "IsolationMatchesPolicy"
+)
+
+var (
+	auditLogger = ctrl.Log.WithName("drift-audit")
+
+	driftPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hyperv_webhook_isolation_drift_pods",
+			Help: "Windows pods whose isolation does not match the webhook policy at the last audit summary, by reason.",
+		},
+		[]string{"reason"},
+	)
+	auditedPods = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hyperv_webhook_audited_windows_pods",
+			Help: "Windows pods considered at the last audit summary.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftPods, auditedPods)
+}
+
+// driftAuditor compares the isolation of running Windows pods with the
+// decision the webhook would make for them today. Pods created while the
+// webhook was unavailable (failurePolicy bypass, startup races) end up without
+// Hyper-V isolation; pods created under an older policy may have it when they
+// should not. Findings are reported as a pod condition, an Event and a
+// periodic summary.
+type driftAuditor struct {
+	Client   client.Client
+	Recorder events.EventRecorder
+	// Updater supplies the policy the pods are checked against.
+	Updater *podUpdater
+	// IgnoredNamespaces lists namespaces that are not audited, typically the
+	// ones excluded by the MutatingWebhookConfiguration namespaceSelector.
+	IgnoredNamespaces map[string]struct{}
+	// Interval is how often the summary is logged and the metrics updated.
+	Interval time.Duration
+}
+
+// auditFinding is the outcome of auditing a single pod.
+type auditFinding struct {
+	// Reason is one of the drift reasons, or driftNone.
+	Reason  string
+	Message string
+}
+
+// SetupWithManager registers the pod controller and the periodic summary.
+func (a *driftAuditor) SetupWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewControllerManagedBy(mgr).
+		Named("hyperv-drift-audit").
+		For(&corev1.Pod{}).
+		Complete(a); err != nil {
+		return err
+	}
+	return mgr.Add(a)
+}
+
+// Reconcile audits a single pod and records drift on it.
+func (a *driftAuditor) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pod := &corev1.Pod{}
+	if err := a.Client.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	finding, audited, err := a.audit(ctx, pod)
+	if err != nil || !audited {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, a.record(ctx, pod, finding)
+}
+
+// audit returns the finding for the pod. audited is false for pods outside the
+// audit's scope: unscheduled or terminating pods, pods in ignored namespaces,
+// pods on non-Windows nodes, pods using a different runtime class and pods
+// the policy cannot be evaluated for.
+func (a *driftAuditor) audit(ctx context.Context, pod *corev1.Pod) (finding auditFinding, audited bool, err error) {
+	if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+		return auditFinding{}, false, nil
+	}
+	if _, ok := a.IgnoredNamespaces[pod.Namespace]; ok {
+		return auditFinding{}, false, nil
+	}
+
+	node := &corev1.Node{}
+	if err := a.Client.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return auditFinding{}, false, nil
+		}
+		return auditFinding{}, false, err
+	}
+	if node.Labels[corev1.LabelOSStable] != "windows" {
+		return auditFinding{}, false, nil
+	}
+
+	current := ""
+	if pod.Spec.RuntimeClassName != nil {
+		current = *pod.Spec.RuntimeClassName
+	}
+	// The webhook never replaces a runtime class set by the user.
+	if current != "" && current != runtimeClassName {
+		return auditFinding{}, false, nil
+	}
+	hasHyperV := current == runtimeClassName
+
+	// The decision is not observed: auditing a pod is not an admission and
+	// must not count rule matches or match condition skips.
+	var decision skipDecision
+	if _, ok := a.Updater.ExcludedNamespaces[pod.Namespace]; ok {
+		decision = skipDecision{reason: skipExcludedNamespace}
+	} else {
+		decision = a.Updater.decideSkip(ctx, pod)
+	}
+	if decision.reason == "" {
+		// Match conditions see the running pod, as if it was being created.
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			return auditFinding{}, false, err
+		}
+		decision = a.Updater.decideConditions(ctx, &admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		})
+	}
+	if decision.err != nil {
+		// The webhook would fail open, which says nothing about the policy.
+		auditLogger.V(1).Info("unable to decide the isolation of pod, not auditing it",
+			"pod", client.ObjectKeyFromObject(pod), "reason", decision.reason, "error", decision.err.Error())
+		return auditFinding{}, false, nil
+	}
+	reason := decision.reason
+
+	switch {
+	case reason == "" && !hasHyperV:
+		return auditFinding{
+			Reason:  driftMissingHyperV,
+			Message: fmt.Sprintf("Pod should run with runtime class %s but has none", runtimeClassName),
+		}, true, nil
+	case reason != "" && hasHyperV:
+		return auditFinding{
+			Reason:  driftUnexpectedHyperV,
+			Message: fmt.Sprintf("Pod runs with runtime class %s but the webhook would skip it (%s)", runtimeClassName, reason),
+		}, true, nil
+	}
+	return auditFinding{Reason: driftNone}, true, nil
+}
+
+// record sets the drift condition on the pod and emits an Event when the
+// pod starts drifting. Pods without drift only get a condition when one was
+// previously set.
+func (a *driftAuditor) record(ctx context.Context, pod *corev1.Pod, finding auditFinding) error {
+	drifting := finding.Reason != driftNone
+	status := corev1.ConditionFalse
+	if drifting {
+		status = corev1.ConditionTrue
+	}
+
+	var existing *corev1.PodCondition
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == driftConditionType {
+			existing = &pod.Status.Conditions[i]
+		}
+	}
+	if existing == nil && !drifting {
+		return nil
+	}
+	if existing != nil && existing.Status == status && existing.Reason == finding.Reason {
+		return nil
+	}
+
+	condition := corev1.PodCondition{
+		Type:               driftConditionType,
+		Status:             status,
+		Reason:             finding.Reason,
+		Message:            finding.Message,
+		LastTransitionTime: metav1.Now(),
+	}
+	patch := client.StrategicMergeFrom(pod.DeepCopy())
+	if existing != nil {
+		*existing = condition
+	} else {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+	if err := a.Client.Status().Patch(ctx, pod, patch); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if drifting {
+		auditLogger.Info("pod isolation drift", "pod", client.ObjectKeyFromObject(pod), "reason", finding.Reason)
+		if a.Recorder != nil {
+			a.Recorder.Eventf(pod, nil, corev1.EventTypeWarning, finding.Reason, "Audit", "%s", finding.Message)
+		}
+	}
+	return nil
+}
+
+// driftSummary counts the audited Windows pods by finding.
+type driftSummary struct {
+	Audited int
+	// Drift maps drift reasons to the affected pods.
+	Drift map[string][]string
+}
+
+// summarize audits every pod in the cache.
+func (a *driftAuditor) summarize(ctx context.Context) (driftSummary, error) {
+	pods := &corev1.PodList{}
+	if err := a.Client.List(ctx, pods); err != nil {
+		return driftSummary{}, err
+	}
+
+	summary := driftSummary{Drift: map[string][]string{}}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		finding, audited, err := a.audit(ctx, pod)
+		if err != nil {
+			return driftSummary{}, err
+		}
+		if !audited {
+			continue
+		}
+		summary.Audited++
+		if finding.Reason != driftNone {
+			summary.Drift[finding.Reason] = append(summary.Drift[finding.Reason], client.ObjectKeyFromObject(pod).String())
+		}
+	}
+	return summary, nil
+}
+
+// Start logs a drift summary and updates the drift metrics every Interval
+// until the context is cancelled. It only runs on the leader.
+func (a *driftAuditor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		summary, err := a.summarize(ctx)
+		if err != nil {
+			auditLogger.Error(err, "unable to summarize isolation drift")
+			continue
+		}
+		auditedPods.Set(float64(summary.Audited))
+		for _, reason := range []string{driftMissingHyperV, driftUnexpectedHyperV} {
+			driftPods.WithLabelValues(reason).Set(float64(len(summary.Drift[reason])))
+		}
+		auditLogger.Info("isolation drift summary",
+			"audited", summary.Audited,
+			"missingHyperV", len(summary.Drift[driftMissingHyperV]),
+			"unexpectedHyperV", len(summary.Drift[driftUnexpectedHyperV]),
+			"pods", summary.Drift)
+	}
+}
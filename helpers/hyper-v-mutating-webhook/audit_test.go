@@ -0,0 +1,231 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"windows.k8s.io/webhook-common/matchconditions"
+)
+
+func auditPod(name, namespace, node, runtimeClass string, hostNetwork bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.PodSpec{
+			NodeName:    node,
+			HostNetwork: hostNetwork,
+			Containers:  []corev1.Container{{Name: "c", Image: "busybox"}},
+		},
+	}
+	if runtimeClass != "" {
+		pod.Spec.RuntimeClassName = &runtimeClass
+	}
+	return pod
+}
+
+func TestDriftAudit(t *testing.T) {
+	linuxNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "linux",
+		Labels: map[string]string{"kubernetes.io/os": "linux"},
+	}}
+
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		wantAudited bool
+		wantReason  string
+	}{
+		{
+			name:        "missing hyper-v",
+			pod:         auditPod("p1", "default", "win", "", false),
+			wantAudited: true,
+			wantReason:  driftMissingHyperV,
+		},
+		{
+			name:        "unexpected hyper-v",
+			pod:         auditPod("p2", "default", "win", runtimeClassName, true),
+			wantAudited: true,
+			wantReason:  driftUnexpectedHyperV,
+		},
+		{
+			name:        "matches policy",
+			pod:         auditPod("p3", "default", "win", runtimeClassName, false),
+			wantAudited: true,
+			wantReason:  driftNone,
+		},
+		{
+			name:        "excluded namespace without hyper-v",
+			pod:         auditPod("p4", "excluded", "win", "", false),
+			wantAudited: true,
+			wantReason:  driftNone,
+		},
+		{
+			name: "ignored namespace",
+			pod:  auditPod("p5", "kube-system", "win", "", false),
+		},
+		{
+			name: "linux node",
+			pod:  auditPod("p6", "default", "linux", "", false),
+		},
+		{
+			name: "unscheduled",
+			pod:  auditPod("p7", "default", "", "", false),
+		},
+		{
+			name: "other runtime class",
+			pod:  auditPod("p8", "default", "win", "runhcs-wcow-process", false),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().
+				WithObjects(windowsNode("win", "10.0.20348"), linuxNode, tc.pod).
+				WithStatusSubresource(&corev1.Pod{}).
+				Build()
+			recorder := events.NewFakeRecorder(10)
+			a := &driftAuditor{
+				Client:            c,
+				Recorder:          recorder,
+				Updater:           &podUpdater{ExcludedNamespaces: parseList("excluded")},
+				IgnoredNamespaces: parseList("kube-system"),
+			}
+
+			finding, audited, err := a.audit(context.Background(), tc.pod)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if audited != tc.wantAudited {
+				t.Fatalf("audited = %v, want %v", audited, tc.wantAudited)
+			}
+			if audited && finding.Reason != tc.wantReason {
+				t.Errorf("reason = %q, want %q", finding.Reason, tc.wantReason)
+			}
+
+			key := client.ObjectKeyFromObject(tc.pod)
+			if _, err := a.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := &corev1.Pod{}
+			if err := c.Get(context.Background(), key, got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var cond *corev1.PodCondition
+			for i := range got.Status.Conditions {
+				if got.Status.Conditions[i].Type == driftConditionType {
+					cond = &got.Status.Conditions[i]
+				}
+			}
+			drifting := audited && tc.wantReason != driftNone
+			if !drifting {
+				if cond != nil {
+					t.Errorf("expected no drift condition, got %+v", cond)
+				}
+				if len(recorder.Events) != 0 {
+					t.Errorf("expected no events, got %q", <-recorder.Events)
+				}
+				return
+			}
+			if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != tc.wantReason {
+				t.Fatalf("expected a true %s condition with reason %s, got %+v", driftConditionType, tc.wantReason, cond)
+			}
+			if len(recorder.Events) != 1 {
+				t.Fatalf("expected one event, got %d", len(recorder.Events))
+			}
+			if ev := <-recorder.Events; !strings.Contains(ev, "Warning "+tc.wantReason) {
+				t.Errorf("unexpected event %q", ev)
+			}
+
+			// Auditing the pod again does not emit another event.
+			if _, err := a.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(recorder.Events) != 0 {
+				t.Errorf("expected no repeated events, got %q", <-recorder.Events)
+			}
+		})
+	}
+}
+
+func TestDriftSummary(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		windowsNode("win", "10.0.20348"),
+		auditPod("missing", "default", "win", "", false),
+		auditPod("unexpected", "default", "win", runtimeClassName, true),
+		auditPod("ok", "default", "win", runtimeClassName, false),
+		auditPod("pending", "default", "", "", false),
+	).Build()
+	a := &driftAuditor{Client: c, Updater: &podUpdater{}}
+
+	summary, err := a.summarize(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Audited != 3 {
+		t.Errorf("expected 3 audited pods, got %d", summary.Audited)
+	}
+	if got := summary.Drift[driftMissingHyperV]; len(got) != 1 || got[0] != "default/missing" {
+		t.Errorf("unexpected missing pods %v", got)
+	}
+	if got := summary.Drift[driftUnexpectedHyperV]; len(got) != 1 || got[0] != "default/unexpected" {
+		t.Errorf("unexpected unexpected pods %v", got)
+	}
+}
+
+func TestDriftAuditIsNotObserved(t *testing.T) {
+	conditions, err := matchconditions.Compile([]matchconditions.Condition{{Name: "not-skipped", Expression: "object.metadata.name != 'skipped'"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ruled := auditPod("ruled", "default", "win", "", false)
+	ruled.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+	skipped := auditPod("skipped", "default", "win", "", false)
+
+	c := fake.NewClientBuilder().WithObjects(windowsNode("win", "10.0.20348"), ruled, skipped).Build()
+	a := &driftAuditor{
+		Client:  c,
+		Updater: &podUpdater{Rules: &ruleSet{rules: defaultRuleSet.rules, conditions: conditions}},
+	}
+
+	rulesBefore := testutil.ToFloat64(ruleMatches.WithLabelValues("custom-node-selector", ruleActionSkip))
+	conditionsBefore := testutil.ToFloat64(matchConditionSkips.WithLabelValues("not-skipped"))
+	for _, pod := range []*corev1.Pod{ruled, skipped} {
+		finding, audited, err := a.audit(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !audited || finding.Reason != driftNone {
+			t.Errorf("expected pod %s to match the policy, got %t %q", pod.Name, audited, finding.Reason)
+		}
+	}
+	if after := testutil.ToFloat64(ruleMatches.WithLabelValues("custom-node-selector", ruleActionSkip)); after != rulesBefore {
+		t.Errorf("expected the audit not to count rule matches, got %v -> %v", rulesBefore, after)
+	}
+	if after := testutil.ToFloat64(matchConditionSkips.WithLabelValues("not-skipped")); after != conditionsBefore {
+		t.Errorf("expected the audit not to count match condition skips, got %v -> %v", conditionsBefore, after)
+	}
+}
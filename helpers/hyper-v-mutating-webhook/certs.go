@@ -0,0 +1,183 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"windows.k8s.io/webhook-common/certs"
+)
+
+var certLogger = ctrl.Log.WithName("certs")
+
+// selfManagedCertOptions configures the built-in certificate management used
+// when cert-manager is not installed.
+type selfManagedCertOptions struct {
+	certs.Options
+}
+
+func (o *selfManagedCertOptions) secretKey() types.NamespacedName {
+	return types.NamespacedName{Namespace: o.SecretNamespace, Name: o.SecretName}
+}
+
+// certLoader keeps the certs.Store of every replica in sync with the Secret
+// maintained by the leader's certRotator.
+type certLoader struct {
+	Reader   client.Reader
+	Key      types.NamespacedName
+	Store    *certs.Store
+	Interval time.Duration
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (l *certLoader) NeedLeaderElection() bool { return false }
+
+// Start implements manager.Runnable.
+func (l *certLoader) Start(ctx context.Context) error {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+	for {
+		if err := l.load(ctx); err != nil {
+			certLogger.Info("serving certificate not available", "secret", l.Key, "reason", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *certLoader) load(ctx context.Context) error {
+	secret := &corev1.Secret{}
+	if err := l.Reader.Get(ctx, l.Key, secret); err != nil {
+		return err
+	}
+	changed, err := l.Store.Set(secret.Data)
+	if changed {
+		certLogger.Info("loaded serving certificate")
+	}
+	return err
+}
+
+// certRotator runs on the leader only. It creates the CA and serving
+// certificate, rotates them before they expire and keeps the caBundle of the
+// webhook's MutatingWebhookConfiguration up to date.
+type certRotator struct {
+	Client  client.Client
+	Reader  client.Reader
+	Options selfManagedCertOptions
+	now     func() time.Time
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *certRotator) NeedLeaderElection() bool { return true }
+
+// Start implements manager.Runnable.
+func (r *certRotator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.Options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.reconcile(ctx); err != nil {
+			certLogger.Error(err, "unable to reconcile webhook certificates")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *certRotator) reconcile(ctx context.Context) error {
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Reader.Get(ctx, r.Options.secretKey(), secret)
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("getting secret %s: %w", r.Options.secretKey(), err)
+	}
+
+	// The caBundle is read first, so a new CA is only used for the serving
+	// certificate once the apiserver trusts it.
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	mwcErr := r.Reader.Get(ctx, types.NamespacedName{Name: r.Options.WebhookConfigName}, mwc)
+	var trusted [][]byte
+	if mwcErr == nil {
+		for _, wh := range mwc.Webhooks {
+			trusted = append(trusted, wh.ClientConfig.CABundle)
+		}
+	}
+
+	data, changed, err := certs.Ensure(secret.Data, trusted, &r.Options.Options, now)
+	if err != nil {
+		return err
+	}
+	if changed {
+		secret.Data = data
+		if !exists {
+			secret.ObjectMeta = metav1.ObjectMeta{Name: r.Options.SecretName, Namespace: r.Options.SecretNamespace}
+			secret.Type = corev1.SecretTypeTLS
+			if err := r.Client.Create(ctx, secret); err != nil {
+				return fmt.Errorf("creating secret %s: %w", r.Options.secretKey(), err)
+			}
+		} else if err := r.Client.Update(ctx, secret); err != nil {
+			return fmt.Errorf("updating secret %s: %w", r.Options.secretKey(), err)
+		}
+		certLogger.Info("stored rotated webhook certificates", "secret", r.Options.secretKey())
+	}
+
+	if mwcErr != nil {
+		return fmt.Errorf("getting MutatingWebhookConfiguration %s: %w", r.Options.WebhookConfigName, mwcErr)
+	}
+	return r.injectCABundle(ctx, mwc, data[certs.CABundleKey])
+}
+
+// injectCABundle sets caBundle on every webhook of the configuration. It is
+// reapplied on every sync so a helm upgrade that resets the field heals.
+func (r *certRotator) injectCABundle(ctx context.Context, mwc *admissionregistrationv1.MutatingWebhookConfiguration, bundle []byte) error {
+	patch := client.MergeFrom(mwc.DeepCopy())
+	changed := false
+	for i := range mwc.Webhooks {
+		if !bytes.Equal(mwc.Webhooks[i].ClientConfig.CABundle, bundle) {
+			mwc.Webhooks[i].ClientConfig.CABundle = bundle
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.Client.Patch(ctx, mwc, patch); err != nil {
+		return fmt.Errorf("patching caBundle of MutatingWebhookConfiguration %s: %w", r.Options.WebhookConfigName, err)
+	}
+	certLogger.Info("injected caBundle", "mutatingwebhookconfiguration", r.Options.WebhookConfigName)
+	return nil
+}
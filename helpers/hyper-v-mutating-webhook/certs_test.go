@@ -0,0 +1,129 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"windows.k8s.io/webhook-common/certs"
+)
+
+const day = 24 * time.Hour
+
+func testCertOptions() selfManagedCertOptions {
+	return selfManagedCertOptions{Options: certs.Options{
+		SecretName:        "hyperv-webhook-tls",
+		SecretNamespace:   "hyperv-webhook",
+		WebhookConfigName: "hyperv-webhook",
+		ServiceName:       "hyperv-webhook",
+		CAValidity:        100 * day,
+		CertValidity:      10 * day,
+		RefreshBefore:     2 * day,
+		SyncInterval:      time.Minute,
+	}}
+}
+
+// verifyServing checks that the serving certificate in data chains to the CA
+// bundle for the Service DNS name.
+func verifyServing(t *testing.T, data map[string][]byte, now time.Time) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data[certs.CABundleKey]) {
+		t.Fatalf("no CA certificates in bundle")
+	}
+	serving := certs.ValidCerts(data[corev1.TLSCertKey], now)
+	if len(serving) != 1 {
+		t.Fatalf("expected one serving certificate, got %d", len(serving))
+	}
+	if _, err := serving[0].Verify(x509.VerifyOptions{
+		DNSName:     "hyperv-webhook.hyperv-webhook.svc",
+		Roots:       roots,
+		CurrentTime: now,
+	}); err != nil {
+		t.Fatalf("serving certificate does not verify: %v", err)
+	}
+}
+
+func TestCertRotatorReconcile(t *testing.T) {
+	o := testCertOptions()
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: o.WebhookConfigName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "hyperv.windows.k8s.io"},
+		},
+	}
+	c := fake.NewClientBuilder().WithObjects(mwc).Build()
+	r := &certRotator{Client: c, Reader: c, Options: o}
+
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), o.secretKey(), secret); err != nil {
+		t.Fatalf("expected secret to be created: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("expected secret type %s, got %s", corev1.SecretTypeTLS, secret.Type)
+	}
+	verifyServing(t, secret.Data, time.Now())
+
+	got := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: o.WebhookConfigName}, got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got.Webhooks[0].ClientConfig.CABundle, secret.Data[certs.CABundleKey]) {
+		t.Error("expected caBundle to be injected")
+	}
+
+	// A second pass does not touch the stored certificates.
+	version := secret.ResourceVersion
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Get(context.Background(), o.secretKey(), secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.ResourceVersion != version {
+		t.Error("expected secret to be unchanged on a second reconcile")
+	}
+
+	// The loader serves whatever the rotator stored.
+	store := &certs.Store{}
+	if err := store.Ready(); err == nil {
+		t.Error("expected Ready to fail before a certificate is loaded")
+	}
+	loader := &certLoader{Reader: c, Key: o.secretKey(), Store: store}
+	if err := loader.load(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Ready(); err != nil {
+		t.Errorf("expected Ready to pass: %v", err)
+	}
+	if cert, err := store.GetCertificate(nil); err != nil || cert == nil {
+		t.Errorf("expected a serving certificate, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// isolationModeAlways injects the hyper-v runtime class into every pod
+	// accepted by shouldMutatePod.
+	isolationModeAlways = "always"
+	// isolationModeCompatibility only injects the hyper-v runtime class when
+	// process isolation would be incompatible with an eligible node.
+	isolationModeCompatibility = "compatibility"
+
+	// windowsBuildLabel is set by the kubelet on Windows nodes to the
+	// major.minor.build version of the host OS (e.g. 10.0.20348).
+	windowsBuildLabel = "node.kubernetes.io/windows-build"
+)
+
+// imageOSVersionResolver returns the Windows os.version values an image
+// reference can run as. Multi-arch indexes may carry several Windows entries.
+type imageOSVersionResolver interface {
+	ResolveOSVersions(ctx context.Context, image string) ([]string, error)
+}
+
+// registryResolver resolves image os.version values from the image manifest
+// (or manifest list) served by the registry.
+type registryResolver struct {
+	// timeout bounds each lookup. It must stay well below the webhook
+	// timeoutSeconds, otherwise a slow registry makes the API server reject
+	// the pod under failurePolicy: Fail instead of the webhook failing open.
+	timeout time.Duration
+	options []remote.Option
+}
+
+func newRegistryResolver(timeout time.Duration, options ...remote.Option) *registryResolver {
+	return &registryResolver{
+		timeout: timeout,
+		options: append([]remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}, options...),
+	}
+}
+
+func (r *registryResolver) ResolveOSVersions(ctx context.Context, image string) ([]string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image reference %q: %w", image, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	desc, err := remote.Get(ref, append([]remote.Option{remote.WithContext(ctx)}, r.options...)...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest for %q: %w", image, err)
+	}
+
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, fmt.Errorf("reading index for %q: %w", image, err)
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("reading index manifest for %q: %w", image, err)
+		}
+		var versions []string
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil && m.Platform.OS == "windows" && m.Platform.OSVersion != "" {
+				versions = append(versions, m.Platform.OSVersion)
+			}
+		}
+		return versions, nil
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("reading image for %q: %w", image, err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("reading config for %q: %w", image, err)
+	}
+	if cfg.OS != "windows" || cfg.OSVersion == "" {
+		return nil, nil
+	}
+	return []string{cfg.OSVersion}, nil
+}
+
+type cachedVersions struct {
+	versions []string
+	err      error
+}
+
+// clockFunc adapts a time source to the clock of the LRU cache.
+type clockFunc func() time.Time
+
+func (f clockFunc) Now() time.Time { return f() }
+
+// cachingResolver memoizes lookups of the wrapped resolver, successful ones
+// for ttl and failed ones for errorTTL, keeping at most size images. The
+// short negative cache keeps a registry outage from costing every pod a
+// lookup timeout, while still retrying soon after the registry recovers.
+// Concurrent misses for the same image share one lookup.
+type cachingResolver struct {
+	resolver imageOSVersionResolver
+	ttl      time.Duration
+	errorTTL time.Duration
+	now      func() time.Time
+
+	entries *cache.LRUExpireCache
+	lookups singleflight.Group
+}
+
+func newCachingResolver(resolver imageOSVersionResolver, size int, ttl, errorTTL time.Duration) *cachingResolver {
+	c := &cachingResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		errorTTL: errorTTL,
+		now:      time.Now,
+	}
+	c.entries = cache.NewLRUExpireCacheWithClock(size, clockFunc(func() time.Time { return c.now() }))
+	return c
+}
+
+func (c *cachingResolver) ResolveOSVersions(ctx context.Context, image string) ([]string, error) {
+	if entry, ok := c.entries.Get(image); ok {
+		return entry.(cachedVersions).versions, entry.(cachedVersions).err
+	}
+
+	result := c.lookups.DoChan(image, func() (interface{}, error) {
+		// The lookup is shared by every pod waiting for the image, so it
+		// must not be canceled with the request that started it. The wrapped
+		// resolver bounds it with its own timeout.
+		versions, err := c.resolver.ResolveOSVersions(context.WithoutCancel(ctx), image)
+		if err != nil {
+			c.entries.Add(image, cachedVersions{err: err}, c.errorTTL)
+		} else {
+			c.entries.Add(image, cachedVersions{versions: versions}, c.ttl)
+		}
+		return versions, err
+	})
+	select {
+	case r := <-result:
+		versions, _ := r.Val.([]string)
+		return versions, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// compatibilityChecker decides whether a pod needs Hyper-V isolation because
+// at least one of its images cannot run process-isolated on an eligible node.
+type compatibilityChecker struct {
+	Client   client.Reader
+	Resolver imageOSVersionResolver
+}
+
+// requiresHyperV reports whether any container image of the pod has a Windows
+// build that differs from the build of any Windows node the pod could be
+// scheduled to. Eligible nodes are Windows nodes matching the pod's
+// nodeSelector; affinity is not evaluated. An error is returned when the
+// decision cannot be made, so the caller can fail open.
+func (cc *compatibilityChecker) requiresHyperV(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	nodeBuilds, err := cc.eligibleNodeBuilds(ctx, pod)
+	if err != nil {
+		return false, err
+	}
+	if len(nodeBuilds) == 0 {
+		return false, fmt.Errorf("no eligible Windows nodes with a %s label", windowsBuildLabel)
+	}
+
+	for _, image := range podImages(pod) {
+		versions, err := cc.Resolver.ResolveOSVersions(ctx, image)
+		if err != nil {
+			return false, err
+		}
+		// Images without Windows entries are not Windows images; they will
+		// not run on a Windows node under either isolation mode.
+		if len(versions) == 0 {
+			continue
+		}
+		for build := range nodeBuilds {
+			if !buildsCompatible(versions, build) {
+				webhookLogger.V(1).Info("image requires Hyper-V isolation",
+					"image", image, "osVersions", versions, "nodeBuild", build)
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// eligibleNodeBuilds returns the set of Windows builds of the nodes the pod
+// could be scheduled to.
+func (cc *compatibilityChecker) eligibleNodeBuilds(ctx context.Context, pod *corev1.Pod) (map[string]struct{}, error) {
+	selector := labels.Set{"kubernetes.io/os": "windows"}
+	for k, v := range pod.Spec.NodeSelector {
+		selector[k] = v
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := cc.Client.List(ctx, nodes, client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(selector)}); err != nil {
+		return nil, fmt.Errorf("listing Windows nodes: %w", err)
+	}
+
+	builds := map[string]struct{}{}
+	for _, n := range nodes.Items {
+		if b := n.Labels[windowsBuildLabel]; b != "" {
+			builds[b] = struct{}{}
+		}
+	}
+	return builds, nil
+}
+
+// buildsCompatible reports whether one of the image os.versions can run
+// process-isolated on a host with the given build. Process isolation requires
+// the major.minor.build of the container and host to match.
+func buildsCompatible(imageVersions []string, nodeBuild string) bool {
+	for _, v := range imageVersions {
+		if majorMinorBuild(v) == majorMinorBuild(nodeBuild) {
+			return true
+		}
+	}
+	return false
+}
+
+// majorMinorBuild truncates a Windows version such as 10.0.20348.2227 to
+// 10.0.20348.
+func majorMinorBuild(version string) string {
+	parts := strings.SplitN(version, ".", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return strings.Join(parts, ".")
+}
+
+func podImages(pod *corev1.Pod) []string {
+	var images []string
+	for _, c := range pod.Spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	for _, c := range pod.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
@@ -0,0 +1,380 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestRegistry starts an in-memory registry and returns its host:port.
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func windowsImage(t *testing.T, osVersion string) v1.Image {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("creating random image: %v", err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("reading config: %v", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS = "windows"
+	cfg.Architecture = "amd64"
+	cfg.OSVersion = osVersion
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatalf("mutating config: %v", err)
+	}
+	return img
+}
+
+func pushImage(t *testing.T, reg, repo, osVersion string) string {
+	t.Helper()
+	ref, err := name.ParseReference(reg + "/" + repo)
+	if err != nil {
+		t.Fatalf("parsing reference: %v", err)
+	}
+	if err := remote.Write(ref, windowsImage(t, osVersion)); err != nil {
+		t.Fatalf("pushing image: %v", err)
+	}
+	return ref.String()
+}
+
+func pushIndex(t *testing.T, reg, repo string, osVersions ...string) string {
+	t.Helper()
+	var idx v1.ImageIndex = empty.Index
+	for _, v := range osVersions {
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: windowsImage(t, v),
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: v},
+			},
+		})
+	}
+	ref, err := name.ParseReference(reg + "/" + repo)
+	if err != nil {
+		t.Fatalf("parsing reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("pushing index: %v", err)
+	}
+	return ref.String()
+}
+
+func windowsNode(name, build string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: name,
+		Labels: map[string]string{
+			"kubernetes.io/os": "windows",
+			windowsBuildLabel:  build,
+		},
+	}}
+}
+
+func podWithImages(images ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p1"}}
+	for _, img := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "c", Image: img})
+	}
+	return pod
+}
+
+func TestRegistryResolver(t *testing.T) {
+	reg := newTestRegistry(t)
+	resolver := newRegistryResolver(5 * time.Second)
+
+	t.Run("single image", func(t *testing.T) {
+		img := pushImage(t, reg, "single:ltsc2022", "10.0.20348.2227")
+		got, err := resolver.ResolveOSVersions(context.Background(), img)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 1 || got[0] != "10.0.20348.2227" {
+			t.Errorf("expected [10.0.20348.2227], got %v", got)
+		}
+	})
+
+	t.Run("manifest list", func(t *testing.T) {
+		img := pushIndex(t, reg, "multi:latest", "10.0.17763.5820", "10.0.20348.2227")
+		got, err := resolver.ResolveOSVersions(context.Background(), img)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 2 {
+			t.Errorf("expected 2 os.versions, got %v", got)
+		}
+	})
+
+	t.Run("missing image", func(t *testing.T) {
+		if _, err := resolver.ResolveOSVersions(context.Background(), reg+"/missing:latest"); err == nil {
+			t.Error("expected an error for a missing image, got nil")
+		}
+	})
+}
+
+type countingResolver struct {
+	calls    int
+	versions []string
+	err      error
+}
+
+func (c *countingResolver) ResolveOSVersions(_ context.Context, _ string) ([]string, error) {
+	c.calls++
+	return c.versions, c.err
+}
+
+func TestCachingResolver(t *testing.T) {
+	inner := &countingResolver{versions: []string{"10.0.20348.1"}}
+	cache := newCachingResolver(inner, 16, time.Minute, 10*time.Second)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.ResolveOSVersions(context.Background(), "img"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected 1 upstream call while cached, got %d", inner.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.ResolveOSVersions(context.Background(), "img"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected expired entry to be refreshed, got %d calls", inner.calls)
+	}
+
+	inner.err = errors.New("registry down")
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.ResolveOSVersions(context.Background(), "img"); err == nil {
+		t.Error("expected upstream error to be returned")
+	}
+	inner.err = nil
+	if _, err := cache.ResolveOSVersions(context.Background(), "img"); err == nil || inner.calls != 3 {
+		t.Errorf("expected the error to be cached, got %v after %d calls", err, inner.calls)
+	}
+	now = now.Add(20 * time.Second)
+	if _, err := cache.ResolveOSVersions(context.Background(), "img"); err != nil || inner.calls != 4 {
+		t.Errorf("expected the error to expire after the error TTL, got %v after %d calls", err, inner.calls)
+	}
+}
+
+// blockingResolver counts lookups and blocks them until release is closed.
+type blockingResolver struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (b *blockingResolver) ResolveOSVersions(_ context.Context, _ string) ([]string, error) {
+	b.calls.Add(1)
+	<-b.release
+	return []string{"10.0.20348.1"}, nil
+}
+
+func TestCachingResolverSharesLookups(t *testing.T) {
+	inner := &blockingResolver{release: make(chan struct{})}
+	cache := newCachingResolver(inner, 16, time.Minute, 10*time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if versions, err := cache.ResolveOSVersions(context.Background(), "img"); err != nil || len(versions) != 1 {
+				t.Errorf("unexpected result %v, %v", versions, err)
+			}
+		}()
+	}
+
+	// a waiting pod gives up with its own request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.ResolveOSVersions(ctx, "img"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the request, got %v", err)
+	}
+
+	close(inner.release)
+	wg.Wait()
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("expected concurrent misses to share 1 lookup, got %d", got)
+	}
+}
+
+func TestCachingResolverEvicts(t *testing.T) {
+	inner := &countingResolver{versions: []string{"10.0.20348.1"}}
+	cache := newCachingResolver(inner, 2, time.Minute, 10*time.Second)
+
+	for _, image := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := cache.ResolveOSVersions(context.Background(), image); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// c evicts b, the least recently used image, and b evicts c
+	if inner.calls != 4 {
+		t.Errorf("expected 4 upstream calls, got %d", inner.calls)
+	}
+}
+
+func TestRegistryResolverTimeout(t *testing.T) {
+	// a registry that accepts connections and never answers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	resolver := newRegistryResolver(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := resolver.ResolveOSVersions(context.Background(), strings.TrimPrefix(server.URL, "http://")+"/slow:latest")
+	if err == nil {
+		t.Fatal("expected an error from a registry that does not answer")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the lookup to time out quickly, took %s", elapsed)
+	}
+}
+
+func TestRequiresHyperV(t *testing.T) {
+	reg := newTestRegistry(t)
+	ltsc2019 := pushImage(t, reg, "app:ltsc2019", "10.0.17763.5820")
+	ltsc2022 := pushImage(t, reg, "app:ltsc2022", "10.0.20348.2227")
+	multi := pushIndex(t, reg, "app:multi", "10.0.17763.5820", "10.0.20348.2227")
+
+	tests := []struct {
+		name    string
+		nodes   []*corev1.Node
+		pod     *corev1.Pod
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:  "matching build runs process isolated",
+			nodes: []*corev1.Node{windowsNode("n1", "10.0.20348")},
+			pod:   podWithImages(ltsc2022),
+			want:  false,
+		},
+		{
+			name:  "older image on newer host needs Hyper-V",
+			nodes: []*corev1.Node{windowsNode("n1", "10.0.20348")},
+			pod:   podWithImages(ltsc2019),
+			want:  true,
+		},
+		{
+			name:  "manifest list with a matching entry runs process isolated",
+			nodes: []*corev1.Node{windowsNode("n1", "10.0.17763"), windowsNode("n2", "10.0.20348")},
+			pod:   podWithImages(multi),
+			want:  false,
+		},
+		{
+			name:  "any incompatible eligible node needs Hyper-V",
+			nodes: []*corev1.Node{windowsNode("n1", "10.0.17763"), windowsNode("n2", "10.0.20348")},
+			pod:   podWithImages(ltsc2022),
+			want:  true,
+		},
+		{
+			name: "nodeSelector narrows eligible nodes",
+			nodes: func() []*corev1.Node {
+				n2 := windowsNode("n2", "10.0.20348")
+				n2.Labels["pool"] = "ltsc2022"
+				return []*corev1.Node{windowsNode("n1", "10.0.17763"), n2}
+			}(),
+			pod: func() *corev1.Pod {
+				p := podWithImages(ltsc2022)
+				p.Spec.NodeSelector = map[string]string{"pool": "ltsc2022"}
+				return p
+			}(),
+			want: false,
+		},
+		{
+			name:    "no labeled Windows nodes is an error",
+			pod:     podWithImages(ltsc2022),
+			wantErr: true,
+		},
+		{
+			name:    "unreachable image is an error",
+			nodes:   []*corev1.Node{windowsNode("n1", "10.0.20348")},
+			pod:     podWithImages(reg + "/missing:latest"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			for _, n := range tc.nodes {
+				builder = builder.WithObjects(n)
+			}
+			cc := &compatibilityChecker{Client: builder.Build(), Resolver: newRegistryResolver(5 * time.Second)}
+			got, err := cc.requiresHyperV(context.Background(), tc.pod)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("requiresHyperV() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequiresHyperVUnreachableRegistry(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	reg := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	cc := &compatibilityChecker{
+		Client:   fake.NewClientBuilder().WithObjects(windowsNode("n1", "10.0.20348")).Build(),
+		Resolver: newRegistryResolver(5 * time.Second),
+	}
+	if _, err := cc.requiresHyperV(context.Background(), podWithImages(reg+"/app:latest")); err == nil {
+		t.Error("expected an error when the registry is unreachable")
+	}
+}
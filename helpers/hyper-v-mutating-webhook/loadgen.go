@@ -0,0 +1,45 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/loadgen"
+)
+
+// inProcessAdmit sends reviews to the handler without a server.
+func inProcessAdmit(handler admission.Handler) loadgen.AdmitFunc {
+	return func(ctx context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+		resp := handler.Handle(ctx, admission.Request{AdmissionRequest: *review.Request})
+		if err := resp.Complete(admission.Request{AdmissionRequest: *review.Request}); err != nil {
+			return nil, err
+		}
+		return &resp.AdmissionResponse, nil
+	}
+}
+
+// runLoadgen implements the "loadgen" subcommand, which sends synthesized or
+// recorded AdmissionReviews to a webhook endpoint, or in-process to the pod
+// handler with default flags, and reports throughput, errors and latency
+// percentiles.
+func runLoadgen(args []string, out io.Writer) error {
+	return loadgen.Main("manager", args, out, inProcessAdmit(&podUpdater{}))
+}
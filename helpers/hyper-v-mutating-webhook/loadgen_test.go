@@ -0,0 +1,63 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"windows.k8s.io/webhook-common/loadgen"
+)
+
+func TestRunLoadInProcess(t *testing.T) {
+	payloads, err := loadgen.LoadPayloads("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	admit := inProcessAdmit(&podUpdater{})
+
+	report := loadgen.Run(context.Background(), loadgen.Options{Concurrency: 4, Requests: 50}, payloads, admit)
+	if report.Requests != 50 {
+		t.Errorf("expected 50 requests, got %d", report.Requests)
+	}
+	if report.Errors != 0 || report.Denied != 0 {
+		t.Errorf("expected no errors or denials, got %+v", report)
+	}
+	// One of the five synthesized pods is mutated.
+	if report.Patched != 10 {
+		t.Errorf("expected 10 patched requests, got %d", report.Patched)
+	}
+}
+
+func TestRunLoadHTTP(t *testing.T) {
+	srv := httptest.NewTLSServer(&webhook.Admission{Handler: &podUpdater{}})
+	defer srv.Close()
+	payloads, err := loadgen.LoadPayloads("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report := loadgen.Run(context.Background(), loadgen.Options{Concurrency: 4, Requests: 20}, payloads, loadgen.HTTPAdmit(srv.Client(), srv.URL))
+	if report.Requests != 20 || report.Errors != 0 {
+		t.Errorf("expected 20 successful requests, got %+v", report)
+	}
+	if report.Patched != 4 {
+		t.Errorf("expected 4 patched requests, got %d", report.Patched)
+	}
+}
@@ -17,8 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -33,6 +39,9 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/certs"
+	"windows.k8s.io/webhook-common/recording"
+	"windows.k8s.io/webhook-common/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,14 +58,140 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string, io.Writer) error{
+			"report":  runReport,
+			"loadgen": runLoadgen,
+			"replay":  runReplay,
+			"policy":  runPolicy,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var isolationMode string
+	var imageCacheTTL time.Duration
+	var imageErrorCacheTTL time.Duration
+	var imageCacheSize int
+	var imageLookupTimeout time.Duration
+	var excludedNamespaces string
+	var selfManagedCerts bool
+	var shadow bool
+	var rulesFile string
+	var samplePercent float64
+	var sampleSeed string
+	var nodeGateEnabled bool
+	var capabilityLabel string
+	var nodeGracePeriod time.Duration
+	var driftAudit bool
+	var driftAuditInterval time.Duration
+	var driftAuditIgnoredNamespaces string
+	var recordPath string
+	var recordMaxSizeMB int64
+	var recordMaxFiles int
+	var recordRedactEnv bool
+	var recordRedactPattern string
+	tracingOpts := tracing.Options{}
+	serverOpts := webhookServerOptions{}
+	certOpts := selfManagedCertOptions{}
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&serverOpts.Host, "webhook-bind-host", "",
+		"The address the webhook server binds to. Defaults to all interfaces.")
+	flag.IntVar(&serverOpts.Port, "webhook-port", 9443, "The port the webhook server listens on.")
+	flag.StringVar(&serverOpts.CertDir, "cert-dir", "",
+		"The directory containing the serving certificate and key. "+
+			"Defaults to <temp-dir>/k8s-webhook-server/serving-certs.")
+	flag.StringVar(&serverOpts.CertName, "tls-cert-name", "tls.crt", "The serving certificate file name within --cert-dir.")
+	flag.StringVar(&serverOpts.KeyName, "tls-key-name", "tls.key", "The serving key file name within --cert-dir.")
+	flag.StringVar(&serverOpts.TLSMinVersion, "tls-min-version", "VersionTLS12",
+		"Minimum TLS version served. One of: VersionTLS12, VersionTLS13.")
+	flag.StringVar(&serverOpts.TLSCipherSuites, "tls-cipher-suites", "",
+		"Comma-separated list of TLS cipher suites for TLS 1.2. Defaults to the Go default suites.")
+	flag.BoolVar(&serverOpts.DisableHTTP2, "disable-http2", true,
+		"Serve the webhook over HTTP/1.1 only.")
+	flag.StringVar(&serverOpts.MutatePath, "webhook-path", "/mutate-v1-pod", "The path the pod mutating webhook is served on.")
+	flag.StringVar(&serverOpts.LeaderElectionID, "leader-election-id", "ad4f0eab.windows.k8s.io",
+		"The name of the Lease used for leader election.")
+	flag.StringVar(&runtimeClassName, "runtime-class-name", runtimeClassName,
+		"The RuntimeClass injected into pods. Defaults to $RUNTIME_CLASS_NAME or runhcs-wcow-hypervisor.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", "",
+		"Comma-separated list of namespaces whose pods are never mutated.")
+	flag.BoolVar(&selfManagedCerts, "self-managed-certs", false,
+		"Generate and rotate the CA and serving certificate in-process instead of reading them from --cert-dir. "+
+			"Certificates are stored in --cert-secret-name and injected into --webhook-configuration-name.")
+	flag.StringVar(&certOpts.SecretName, "cert-secret-name", "", "The Secret storing self-managed certificates.")
+	flag.StringVar(&certOpts.SecretNamespace, "cert-secret-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of --cert-secret-name and the webhook Service. Defaults to $POD_NAMESPACE.")
+	flag.StringVar(&certOpts.WebhookConfigName, "webhook-configuration-name", "",
+		"The MutatingWebhookConfiguration whose caBundle is kept in sync with self-managed certificates.")
+	flag.StringVar(&certOpts.ServiceName, "service-name", "", "The webhook Service name used as the serving certificate DNS name.")
+	flag.DurationVar(&certOpts.CAValidity, "ca-validity", 10*365*24*time.Hour, "Lifetime of a self-managed CA.")
+	flag.DurationVar(&certOpts.CertValidity, "cert-validity", 365*24*time.Hour, "Lifetime of a self-managed serving certificate.")
+	flag.DurationVar(&certOpts.RefreshBefore, "cert-refresh-before", 30*24*time.Hour,
+		"Rotate a self-managed serving certificate when it expires within this duration.")
+	flag.DurationVar(&certOpts.SyncInterval, "cert-sync-interval", time.Minute,
+		"How often self-managed certificates and the caBundle are checked.")
+	flag.BoolVar(&shadow, "shadow", false,
+		"Report-only mode: compute the decision and patch for each pod but only record them in "+
+			"annotations, audit annotations and the hyperv_webhook_shadow_decisions_total metric.")
+	flag.StringVar(&rulesFile, "rules-file", "",
+		"YAML file with named rules that skip or force injection for matching pods. "+
+			"Replaces the built-in custom-node-selector rule.")
+	flag.Float64Var(&samplePercent, "sample-percent", 100,
+		"Percentage of workloads, keyed by namespace and controlling owner, that get Hyper-V isolation. "+
+			"Below 100 the chosen isolation is recorded in the "+isolationAnnotation+" annotation of every pod.")
+	flag.StringVar(&sampleSeed, "sample-seed", "", "Seed that changes which workloads --sample-percent selects.")
+	flag.BoolVar(&nodeGateEnabled, "require-capable-nodes", true,
+		"Only inject the runtime class while at least one Windows node matches the RuntimeClass "+
+			"scheduling nodeSelector and --capability-label.")
+	flag.StringVar(&capabilityLabel, "capability-label", "",
+		"Node label, as key or key=value, that marks Windows nodes able to run Hyper-V isolated containers.")
+	flag.DurationVar(&nodeGracePeriod, "capable-nodes-grace-period", 2*time.Minute,
+		"How long injection continues after startup or after the last capable node disappears.")
+	flag.BoolVar(&driftAudit, "drift-audit", false,
+		"Run a controller that flags Windows pods whose isolation does not match the webhook policy "+
+			"with the HyperVIsolationDrift condition and an Event. Ignored in shadow mode.")
+	flag.DurationVar(&driftAuditInterval, "drift-audit-interval", 5*time.Minute,
+		"How often the drift audit summary is logged and its metrics are updated.")
+	flag.StringVar(&driftAuditIgnoredNamespaces, "drift-audit-ignored-namespaces", "kube-system,calico-system,tigera-operator",
+		"Comma-separated namespaces the drift audit skips, typically those excluded by the webhook namespaceSelector.")
+	flag.StringVar(&recordPath, "record-path", "",
+		"File or directory to which every AdmissionReview request, decision and response patch is "+
+			"appended as JSON Lines. Disabled when empty.")
+	flag.Int64Var(&recordMaxSizeMB, "record-max-size-mb", 100, "Size in megabytes at which the recording is rotated.")
+	flag.IntVar(&recordMaxFiles, "record-max-files", 5, "Number of rotated recordings kept.")
+	flag.BoolVar(&recordRedactEnv, "record-redact-env-values", true, "Replace all env var values and container command lines in recordings.")
+	flag.StringVar(&recordRedactPattern, "record-redact-pattern", recording.DefaultRedactPattern,
+		"Regular expression of env var names, annotation keys and command line flags whose values are replaced in recordings.")
+	flag.StringVar(&isolationMode, "isolation-mode", isolationModeAlways,
+		"How pods are selected for Hyper-V isolation. One of: "+
+			"always (inject into every eligible pod), "+
+			"compatibility (inject only when an image's os.version does not match the build of an eligible Windows node).")
+	flag.DurationVar(&imageCacheTTL, "image-os-version-cache-ttl", 10*time.Minute,
+		"How long resolved image os.version values are cached in compatibility mode.")
+	flag.DurationVar(&imageErrorCacheTTL, "image-os-version-error-cache-ttl", 30*time.Second,
+		"How long a failed image os.version lookup is cached in compatibility mode, so pods are admitted without waiting on a registry that is down.")
+	flag.IntVar(&imageCacheSize, "image-os-version-cache-size", 4096,
+		"How many images the os.version cache holds in compatibility mode. The least recently used images are evicted first.")
+	flag.DurationVar(&imageLookupTimeout, "image-lookup-timeout", 3*time.Second,
+		"Timeout of each registry lookup in compatibility mode. Keep it well below the webhook timeoutSeconds so the webhook fails open.")
+	flag.StringVar(&tracingOpts.Endpoint, "tracing-endpoint", "",
+		"The host:port of an OTLP gRPC collector admission spans are exported to. Tracing is disabled when empty.")
+	flag.BoolVar(&tracingOpts.Insecure, "tracing-insecure", false, "Connect to the tracing collector without TLS.")
+	flag.Float64Var(&tracingOpts.SamplingRatio, "tracing-sampling-ratio", 1,
+		"Fraction of requests without a trace context from the API server that are traced.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,13 +200,41 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingService, tracingOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "unable to flush traces")
+		}
+	}()
+
+	webhookOpts, err := serverOpts.toWebhookOptions()
+	if err != nil {
+		setupLog.Error(err, "invalid webhook server options")
+		os.Exit(1)
+	}
+
+	store := &certs.Store{}
+	if selfManagedCerts {
+		if err := certOpts.Validate(); err != nil {
+			setupLog.Error(err, "invalid self-managed certificate options")
+			os.Exit(1)
+		}
+		webhookOpts.TLSOpts = append(webhookOpts.TLSOpts, func(c *tls.Config) {
+			c.GetCertificate = store.GetCertificate
+		})
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
-		WebhookServer:          webhook.NewServer(webhook.Options{Port: 9443}),
+		WebhookServer:          webhook.NewServer(webhookOpts),
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "ad4f0eab.windows.k8s.io",
+		LeaderElectionID:       serverOpts.LeaderElectionID,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,11 +252,84 @@ func main() {
 		os.Exit(1)
 	}
 
-	decoder := admission.NewDecoder(scheme)
-	mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &podUpdater{Client: mgr.GetClient(), decoder: decoder}})
+	updater := &podUpdater{
+		Client:             mgr.GetClient(),
+		ExcludedNamespaces: parseList(excludedNamespaces),
+		Shadow:             shadow,
+	}
+	if rulesFile != "" {
+		updater.Rules, err = loadRulesFile(rulesFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load mutation rules")
+			os.Exit(1)
+		}
+	}
+	if samplePercent < 100 {
+		updater.Sampler, err = newSampler(samplePercent, sampleSeed)
+		if err != nil {
+			setupLog.Error(err, "invalid sampling options")
+			os.Exit(1)
+		}
+	}
+	if nodeGateEnabled {
+		updater.Nodes = newNodeGate(mgr.GetClient(), capabilityLabel, nodeGracePeriod)
+	}
+	switch isolationMode {
+	case isolationModeAlways:
+	case isolationModeCompatibility:
+		updater.Compat = &compatibilityChecker{
+			Client:   mgr.GetClient(),
+			Resolver: newCachingResolver(newRegistryResolver(imageLookupTimeout), imageCacheSize, imageCacheTTL, imageErrorCacheTTL),
+		}
+	default:
+		setupLog.Error(nil, "unknown isolation mode", "isolation-mode", isolationMode)
+		os.Exit(1)
+	}
+	var handler admission.Handler = updater
+	if recordPath != "" {
+		redact, err := recording.NewRedaction(recordRedactEnv, recordRedactPattern)
+		if err != nil {
+			setupLog.Error(err, "invalid recorder options")
+			os.Exit(1)
+		}
+		out := &recording.RotatingFile{Path: recording.FilePath(recordPath), MaxBytes: recordMaxSizeMB << 20, MaxFiles: recordMaxFiles}
+		defer out.Close()
+		handler = &recordingHandler{Handler: updater, Recorder: &recording.Recorder{Webhook: recorderWebhookName, Redact: redact, Out: out}}
+	}
+	mgr.GetWebhookServer().Register(serverOpts.MutatePath, tracingWebhook(&webhook.Admission{Handler: &tracingHandler{Handler: handler}}))
 
 	//+kubebuilder:scaffold:builder
 
+	if driftAudit && !shadow {
+		auditor := &driftAuditor{
+			Client:            mgr.GetClient(),
+			Recorder:          mgr.GetEventRecorder("hyperv-drift-audit"),
+			Updater:           updater,
+			IgnoredNamespaces: parseList(driftAuditIgnoredNamespaces),
+			Interval:          driftAuditInterval,
+		}
+		if err := auditor.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up drift audit")
+			os.Exit(1)
+		}
+	}
+
+	if selfManagedCerts {
+		if err := mgr.Add(&certRotator{Client: mgr.GetClient(), Reader: mgr.GetAPIReader(), Options: certOpts}); err != nil {
+			setupLog.Error(err, "unable to set up certificate rotator")
+			os.Exit(1)
+		}
+		loader := &certLoader{Reader: mgr.GetAPIReader(), Key: certOpts.secretKey(), Store: store, Interval: 10 * time.Second}
+		if err := mgr.Add(loader); err != nil {
+			setupLog.Error(err, "unable to set up certificate loader")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("serving-cert", func(_ *http.Request) error { return store.Ready() }); err != nil {
+			setupLog.Error(err, "unable to set up serving certificate check")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
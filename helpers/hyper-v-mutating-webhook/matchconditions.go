@@ -0,0 +1,60 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var matchConditionSkips = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "hyperv_webhook_match_condition_skips_total",
+		Help: "Pods skipped because a match condition evaluated to false, by condition name.",
+	},
+	[]string{"condition"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(matchConditionSkips)
+}
+
+// namespaceObjectGetter returns a lookup of the namespace for the
+// namespaceObject variable of match conditions.
+func namespaceObjectGetter(ctx context.Context, c client.Reader, namespace string) func() (*corev1.Namespace, error) {
+	return func() (*corev1.Namespace, error) {
+		if c == nil {
+			return nil, fmt.Errorf("no client to read namespace %s", namespace)
+		}
+		ns := &corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return nil, fmt.Errorf("getting namespace %s: %w", namespace, err)
+		}
+		return ns, nil
+	}
+}
+
+// matchConditionReason is the skip reason reported for pods skipped by a
+// false match condition.
+func matchConditionReason(name string) string {
+	return "matchCondition:" + name
+}
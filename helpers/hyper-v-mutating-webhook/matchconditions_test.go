@@ -0,0 +1,239 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/loadgen"
+	"windows.k8s.io/webhook-common/matchconditions"
+)
+
+const testMatchConditions = `
+matchConditions:
+- name: not-opted-out
+  expression: "!has(object.metadata.labels) || !('example.com/process-isolated' in object.metadata.labels)"
+- name: short-grace-period
+  expression: "!has(object.spec.terminationGracePeriodSeconds) || object.spec.terminationGracePeriodSeconds <= 30"
+- name: create-only
+  expression: "request.operation == 'CREATE' && oldObject == null"
+- name: not-privileged-namespace
+  expression: >-
+    namespaceObject == null || !has(namespaceObject.metadata.labels) ||
+    namespaceObject.metadata.labels['pod-security.kubernetes.io/enforce'] != 'privileged'
+`
+
+func TestLoadMatchConditionErrors(t *testing.T) {
+	tooMany := "matchConditions:\n"
+	for i := 0; i <= matchconditions.MaxConditions; i++ {
+		tooMany += fmt.Sprintf("- {name: c%d, expression: 'true'}\n", i)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "missing name",
+			content: "matchConditions:\n- expression: 'true'\n",
+			wantErr: "name is required",
+		},
+		{
+			name:    "duplicate name",
+			content: "matchConditions:\n- {name: a, expression: 'true'}\n- {name: a, expression: 'true'}\n",
+			wantErr: "duplicate name",
+		},
+		{
+			name:    "missing expression",
+			content: "matchConditions:\n- name: a\n",
+			wantErr: "expression is required",
+		},
+		{
+			name:    "syntax error",
+			content: "matchConditions:\n- {name: a, expression: 'object.metadata.'}\n",
+			wantErr: "Syntax error",
+		},
+		{
+			name:    "undeclared variable",
+			content: "matchConditions:\n- {name: a, expression: 'params.enabled'}\n",
+			wantErr: "undeclared reference",
+		},
+		{
+			name:    "not bool",
+			content: "matchConditions:\n- {name: a, expression: \"'yes'\"}\n",
+			wantErr: "must evaluate to bool",
+		},
+		{
+			name:    "too many",
+			content: tooMany,
+			wantErr: "at most 64",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadRulesFile(writeRules(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func conditionRequest(pod string) *admissionv1.AdmissionRequest {
+	return loadgen.PodReview([]byte(pod)).Request
+}
+
+func TestEvalMatchConditions(t *testing.T) {
+	rules, err := loadRulesFile(writeRules(t, testMatchConditions))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "e2e"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "privileged", Labels: map[string]string{
+			"pod-security.kubernetes.io/enforce": "privileged",
+		}}},
+	).Build()
+
+	update := conditionRequest(`{"metadata":{"namespace":"e2e"}}`)
+	update.Operation = admissionv1.Update
+	update.OldObject = runtime.RawExtension{Raw: []byte(`{"metadata":{"namespace":"e2e"}}`)}
+
+	tests := []struct {
+		name    string
+		req     *admissionv1.AdmissionRequest
+		client  bool
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "all true",
+			req:    conditionRequest(`{"metadata":{"namespace":"e2e","labels":{"app":"web"}},"spec":{"terminationGracePeriodSeconds":30}}`),
+			client: true,
+		},
+		{
+			name:   "label opt-out",
+			req:    conditionRequest(`{"metadata":{"namespace":"e2e","labels":{"example.com/process-isolated":""}}}`),
+			client: true,
+			want:   "not-opted-out",
+		},
+		{
+			name:   "integer field",
+			req:    conditionRequest(`{"metadata":{"namespace":"e2e"},"spec":{"terminationGracePeriodSeconds":60}}`),
+			client: true,
+			want:   "short-grace-period",
+		},
+		{
+			name:   "request and oldObject",
+			req:    update,
+			client: true,
+			want:   "create-only",
+		},
+		{
+			name:   "namespace object",
+			req:    conditionRequest(`{"metadata":{"namespace":"privileged"}}`),
+			client: true,
+			want:   "not-privileged-namespace",
+		},
+		{
+			name:    "namespace lookup error",
+			req:     conditionRequest(`{"metadata":{"namespace":"e2e"},"spec":{}}`),
+			wantErr: "no client to read namespace e2e",
+		},
+		{
+			name: "false wins over errors",
+			req:  conditionRequest(`{"metadata":{"namespace":"e2e"},"spec":{"terminationGracePeriodSeconds":60}}`),
+			want: "short-grace-period",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var reader client.Reader
+			if tc.client {
+				reader = c
+			}
+			got, err := matchconditions.Eval(context.Background(), rules.conditions, tc.req, namespaceObjectGetter(context.Background(), reader, tc.req.Namespace))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleMatchConditions(t *testing.T) {
+	rules, err := loadRulesFile(writeRules(t, testMatchConditions))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pu := &podUpdater{Rules: rules}
+
+	tests := []struct {
+		name       string
+		pod        string
+		wantReason string
+		wantPatch  bool
+	}{
+		{
+			name:       "condition false",
+			pod:        `{"metadata":{"name":"p","labels":{"example.com/process-isolated":"true"}},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+			wantReason: matchConditionReason("not-opted-out"),
+		},
+		{
+			name:       "condition error",
+			pod:        `{"metadata":{"name":"p","namespace":"e2e"},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+			wantReason: skipMatchConditionFailed,
+		},
+		{
+			name:      "all true",
+			pod:       `{"metadata":{"name":"p"},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+			wantPatch: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := admission.Request{AdmissionRequest: *conditionRequest(tc.pod)}
+			resp, rec, err := handleRecorded(context.Background(), pu, req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !resp.Allowed || rec.Reason != tc.wantReason || (len(resp.Patches) > 0) != tc.wantPatch {
+				t.Errorf("expected reason %q and patch %v, got %q and %v", tc.wantReason, tc.wantPatch, rec.Reason, resp.Patches)
+			}
+		})
+	}
+}
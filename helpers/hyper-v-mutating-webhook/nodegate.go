@@ -0,0 +1,166 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	capableNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hyperv_webhook_capable_nodes",
+			Help: "Windows nodes matching the RuntimeClass scheduling selector and the capability label at the last admission.",
+		},
+	)
+	injectionGateOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hyperv_webhook_injection_gate_open",
+			Help: "Whether the runtime class is currently injected (1) or withheld because no Hyper-V capable nodes exist (0).",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(capableNodes, injectionGateOpen)
+}
+
+// nodeGate withholds the runtime class while no Windows node can run it, so
+// that pods stay schedulable on clusters without Hyper-V capable nodes. A node
+// is capable when it is a Windows node matching the RuntimeClass scheduling
+// nodeSelector and, if set, the capability label. After the last capable node
+// disappears (or after the webhook starts) injection continues for
+// GracePeriod, so pods created during a node pool roll or scale-up still get
+// Hyper-V isolation.
+type nodeGate struct {
+	// Client reads RuntimeClasses and Nodes, normally from the manager cache.
+	Client client.Reader
+	// CapabilityLabel is an additional node label requirement, either "key"
+	// or "key=value". Empty means only the RuntimeClass selector is used.
+	CapabilityLabel string
+	GracePeriod     time.Duration
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	open     *bool
+}
+
+func newNodeGate(c client.Reader, capabilityLabel string, gracePeriod time.Duration) *nodeGate {
+	g := &nodeGate{Client: c, CapabilityLabel: capabilityLabel, GracePeriod: gracePeriod, now: time.Now}
+	g.lastSeen = g.now()
+	return g
+}
+
+// nodeSelector returns the labels a node must carry to run the runtime class.
+func (g *nodeGate) nodeSelector(ctx context.Context) (map[string]string, error) {
+	selector := map[string]string{corev1.LabelOSStable: "windows"}
+
+	rc := &nodev1.RuntimeClass{}
+	if err := g.Client.Get(ctx, client.ObjectKey{Name: runtimeClassName}, rc); err != nil {
+		return nil, fmt.Errorf("getting RuntimeClass %s: %w", runtimeClassName, err)
+	}
+	if rc.Scheduling != nil {
+		for k, v := range rc.Scheduling.NodeSelector {
+			selector[k] = v
+		}
+	}
+	return selector, nil
+}
+
+// countCapableNodes returns the number of ready-to-use Hyper-V capable nodes.
+// Unschedulable nodes are not counted.
+func (g *nodeGate) countCapableNodes(ctx context.Context) (int, error) {
+	selector, err := g.nodeSelector(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := g.Client.List(ctx, nodes, client.MatchingLabels(selector)); err != nil {
+		return 0, fmt.Errorf("listing nodes: %w", err)
+	}
+
+	key, value, hasValue := strings.Cut(g.CapabilityLabel, "=")
+	count := 0
+	for _, n := range nodes.Items {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		if key != "" {
+			v, ok := n.Labels[key]
+			if !ok || (hasValue && v != value) {
+				continue
+			}
+		}
+		count++
+	}
+	return count, nil
+}
+
+// allowInjection reports whether the runtime class may be injected. A
+// missing RuntimeClass withholds it immediately, since the apiserver rejects
+// pods that reference it. Other errors reading the cache keep injection
+// enabled, which is the behavior without the gate.
+func (g *nodeGate) allowInjection(ctx context.Context) bool {
+	count, err := g.countCapableNodes(ctx)
+	missing := apierrors.IsNotFound(err)
+	if err != nil && !missing {
+		webhookLogger.Error(err, "unable to determine Hyper-V capable nodes, injecting runtime class")
+		return true
+	}
+	capableNodes.Set(float64(count))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if count > 0 {
+		g.lastSeen = now
+	}
+	open := !missing && (count > 0 || now.Sub(g.lastSeen) < g.GracePeriod)
+
+	if g.open == nil || *g.open != open {
+		switch {
+		case open:
+			webhookLogger.Info("Hyper-V capable nodes available, injecting runtime class", "nodes", count)
+		case missing:
+			webhookLogger.Info("RuntimeClass not found, withholding runtime class", "runtimeClass", runtimeClassName)
+		default:
+			webhookLogger.Info("no Hyper-V capable nodes, withholding runtime class",
+				"capabilityLabel", g.CapabilityLabel, "gracePeriod", g.GracePeriod)
+		}
+		g.open = &open
+	}
+	if open {
+		injectionGateOpen.Set(1)
+	} else {
+		injectionGateOpen.Set(0)
+	}
+	return open
+}
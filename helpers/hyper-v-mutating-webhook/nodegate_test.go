@@ -0,0 +1,192 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func testRuntimeClassObject() *nodev1.RuntimeClass {
+	return &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: runtimeClassName},
+		Handler:    runtimeClassName,
+		Scheduling: &nodev1.Scheduling{NodeSelector: map[string]string{
+			"kubernetes.io/os":   "windows",
+			"kubernetes.io/arch": "amd64",
+		}},
+	}
+}
+
+func labeledNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestCountCapableNodes(t *testing.T) {
+	amd64 := map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64"}
+	capable := map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64", "example.com/hyperv": "true"}
+	cordoned := labeledNode("cordoned", amd64)
+	cordoned.Spec.Unschedulable = true
+
+	tests := []struct {
+		name            string
+		objects         []client.Object
+		capabilityLabel string
+		want            int
+		wantErr         bool
+	}{
+		{
+			name:    "windows nodes matching the runtime class",
+			objects: []client.Object{testRuntimeClassObject(), labeledNode("w1", amd64), labeledNode("w2", amd64)},
+			want:    2,
+		},
+		{
+			name: "linux and arm64 nodes do not count",
+			objects: []client.Object{
+				testRuntimeClassObject(),
+				labeledNode("l1", map[string]string{"kubernetes.io/os": "linux", "kubernetes.io/arch": "amd64"}),
+				labeledNode("w1", map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "arm64"}),
+			},
+			want: 0,
+		},
+		{
+			name:    "unschedulable nodes do not count",
+			objects: []client.Object{testRuntimeClassObject(), cordoned},
+			want:    0,
+		},
+		{
+			name:            "capability label key",
+			objects:         []client.Object{testRuntimeClassObject(), labeledNode("w1", amd64), labeledNode("w2", capable)},
+			capabilityLabel: "example.com/hyperv",
+			want:            1,
+		},
+		{
+			name:            "capability label value mismatch",
+			objects:         []client.Object{testRuntimeClassObject(), labeledNode("w2", capable)},
+			capabilityLabel: "example.com/hyperv=false",
+			want:            0,
+		},
+		{
+			name:    "missing runtime class",
+			objects: []client.Object{labeledNode("w1", amd64)},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newNodeGate(fake.NewClientBuilder().WithObjects(tc.objects...).Build(), tc.capabilityLabel, 0)
+			got, err := g.countCapableNodes(context.Background())
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("countCapableNodes() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodeGateGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	node := labeledNode("w1", map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64"})
+	c := fake.NewClientBuilder().WithObjects(testRuntimeClassObject()).Build()
+
+	now := time.Now()
+	g := newNodeGate(c, "", time.Minute)
+	g.now = func() time.Time { return now }
+	g.lastSeen = now
+
+	if !g.allowInjection(ctx) {
+		t.Error("expected injection during the startup grace period")
+	}
+	now = now.Add(2 * time.Minute)
+	if g.allowInjection(ctx) {
+		t.Error("expected injection to be withheld without capable nodes")
+	}
+
+	if err := c.Create(ctx, node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !g.allowInjection(ctx) {
+		t.Error("expected injection once a capable node exists")
+	}
+
+	if err := c.Delete(ctx, node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if !g.allowInjection(ctx) {
+		t.Error("expected injection within the grace period after the last node left")
+	}
+	now = now.Add(time.Minute)
+	if g.allowInjection(ctx) {
+		t.Error("expected injection to be withheld after the grace period")
+	}
+}
+
+func TestNodeGateMissingRuntimeClass(t *testing.T) {
+	ctx := context.Background()
+	node := labeledNode("w1", map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64"})
+	c := fake.NewClientBuilder().WithObjects(node).Build()
+
+	// The grace period does not apply: pods referencing a missing
+	// RuntimeClass are rejected by the apiserver.
+	g := newNodeGate(c, "", time.Hour)
+	if g.allowInjection(ctx) {
+		t.Error("expected injection to be withheld without the RuntimeClass")
+	}
+
+	if err := c.Create(ctx, testRuntimeClassObject()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !g.allowInjection(ctx) {
+		t.Error("expected injection once the RuntimeClass exists")
+	}
+}
+
+func TestHandleWithoutCapableNodes(t *testing.T) {
+	g := newNodeGate(fake.NewClientBuilder().WithObjects(testRuntimeClassObject()).Build(), "", 0)
+	pu := &podUpdater{Nodes: g}
+
+	resp := pu.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`)},
+	}})
+	if !resp.Allowed {
+		t.Fatalf("expected request to be allowed, got %v", resp.Result)
+	}
+	if len(resp.Patches) != 0 {
+		t.Errorf("expected no patches without capable nodes, got %v", resp.Patches)
+	}
+}
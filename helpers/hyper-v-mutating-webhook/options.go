@@ -0,0 +1,123 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// webhookServerOptions holds the command line settings of the webhook server.
+type webhookServerOptions struct {
+	Host             string
+	Port             int
+	CertDir          string
+	CertName         string
+	KeyName          string
+	TLSMinVersion    string
+	TLSCipherSuites  string
+	DisableHTTP2     bool
+	MutatePath       string
+	LeaderElectionID string
+}
+
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// toWebhookOptions converts the command line settings into controller-runtime
+// webhook server options.
+func (o *webhookServerOptions) toWebhookOptions() (webhook.Options, error) {
+	opts := webhook.Options{
+		Host:     o.Host,
+		Port:     o.Port,
+		CertDir:  o.CertDir,
+		CertName: o.CertName,
+		KeyName:  o.KeyName,
+	}
+
+	minVersion, err := parseTLSVersion(o.TLSMinVersion)
+	if err != nil {
+		return opts, err
+	}
+	cipherSuites, err := parseCipherSuites(o.TLSCipherSuites)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.TLSOpts = append(opts.TLSOpts, func(c *tls.Config) {
+		c.MinVersion = minVersion
+		if len(cipherSuites) > 0 {
+			c.CipherSuites = cipherSuites
+		}
+		// Disabling HTTP/2 avoids the HTTP/2 Stream Cancellation and Rapid
+		// Reset CVEs (GHSA-qppj-fm5r-hxr3, GHSA-4374-p667-p6c8).
+		if o.DisableHTTP2 {
+			c.NextProtos = []string{"http/1.1"}
+		}
+	})
+	return opts, nil
+}
+
+// parseTLSVersion maps a crypto/tls constant name to its value.
+func parseTLSVersion(name string) (uint16, error) {
+	v, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, must be one of VersionTLS12, VersionTLS13", name)
+	}
+	return v, nil
+}
+
+// parseCipherSuites maps a comma separated list of IANA cipher suite names to
+// their IDs. Insecure suites are rejected.
+func parseCipherSuites(list string) ([]uint16, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseList splits a comma separated flag value into a set, ignoring empty
+// entries.
+func parseList(list string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = struct{}{}
+		}
+	}
+	return set
+}
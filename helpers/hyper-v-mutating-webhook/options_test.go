@@ -0,0 +1,99 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestToWebhookOptions(t *testing.T) {
+	o := &webhookServerOptions{
+		Host:            "127.0.0.1",
+		Port:            8443,
+		CertDir:         "/certs",
+		CertName:        "serving.crt",
+		KeyName:         "serving.key",
+		TLSMinVersion:   "VersionTLS13",
+		TLSCipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		DisableHTTP2:    true,
+	}
+	opts, err := o.toWebhookOptions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Host != "127.0.0.1" || opts.Port != 8443 || opts.CertDir != "/certs" ||
+		opts.CertName != "serving.crt" || opts.KeyName != "serving.key" {
+		t.Errorf("server options not propagated: %+v", opts)
+	}
+
+	cfg := &tls.Config{}
+	for _, f := range opts.TLSOpts {
+		f(cfg)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("expected MinVersion TLS 1.3, got %x", cfg.MinVersion)
+	}
+	if len(cfg.CipherSuites) != 2 || cfg.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites %v", cfg.CipherSuites)
+	}
+	if len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != "http/1.1" {
+		t.Errorf("expected HTTP/2 to be disabled, got NextProtos %v", cfg.NextProtos)
+	}
+}
+
+func TestToWebhookOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts webhookServerOptions
+	}{
+		{
+			name: "unknown TLS version",
+			opts: webhookServerOptions{TLSMinVersion: "VersionTLS10"},
+		},
+		{
+			name: "insecure cipher suite",
+			opts: webhookServerOptions{TLSMinVersion: "VersionTLS12", TLSCipherSuites: "TLS_RSA_WITH_RC4_128_SHA"},
+		},
+		{
+			name: "unknown cipher suite",
+			opts: webhookServerOptions{TLSMinVersion: "VersionTLS12", TLSCipherSuites: "bogus"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.opts.toWebhookOptions(); err == nil {
+				t.Error("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	got := parseList(" a, b,,c ")
+	if len(got) != 3 {
+		t.Fatalf("expected 3 entries, got %v", got)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("expected %q in %v", k, got)
+		}
+	}
+	if len(parseList("")) != 0 {
+		t.Error("expected empty set for empty list")
+	}
+}
@@ -0,0 +1,358 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+	"windows.k8s.io/webhook-common/matchconditions"
+)
+
+// policyOptions configures the generated MutatingAdmissionPolicy.
+type policyOptions struct {
+	Name               string
+	RuntimeClassName   string
+	ExcludedNamespaces []string
+	// Rules are the mutation rules and match conditions. Defaults to the
+	// built-in rules when nil.
+	Rules *ruleSet
+}
+
+// mutatingAdmissionPolicy returns a MutatingAdmissionPolicy and binding that
+// inject the runtime class into the pods the webhook would mutate, under the
+// same hard checks, excluded namespaces, rules and match conditions. Only the
+// checks that need the webhook's own state, such as the capable node gate,
+// compatibility mode and sampling, have no equivalent.
+func mutatingAdmissionPolicy(opts policyOptions) (*admissionregistrationv1beta1.MutatingAdmissionPolicy, *admissionregistrationv1beta1.MutatingAdmissionPolicyBinding, error) {
+	rules := opts.Rules
+	if rules == nil {
+		rules = defaultRuleSet
+	}
+	conditions, err := policyConditions(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	var matchConditions []admissionregistrationv1beta1.MatchCondition
+	var guards []policyCondition
+	for _, c := range conditions {
+		if c.namespace {
+			guards = append(guards, c)
+			continue
+		}
+		matchConditions = append(matchConditions, admissionregistrationv1beta1.MatchCondition{Name: c.name, Expression: c.expression})
+	}
+	if len(matchConditions) > matchconditions.MaxConditions {
+		return nil, nil, fmt.Errorf("the policy needs %d matchConditions, at most %d are allowed", len(matchConditions), matchconditions.MaxConditions)
+	}
+
+	namespaceSelector := &metav1.LabelSelector{}
+	if len(opts.ExcludedNamespaces) > 0 {
+		excluded := append([]string(nil), opts.ExcludedNamespaces...)
+		sort.Strings(excluded)
+		namespaceSelector.MatchExpressions = []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   excluded,
+		}}
+	}
+
+	policy := &admissionregistrationv1beta1.MutatingAdmissionPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admissionregistrationv1beta1.SchemeGroupVersion.String(), Kind: "MutatingAdmissionPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name},
+		Spec: admissionregistrationv1beta1.MutatingAdmissionPolicySpec{
+			MatchConstraints: &admissionregistrationv1beta1.MatchResources{
+				NamespaceSelector: namespaceSelector,
+				ObjectSelector:    &metav1.LabelSelector{},
+				MatchPolicy:       ptr.To(admissionregistrationv1beta1.Equivalent),
+				// runtimeClassName is immutable, so like the webhook the
+				// policy only mutates pods on CREATE.
+				ResourceRules: []admissionregistrationv1beta1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1beta1.RuleWithOperations{
+						Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create},
+						Rule: admissionregistrationv1beta1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				}},
+			},
+			MatchConditions: matchConditions,
+			Mutations: []admissionregistrationv1beta1.Mutation{{
+				PatchType: admissionregistrationv1beta1.PatchTypeApplyConfiguration,
+				ApplyConfiguration: &admissionregistrationv1beta1.ApplyConfiguration{
+					Expression: policyMutation(opts.RuntimeClassName, guards),
+				},
+			}},
+			// Like the webhook, admit the pod unmodified if a condition
+			// cannot be evaluated.
+			FailurePolicy:      ptr.To(admissionregistrationv1beta1.Ignore),
+			ReinvocationPolicy: admissionregistrationv1beta1.NeverReinvocationPolicy,
+		},
+	}
+	binding := &admissionregistrationv1beta1.MutatingAdmissionPolicyBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admissionregistrationv1beta1.SchemeGroupVersion.String(), Kind: "MutatingAdmissionPolicyBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name},
+		Spec:       admissionregistrationv1beta1.MutatingAdmissionPolicyBindingSpec{PolicyName: opts.Name},
+	}
+	return policy, binding, nil
+}
+
+// policyMutation sets the mutated annotation and, when unset, the runtime
+// class, as mutatePodRaw does. Applying the current runtimeClassName is a
+// no-op. The API server evaluates matchConditions without namespaceObject, so
+// conditions on the namespace guard the mutation instead; applying an empty
+// Object leaves the pod unmodified.
+func policyMutation(runtimeClassName string, guards []policyCondition) string {
+	mutation := fmt.Sprintf(`Object{
+  metadata: Object.metadata{
+    annotations: {%s: "mutated"}
+  },
+  spec: Object.spec{
+    runtimeClassName: has(object.spec.runtimeClassName) && object.spec.runtimeClassName != "" ? object.spec.runtimeClassName : %s
+  }
+}`, celString(webhookAnnotationPrefix), celString(runtimeClassName))
+	if len(guards) == 0 {
+		return mutation
+	}
+	var b strings.Builder
+	for i, g := range guards {
+		if i > 0 {
+			b.WriteString(" &&\n")
+		}
+		fmt.Fprintf(&b, "// %s\n(%s)", g.name, g.expression)
+	}
+	fmt.Fprintf(&b, "\n? %s\n: Object{}", mutation)
+	return b.String()
+}
+
+// hostProcessExpression mirrors isHostProcessPod: the pod-level setting takes
+// precedence over the containers.
+const hostProcessExpression = `has(object.spec.securityContext) && has(object.spec.securityContext.windowsOptions) && has(object.spec.securityContext.windowsOptions.hostProcess)
+  ? !object.spec.securityContext.windowsOptions.hostProcess
+  : !(has(object.spec.containers) && object.spec.containers.exists(c, ` + containerHostProcess + `)) &&
+    !(has(object.spec.initContainers) && object.spec.initContainers.exists(c, ` + containerHostProcess + `))`
+
+const containerHostProcess = `has(c.securityContext) && has(c.securityContext.windowsOptions) && has(c.securityContext.windowsOptions.hostProcess) && c.securityContext.windowsOptions.hostProcess`
+
+// policyCondition is a condition that must hold for a pod to be mutated.
+type policyCondition struct {
+	name       string
+	expression string
+	// namespace is set for expressions that use namespaceObject.
+	namespace bool
+}
+
+// policyConditions translates podSkipReason, the rules and the match
+// conditions of rs into CEL. The hard checks are named after their skip
+// reason and rules after the rule.
+func policyConditions(rs *ruleSet) ([]policyCondition, error) {
+	conditions := []policyCondition{
+		{name: skipHostProcess, expression: hostProcessExpression},
+		{name: skipHostNetwork, expression: "!has(object.spec.hostNetwork) || !object.spec.hostNetwork"},
+		{name: skipLinuxNodeSelector, expression: fmt.Sprintf(
+			"!has(object.spec.nodeSelector) || !(%[1]s in object.spec.nodeSelector) || object.spec.nodeSelector[%[1]s] != \"linux\"",
+			celString(corev1.LabelOSStable))},
+	}
+
+	// The first matching rule wins, so a skip rule only applies if no
+	// earlier force rule matches.
+	var forced []string
+	forcedNamespace := false
+	for _, r := range rs.rules {
+		match, err := ruleExpression(r)
+		if err != nil {
+			return nil, err
+		}
+		if r.action == ruleActionForce {
+			forced = append(forced, match)
+			forcedNamespace = forcedNamespace || r.namespaceLabels != nil
+			continue
+		}
+		expression := "!(" + match + ")"
+		if len(forced) > 0 {
+			expression = "(" + strings.Join(forced, ") || (") + ") || " + expression
+		}
+		conditions = append(conditions, policyCondition{
+			name:       "rule-" + r.name,
+			expression: expression,
+			namespace:  forcedNamespace || r.namespaceLabels != nil,
+		})
+	}
+
+	for _, c := range rs.conditions {
+		conditions = append(conditions, policyCondition{
+			name:       c.Name,
+			expression: c.Expression,
+			namespace:  strings.Contains(c.Expression, "namespaceObject"),
+		})
+	}
+
+	for _, c := range conditions {
+		if errs := validation.IsQualifiedName(c.name); len(errs) > 0 {
+			return nil, fmt.Errorf("matchCondition %s: invalid name: %s", c.name, strings.Join(errs, ", "))
+		}
+	}
+	return conditions, nil
+}
+
+// ruleExpression returns a CEL expression that is true for the pods r
+// matches.
+func ruleExpression(r compiledRule) (string, error) {
+	var terms []string
+	for _, s := range []struct {
+		field    string
+		selector labels.Selector
+		object   string
+	}{
+		{"labels", r.labels, "object.metadata.labels"},
+		{"annotations", r.annotations, "object.metadata.annotations"},
+		{"nodeSelector", r.nodeSelector, "object.spec.nodeSelector"},
+		{"namespaceLabels", r.namespaceLabels, "namespaceObject.metadata.labels"},
+	} {
+		if s.selector == nil {
+			continue
+		}
+		term, err := selectorExpression(s.selector, s.object)
+		if err != nil {
+			return "", fmt.Errorf("rule %s: %s: %w", r.name, s.field, err)
+		}
+		if s.field == "nodeSelector" {
+			// The nodeSelector matcher never matches pods without one.
+			term = fmt.Sprintf("has(%[1]s) && size(%[1]s) > 0 && %s", s.object, term)
+		}
+		terms = append(terms, term)
+	}
+	if r.ownerKinds != nil {
+		kinds := make([]string, 0, len(r.ownerKinds))
+		for k := range r.ownerKinds {
+			kinds = append(kinds, k)
+		}
+		terms = append(terms, fmt.Sprintf("has(object.metadata.ownerReferences) && object.metadata.ownerReferences.exists(o, o.kind in %s)", celList(kinds)))
+	}
+	return "(" + strings.Join(terms, ") && (") + ")", nil
+}
+
+// selectorExpression returns a CEL expression that is true if the map object
+// matches the selector. object may be unset, which matches like an empty map.
+func selectorExpression(selector labels.Selector, object string) (string, error) {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return "false", nil
+	}
+	if len(requirements) == 0 {
+		return "true", nil
+	}
+	terms := make([]string, 0, len(requirements))
+	for _, req := range requirements {
+		key := celString(req.Key())
+		present := fmt.Sprintf("has(%s) && %s in %s", object, key, object)
+		var term string
+		switch req.Operator() {
+		case selection.Exists:
+			term = present
+		case selection.DoesNotExist:
+			term = "!(" + present + ")"
+		case selection.In, selection.Equals, selection.DoubleEquals:
+			term = fmt.Sprintf("%s && %s[%s] in %s", present, object, key, celList(req.Values().List()))
+		case selection.NotIn, selection.NotEquals:
+			term = fmt.Sprintf("!(%s && %s[%s] in %s)", present, object, key, celList(req.Values().List()))
+		default:
+			return "", fmt.Errorf("operator %s is not supported", req.Operator())
+		}
+		terms = append(terms, term)
+	}
+	return "(" + strings.Join(terms, ") && (") + ")", nil
+}
+
+func celString(s string) string {
+	return strconv.Quote(s)
+}
+
+func celList(values []string) string {
+	sort.Strings(values)
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = celString(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// runPolicy implements the "policy" subcommand, which prints a
+// MutatingAdmissionPolicy and binding equivalent to the webhook configured by
+// its flags.
+func runPolicy(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("policy", flag.ContinueOnError)
+	name := fs.String("name", "hyperv-runtimeclass", "Name of the MutatingAdmissionPolicy and its binding.")
+	fs.StringVar(&runtimeClassName, "runtime-class-name", runtimeClassName, "The RuntimeClass injected into pods.")
+	excludedNamespaces := fs.String("excluded-namespaces", "", "Comma-separated list of namespaces whose pods are never mutated.")
+	rulesFile := fs.String("rules-file", "", "YAML file with mutation rules. Defaults to the built-in rules.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: manager policy [flags]\n\n"+
+			"Prints a MutatingAdmissionPolicy and MutatingAdmissionPolicyBinding equivalent to the webhook.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("arguments not supported: %v", fs.Args())
+	}
+
+	opts := policyOptions{Name: *name, RuntimeClassName: runtimeClassName}
+	for ns := range parseList(*excludedNamespaces) {
+		opts.ExcludedNamespaces = append(opts.ExcludedNamespaces, ns)
+	}
+	if *rulesFile != "" {
+		var err error
+		if opts.Rules, err = loadRulesFile(*rulesFile); err != nil {
+			return err
+		}
+	}
+
+	policy, binding, err := mutatingAdmissionPolicy(opts)
+	if err != nil {
+		return err
+	}
+	for i, obj := range []interface{}{policy, binding} {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/generic"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/matching"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/mutating"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/mutating/patch"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/cel/environment"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/openapi/openapitest"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	ctrladmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/loadgen"
+	commonconditions "windows.k8s.io/webhook-common/matchconditions"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// policyCorpus are the pods the policy and the webhook must agree on, in
+// addition to loadgen.SynthesizedPods.
+var policyCorpus = []string{
+	podWithSecrets,
+	`{"metadata":{"name":"container-hpc","namespace":"e2e"},"spec":{"securityContext":{"windowsOptions":{"hostProcess":false}},"containers":[{"name":"c","image":"pause","securityContext":{"windowsOptions":{"hostProcess":true}}}]}}`,
+	`{"metadata":{"name":"init-hpc","namespace":"e2e"},"spec":{"initContainers":[{"name":"i","image":"pause","securityContext":{"windowsOptions":{"hostProcess":true}}}],"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"own-class","namespace":"e2e","annotations":{"a":"b"}},"spec":{"runtimeClassName":"runhcs-wcow-process","containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"system","namespace":"kube-system"},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"critical","namespace":"e2e","labels":{"app":"critical"}},"spec":{"nodeSelector":{"disktype":"ssd"},"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"opted-out","namespace":"e2e","labels":{"example.com/process-isolated":"true"}},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"ds","namespace":"e2e","ownerReferences":[{"apiVersion":"apps/v1","kind":"DaemonSet","name":"ds","uid":"1"}]},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"process-ns","namespace":"process"},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+	`{"metadata":{"name":"tier","namespace":"e2e","annotations":{"tier":"batch"}},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+}
+
+var policyNamespaces = []*corev1.Namespace{
+	{ObjectMeta: metav1.ObjectMeta{Name: "e2e", Labels: map[string]string{corev1.LabelMetadataName: "e2e"}}},
+	{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: map[string]string{corev1.LabelMetadataName: "kube-system"}}},
+	{ObjectMeta: metav1.ObjectMeta{Name: "process", Labels: map[string]string{corev1.LabelMetadataName: "process", "isolation": "process"}}},
+}
+
+const policyRules = `
+rules:
+- name: critical
+  action: force
+  match:
+    labels:
+      matchLabels: {app: critical}
+- name: custom-node-selector
+  action: skip
+  match:
+    nodeSelector:
+      matchExpressions:
+      - {key: kubernetes.io/os, operator: DoesNotExist}
+- name: daemonsets
+  action: skip
+  match:
+    ownerKinds: [DaemonSet]
+- name: process-namespaces
+  action: skip
+  match:
+    namespaceLabels:
+      matchLabels: {isolation: process}
+- name: non-batch
+  action: skip
+  match:
+    annotations:
+      matchExpressions:
+      - {key: tier, operator: NotIn, values: [batch]}
+    labels:
+      matchExpressions:
+      - {key: app, operator: Exists}
+matchConditions:
+- name: not-opted-out
+  expression: "!has(object.metadata.labels) || !('example.com/process-isolated' in object.metadata.labels)"
+`
+
+// compilePolicy compiles a MutatingAdmissionPolicy the way the API server's
+// mutating admission policy plugin does.
+func compilePolicy(policy *mutating.Policy) mutating.PolicyEvaluator {
+	opts := plugincel.OptionalVariableDeclarations{HasParams: policy.Spec.ParamKind != nil, HasAuthorizer: true}
+	compiler, err := plugincel.NewCompositedCompiler(environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()))
+	if err != nil {
+		panic(err)
+	}
+
+	var matcher matchconditions.Matcher
+	if len(policy.Spec.MatchConditions) > 0 {
+		accessors := make([]plugincel.ExpressionAccessor, len(policy.Spec.MatchConditions))
+		for i := range policy.Spec.MatchConditions {
+			accessors[i] = (*matchconditions.MatchCondition)(&policy.Spec.MatchConditions[i])
+		}
+		matcher = matchconditions.NewMatcher(compiler.CompileCondition(accessors, opts, environment.StoredExpressions), nil, "policy", "mutate", policy.Name)
+	}
+
+	opts.HasPatchTypes = true
+	var patchers []patch.Patcher
+	for _, m := range policy.Spec.Mutations {
+		accessor := &patch.ApplyConfigurationCondition{Expression: m.ApplyConfiguration.Expression}
+		patchers = append(patchers, patch.NewApplyConfigurationPatcher(compiler.CompileMutatingEvaluator(accessor, opts, environment.StoredExpressions)))
+	}
+	return mutating.PolicyEvaluator{Matcher: matcher, Mutators: patchers, CompositionEnv: compiler.CompositionEnv}
+}
+
+// newPolicyTestContext runs policy and binding in the API server's mutating
+// admission policy plugin.
+func newPolicyTestContext(t *testing.T, policy *v1beta1.MutatingAdmissionPolicy, binding *v1beta1.MutatingAdmissionPolicyBinding) *generic.PolicyTestContext[*mutating.Policy, *mutating.PolicyBinding, mutating.PolicyEvaluator] {
+	t.Helper()
+	namespaces := make([]runtime.Object, 0, len(policyNamespaces))
+	for _, ns := range policyNamespaces {
+		namespaces = append(namespaces, ns.DeepCopy())
+	}
+	tc, cancel, err := generic.NewPolicyTestContext(
+		t,
+		mutating.NewMutatingAdmissionPolicyAccessor,
+		mutating.NewMutatingAdmissionPolicyBindingAccessor,
+		compilePolicy,
+		func(a authorizer.Authorizer, m *matching.Matcher, _ kubernetes.Interface) generic.Dispatcher[mutating.PolicyHook] {
+			return mutating.NewDispatcher(a, m, patch.NewTypeConverterManager(nil, openapitest.NewEmbeddedFileClient()))
+		},
+		namespaces,
+		[]meta.RESTMapping{{
+			Resource:         schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Scope:            meta.RESTScopeNamespace,
+		}, {
+			Resource:         schema.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+			Scope:            meta.RESTScopeRoot,
+		}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(cancel)
+	if err := tc.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The test context serves policies and bindings as its own fake kinds.
+	policy, binding = policy.DeepCopy(), binding.DeepCopy()
+	policy.TypeMeta, binding.TypeMeta = metav1.TypeMeta{}, metav1.TypeMeta{}
+	// Fail on errors instead of admitting the pod unmodified.
+	policy.Spec.FailurePolicy = ptr.To(v1beta1.Fail)
+	if err := tc.UpdateAndWait(policy, binding); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tc
+}
+
+// TestPolicyConformance checks that the generated policy mutates the same pods
+// the same way as the webhook.
+func TestPolicyConformance(t *testing.T) {
+	rules, err := loadRulesFile(writeRules(t, policyRules))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name  string
+		rules *ruleSet
+	}{
+		{name: "default rules"},
+		{name: "rules file", rules: rules},
+	}
+
+	objects := make([]client.Object, 0, len(policyNamespaces))
+	for _, ns := range policyNamespaces {
+		objects = append(objects, ns.DeepCopy())
+	}
+	c := fake.NewClientBuilder().WithObjects(objects...).Build()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := policyOptions{Name: "hyperv", RuntimeClassName: testRuntimeClass, ExcludedNamespaces: []string{"kube-system"}, Rules: tc.rules}
+			policy, binding, err := mutatingAdmissionPolicy(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ptc := newPolicyTestContext(t, policy, binding)
+			pu := &podUpdater{
+				Client:             c,
+				ExcludedNamespaces: parseList("kube-system"),
+				Rules:              tc.rules,
+			}
+
+			mutated := 0
+			for _, raw := range append(append([]string(nil), loadgen.SynthesizedPods...), policyCorpus...) {
+				want, wasMutated := webhookOutput(t, pu, raw)
+				if wasMutated {
+					mutated++
+				}
+
+				got := &corev1.Pod{}
+				if err := json.Unmarshal([]byte(raw), got); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				// PolicyTestContext.Dispatch swaps the name and namespace
+				// of the attributes.
+				attr := admission.NewAttributesRecord(got, nil, corev1.SchemeGroupVersion.WithKind("Pod"), got.Namespace, got.Name,
+					corev1.SchemeGroupVersion.WithResource("pods"), "", admission.Create, &metav1.CreateOptions{}, false, nil)
+				if err := ptc.Plugin.Dispatch(ptc, attr, admission.NewObjectInterfacesFromScheme(scheme)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !equality.Semantic.DeepEqual(want, got) {
+					wantJSON, _ := json.Marshal(want)
+					gotJSON, _ := json.Marshal(got)
+					t.Errorf("policy and webhook disagree on %s\nwebhook: %s\npolicy:  %s", raw, wantJSON, gotJSON)
+				}
+			}
+			if mutated == 0 {
+				t.Error("expected the corpus to contain mutated pods")
+			}
+		})
+	}
+}
+
+// webhookOutput returns the pod as admitted by the webhook: the output of
+// mutatePodRaw if the webhook mutates it, and the pod unchanged otherwise.
+func webhookOutput(t *testing.T, pu *podUpdater, raw string) (*corev1.Pod, bool) {
+	t.Helper()
+	review := loadgen.PodReview([]byte(raw))
+	_, rec, err := handleRecorded(context.Background(), pu, ctrladmission.Request{AdmissionRequest: *review.Request})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := []byte(raw)
+	if rec.Decision == recording.DecisionMutated {
+		if out, err = mutatePodRaw(out, testRuntimeClass); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(out, pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return pod, rec.Decision == recording.DecisionMutated
+}
+
+func TestPolicyErrors(t *testing.T) {
+	tooMany := make([]mutationRule, commonconditions.MaxConditions)
+	for i := range tooMany {
+		tooMany[i] = mutationRule{Name: fmt.Sprintf("r%d", i), Action: ruleActionSkip, Match: ruleMatch{OwnerKinds: []string{"Job"}}}
+	}
+	rs, err := compileRules(tooMany)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := mutatingAdmissionPolicy(policyOptions{Name: "p", Rules: rs}); err == nil || !strings.Contains(err.Error(), "at most 64") {
+		t.Errorf("expected too many conditions to be rejected, got %v", err)
+	}
+
+	rs, err = compileRules([]mutationRule{{Name: "not a name", Action: ruleActionSkip, Match: ruleMatch{OwnerKinds: []string{"Job"}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := mutatingAdmissionPolicy(policyOptions{Name: "p", Rules: rs}); err == nil || !strings.Contains(err.Error(), "invalid name") {
+		t.Errorf("expected an invalid condition name to be rejected, got %v", err)
+	}
+}
+
+func TestRunPolicy(t *testing.T) {
+	var out bytes.Buffer
+	if err := runPolicy([]string{"--name=hv", "--excluded-namespaces=kube-system", "--runtime-class-name=" + testRuntimeClass}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"kind: MutatingAdmissionPolicy\n",
+		"kind: MutatingAdmissionPolicyBinding\n",
+		"policyName: hv",
+		"name: rule-custom-node-selector",
+		"- kube-system",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the output to contain %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := runPolicy([]string{"extra"}, &out); err == nil {
+		t.Error("expected positional arguments to be rejected")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// e2ePod is shaped like the hostNetwork pods the e2e suite creates in bulk,
+// with the env, volumes and probes that make full decoding expensive.
+const e2ePod = `{
+  "apiVersion": "v1", "kind": "Pod",
+  "metadata": {"generateName": "netserver-", "namespace": "nettest-1234",
+    "labels": {"selector-0": "true", "pod-template-hash": "abc"},
+    "annotations": {"kubernetes.io/psp": "e2e-test-privileged-psp"}},
+  "spec": {
+    "hostNetwork": true,
+    "nodeSelector": {"kubernetes.io/os": "windows", "kubernetes.io/hostname": "win-node-1"},
+    "containers": [{
+      "name": "webserver", "image": "registry.k8s.io/e2e-test-images/agnhost:2.47",
+      "args": ["netexec", "--http-port=8083", "--udp-port=8081"],
+      "env": [{"name": "A", "value": "1"}, {"name": "B", "value": "2"}, {"name": "C", "value": "3"},
+        {"name": "POD_IP", "valueFrom": {"fieldRef": {"fieldPath": "status.podIP"}}}],
+      "ports": [{"name": "http", "containerPort": 8083}, {"name": "udp", "containerPort": 8081, "protocol": "UDP"}],
+      "livenessProbe": {"httpGet": {"path": "/healthz", "port": 8083}, "initialDelaySeconds": 10, "periodSeconds": 10},
+      "readinessProbe": {"httpGet": {"path": "/healthz", "port": 8083}, "initialDelaySeconds": 10, "periodSeconds": 10},
+      "resources": {"requests": {"cpu": "100m", "memory": "64Mi"}, "limits": {"cpu": "200m", "memory": "128Mi"}},
+      "volumeMounts": [{"name": "kube-api-access", "mountPath": "/var/run/secrets/kubernetes.io/serviceaccount", "readOnly": true}]
+    }],
+    "volumes": [{"name": "kube-api-access", "projected": {"sources": [
+      {"serviceAccountToken": {"expirationSeconds": 3607, "path": "token"}},
+      {"configMap": {"name": "kube-root-ca.crt", "items": [{"key": "ca.crt", "path": "ca.crt"}]}},
+      {"downwardAPI": {"items": [{"path": "namespace", "fieldRef": {"apiVersion": "v1", "fieldPath": "metadata.namespace"}}]}}]}}],
+    "tolerations": [{"key": "node.kubernetes.io/not-ready", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 300}]
+  }
+}`
+
+func TestPrecheckMatchesFullDecode(t *testing.T) {
+	pods := []string{
+		e2ePod,
+		`{"spec":{"containers":[{"name":"c","image":"busybox"}]}}`,
+		`{"spec":{"securityContext":{"windowsOptions":{"hostProcess":true}},"containers":[{"name":"c"}]}}`,
+		`{"spec":{"securityContext":{"windowsOptions":{"hostProcess":false}},"containers":[{"name":"c"}]}}`,
+		`{"spec":{"containers":[{"name":"c","securityContext":{"windowsOptions":{"hostProcess":true}}}]}}`,
+		`{"spec":{"initContainers":[{"name":"i","securityContext":{"windowsOptions":{"hostProcess":true}}}],"containers":[{"name":"c"}]}}`,
+		`{"spec":{"nodeSelector":{"kubernetes.io/os":"linux"},"containers":[{"name":"c"}]}}`,
+		`{"spec":{"nodeSelector":{"disktype":"ssd"},"containers":[{"name":"c"}]}}`,
+		`{"metadata":{"name":"p"}}`,
+	}
+
+	for _, raw := range pods {
+		full := &corev1.Pod{}
+		if err := json.Unmarshal([]byte(raw), full); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sparse, err := precheckRaw([]byte(raw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := podSkipReason(sparse), podSkipReason(full); got != want {
+			t.Errorf("precheck skip reason %q, full decode %q for %s", got, want, raw)
+		}
+		if len(sparse.Spec.Containers) != len(full.Spec.Containers) {
+			t.Errorf("expected %d containers, got %d", len(full.Spec.Containers), len(sparse.Spec.Containers))
+		}
+	}
+
+	if _, err := precheckRaw([]byte(`{"spec":`)); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+}
+
+// fullDecodeSkipReason is the decision path without the precheck, kept for
+// comparison in benchmarks.
+func fullDecodeSkipReason(pu *podUpdater, req admission.Request) string {
+	_, pod, err := decodePodRaw(req.Object.Raw)
+	if err != nil {
+		panic(err)
+	}
+	return pu.skipReason(context.Background(), pod)
+}
+
+func e2eRequest() admission.Request {
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: "nettest-1234",
+		Object:    runtime.RawExtension{Raw: []byte(e2ePod)},
+	}}
+}
+
+func BenchmarkSkipDecision(b *testing.B) {
+	pu := &podUpdater{}
+	req := e2eRequest()
+
+	b.Run("precheck", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sparse, err := precheckRaw(req.Object.Raw)
+			if err != nil || podSkipReason(sparse) != skipHostNetwork {
+				b.Fatal("expected the pod to be skipped")
+			}
+		}
+	})
+	b.Run("full-decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if fullDecodeSkipReason(pu, req) != skipHostNetwork {
+				b.Fatal("expected the pod to be skipped")
+			}
+		}
+	})
+}
+
+// BenchmarkHandleUnderLoad runs skipped admissions through Handle in
+// parallel, with and without the precheck, and reports the p99 latency next
+// to the mean.
+func BenchmarkHandleUnderLoad(b *testing.B) {
+	req := e2eRequest()
+
+	for _, tc := range []struct {
+		name string
+		pu   *podUpdater
+	}{
+		{name: "precheck", pu: &podUpdater{}},
+		{name: "full-decode", pu: &podUpdater{noPrecheck: true}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			benchmarkP99(b, func() {
+				if resp := tc.pu.Handle(context.Background(), req); !resp.Allowed || len(resp.Patches) != 0 {
+					b.Fatal("expected the pod to be admitted unmodified")
+				}
+			})
+		})
+	}
+}
+
+func benchmarkP99(b *testing.B, fn func()) {
+	b.ReportAllocs()
+	var mu sync.Mutex
+	var latencies []time.Duration
+	b.RunParallel(func(pb *testing.PB) {
+		var local []time.Duration
+		for pb.Next() {
+			start := time.Now()
+			fn()
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// recorderWebhookName identifies records written by this webhook.
+const recorderWebhookName = "hyperv"
+
+type skipReasonKey struct{}
+
+// noteSkipReason passes the skip reason of a request to the recording handler
+// and records it on the current span.
+func noteSkipReason(ctx context.Context, reason string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("admission.skip_reason", reason))
+	if p, ok := ctx.Value(skipReasonKey{}).(*string); ok {
+		*p = reason
+	}
+}
+
+// recordingHandler records every request and response of Handler. Failing to
+// record never fails the admission request.
+type recordingHandler struct {
+	Handler  admission.Handler
+	Recorder *recording.Recorder
+}
+
+func (h *recordingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	resp, rec, err := handleRecorded(ctx, h.Handler, req)
+	if err == nil {
+		err = h.Recorder.Record(rec)
+	}
+	if err != nil {
+		webhookLogger.Error(err, "unable to record admission request", "uid", req.UID)
+	}
+	return resp
+}
+
+// handleRecorded runs handler and returns its response with the unredacted
+// record of the request.
+func handleRecorded(ctx context.Context, handler admission.Handler, req admission.Request) (admission.Response, recording.Record, error) {
+	reason := new(string)
+	start := time.Now()
+	resp := handler.Handle(context.WithValue(ctx, skipReasonKey{}, reason), req)
+	rec := recording.Record{
+		Time:          start.UTC(),
+		Request:       &req.AdmissionRequest,
+		Reason:        *reason,
+		LatencyMicros: time.Since(start).Microseconds(),
+	}
+	err := rec.SetResponse(&resp.AdmissionResponse, resp.Patches)
+	return resp, rec, err
+}
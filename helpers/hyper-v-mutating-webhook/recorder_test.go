@@ -0,0 +1,158 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/loadgen"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+const podWithSecrets = `{"metadata":{"name":"p","namespace":"e2e","annotations":{` +
+	`"api-token":"abc","kubectl.kubernetes.io/last-applied-configuration":"{\"spec\":{\"containers\":[{\"env\":[{\"name\":\"A\",\"value\":\"leak\"}]}]}}"}},` +
+	`"spec":{"nodeSelector":{"kubernetes.io/os":"windows"},"containers":[{"name":"c","image":"busybox","env":[` +
+	`{"name":"PLAIN","value":"visible"},{"name":"DB_PASSWORD","value":"hunter2"},` +
+	`{"name":"FROM_SECRET","valueFrom":{"secretKeyRef":{"name":"s","key":"k"}}}]}]}}`
+
+// recordPods sends pods through a recording podUpdater and returns the
+// recording directory.
+func recordPods(t *testing.T, maxBytes int64, pods ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	redact, err := recording.NewRedaction(true, recording.DefaultRedactPattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := &recording.RotatingFile{Path: recording.FilePath(dir), MaxBytes: maxBytes, MaxFiles: 10}
+	defer out.Close()
+	h := &recordingHandler{
+		Handler:  &podUpdater{ExcludedNamespaces: parseList("kube-system")},
+		Recorder: &recording.Recorder{Webhook: recorderWebhookName, Redact: redact, Out: out},
+	}
+	for i, pod := range pods {
+		review := loadgen.PodReview([]byte(pod))
+		review.Request.UID = types.UID(string(rune('a' + i)))
+		resp := h.Handle(context.Background(), admission.Request{AdmissionRequest: *review.Request})
+		if !resp.Allowed {
+			t.Fatalf("expected pod %d to be allowed: %v", i, resp.Result)
+		}
+	}
+	return dir
+}
+
+func TestRecordingHandler(t *testing.T) {
+	dir := recordPods(t, 1024,
+		podWithSecrets,
+		loadgen.SynthesizedPods[1],
+		`{"metadata":{"name":"sys","namespace":"kube-system"},"spec":{"containers":[{"name":"c","image":"pause"}]}}`,
+	)
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) < 2 {
+		t.Errorf("expected the recording to be rotated, got %d files", len(entries))
+	}
+	records, err := recording.Read([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	want := []struct {
+		uid      types.UID
+		decision string
+		reason   string
+	}{
+		{"a", recording.DecisionMutated, ""},
+		{"b", recording.DecisionAdmitted, skipHostNetwork},
+		{"c", recording.DecisionAdmitted, skipExcludedNamespace},
+	}
+	for i, w := range want {
+		rec := records[i]
+		if rec.Kind != recording.Kind || rec.Webhook != recorderWebhookName {
+			t.Errorf("record %d: unexpected kind %q or webhook %q", i, rec.Kind, rec.Webhook)
+		}
+		if rec.Request.UID != w.uid || rec.Decision != w.decision || rec.Reason != w.reason {
+			t.Errorf("record %d: expected %s %s %q, got %s %s %q",
+				i, w.uid, w.decision, w.reason, rec.Request.UID, rec.Decision, rec.Reason)
+		}
+	}
+	if len(records[0].Patch) == 0 {
+		t.Error("expected the response patch to be recorded")
+	}
+	if bytes.Contains(records[0].Request.Object.Raw, []byte("hunter2")) {
+		t.Error("expected env values to be redacted")
+	}
+
+	// Recordings can be used as loadgen payloads.
+	payloads, err := loadgen.LoadPayloads(filepath.Join(dir, "admissions.jsonl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(payloads) == 0 || payloads[0].Kind != "AdmissionReview" || payloads[0].Request == nil {
+		t.Errorf("expected recorded requests as payloads, got %+v", payloads)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := recordPods(t, 0, podWithSecrets, loadgen.SynthesizedPods[1], loadgen.SynthesizedPods[4])
+	hpcRecord := recording.Record{Kind: recording.Kind, Webhook: "hpc", Request: &admissionv1.AdmissionRequest{UID: "hpc"}}
+	line, _ := json.Marshal(hpcRecord)
+	f, err := os.OpenFile(filepath.Join(dir, "admissions.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Write(append(line, '\n'))
+	f.Close()
+
+	var out bytes.Buffer
+	if err := runReplay([]string{"--fail-on-diff", dir}, &out); err != nil {
+		t.Fatalf("expected an unchanged replay, got %v:\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "3 unchanged, 0 changed, 1 skipped") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = runReplay([]string{"--fail-on-diff", "--excluded-namespaces=e2e", dir}, &out)
+	if err == nil {
+		t.Fatal("expected the changed outcome to fail the replay")
+	}
+	for _, want := range []string{
+		"CHANGED a CREATE e2e/-",
+		"decision: mutated -> admitted",
+		"reason: - -> " + skipExcludedNamespace,
+		"- add /spec/runtimeClassName",
+		"reason: " + skipHostNetwork + " -> " + skipExcludedNamespace,
+		"0 unchanged, 3 changed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected replay output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// runReplay implements the "replay" subcommand, which feeds recorded
+// admissions back through the pod handler configured by its flags and prints
+// the requests whose outcome changed.
+func runReplay(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.StringVar(&runtimeClassName, "runtime-class-name", runtimeClassName, "The RuntimeClass injected into pods.")
+	excludedNamespaces := fs.String("excluded-namespaces", "", "Comma-separated list of namespaces whose pods are never mutated.")
+	rulesFile := fs.String("rules-file", "", "YAML file with mutation rules. Defaults to the built-in rules.")
+	samplePercent := fs.Float64("sample-percent", 100, "Percentage of workloads that get Hyper-V isolation.")
+	sampleSeed := fs.String("sample-seed", "", "Seed that changes which workloads --sample-percent selects.")
+	shadow := fs.Bool("shadow", false, "Replay in shadow mode.")
+	failOnDiff := fs.Bool("fail-on-diff", false, "Exit with an error if any outcome changed.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: manager replay [flags] RECORDING...\n\n"+
+			"RECORDING is a file or a --record-path directory written by the recorder.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one recording is required")
+	}
+
+	updater := &podUpdater{
+		ExcludedNamespaces: parseList(*excludedNamespaces),
+		Shadow:             *shadow,
+	}
+	var err error
+	if *rulesFile != "" {
+		if updater.Rules, err = loadRulesFile(*rulesFile); err != nil {
+			return err
+		}
+	}
+	if *samplePercent < 100 {
+		if updater.Sampler, err = newSampler(*samplePercent, *sampleSeed); err != nil {
+			return err
+		}
+	}
+
+	records, err := recording.Read(fs.Args())
+	if err != nil {
+		return err
+	}
+	results, skipped, err := recording.Replay(recorderWebhookName, records, func(req *admissionv1.AdmissionRequest) (recording.Record, error) {
+		_, rec, err := handleRecorded(context.Background(), updater, admission.Request{AdmissionRequest: *req})
+		return rec, err
+	})
+	if err != nil {
+		return err
+	}
+	if changed := recording.PrintResults(out, results, skipped); changed > 0 && *failOnDiff {
+		return fmt.Errorf("%d of %d replayed admissions changed", changed, len(results))
+	}
+	return nil
+}
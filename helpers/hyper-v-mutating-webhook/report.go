@@ -0,0 +1,116 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// shadowSummaryRow counts the pods that share a shadow decision and reason.
+type shadowSummaryRow struct {
+	Decision string
+	Reason   string
+	Pods     []string
+}
+
+// summarizeShadowDecisions groups pods by the shadow decision annotations set
+// in shadow mode. Pods admitted outside shadow mode are counted as
+// "unrecorded".
+func summarizeShadowDecisions(pods []corev1.Pod) []shadowSummaryRow {
+	rows := map[string]*shadowSummaryRow{}
+	for _, p := range pods {
+		decision := p.Annotations[shadowDecisionAnnotation]
+		if decision == "" {
+			decision = "unrecorded"
+		}
+		reason := p.Annotations[shadowReasonAnnotation]
+		key := decision + "/" + reason
+		if rows[key] == nil {
+			rows[key] = &shadowSummaryRow{Decision: decision, Reason: reason}
+		}
+		rows[key].Pods = append(rows[key].Pods, p.Name)
+	}
+
+	out := make([]shadowSummaryRow, 0, len(rows))
+	for _, r := range rows {
+		sort.Strings(r.Pods)
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Decision != out[j].Decision {
+			return out[i].Decision < out[j].Decision
+		}
+		return out[i].Reason < out[j].Reason
+	})
+	return out
+}
+
+func printShadowSummary(w io.Writer, namespace string, rows []shadowSummaryRow, listPods bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAMESPACE\tDECISION\tREASON\tPODS\n")
+	total := 0
+	for _, r := range rows {
+		reason := r.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		count := fmt.Sprintf("%d", len(r.Pods))
+		if listPods {
+			count += " (" + strings.Join(r.Pods, ", ") + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", namespace, r.Decision, reason, count)
+		total += len(r.Pods)
+	}
+	fmt.Fprintf(tw, "%s\ttotal\t\t%d\n", namespace, total)
+	return tw.Flush()
+}
+
+// runReport implements the "report" subcommand, which summarizes the shadow
+// decisions recorded on the pods of a namespace.
+func runReport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	namespace := fs.String("namespace", "default", "The namespace whose pods are summarized.")
+	listPods := fs.Bool("list-pods", false, "List the pod names for each decision.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods, client.InNamespace(*namespace)); err != nil {
+		return fmt.Errorf("listing pods in %s: %w", *namespace, err)
+	}
+	return printShadowSummary(out, *namespace, summarizeShadowDecisions(pods.Items), *listPods)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+	"windows.k8s.io/webhook-common/matchconditions"
+)
+
+const (
+	// ruleActionSkip admits matching pods unmodified.
+	ruleActionSkip = "skip"
+	// ruleActionForce injects the runtime class into matching pods even if
+	// the node gate or compatibility mode would skip them.
+	ruleActionForce = "force"
+)
+
+var ruleMatches = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "hyperv_webhook_rule_matches_total",
+		Help: "Pods matched by a mutation rule, by rule name and action.",
+	},
+	[]string{"rule", "action"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(ruleMatches)
+}
+
+// ruleConfig is the format of the --rules-file.
+type ruleConfig struct {
+	Rules []mutationRule `json:"rules"`
+	// MatchConditions are CEL expressions that must all be true for a pod
+	// to be mutated. They are evaluated before the rules.
+	MatchConditions []matchconditions.Condition `json:"matchConditions,omitempty"`
+}
+
+// mutationRule skips or forces injection for the pods it matches. All set
+// matchers must match.
+type mutationRule struct {
+	Name   string    `json:"name"`
+	Action string    `json:"action"`
+	Match  ruleMatch `json:"match"`
+}
+
+type ruleMatch struct {
+	// Labels matches the pod labels.
+	Labels *metav1.LabelSelector `json:"labels,omitempty"`
+	// Annotations matches the pod annotations.
+	Annotations *metav1.LabelSelector `json:"annotations,omitempty"`
+	// NodeSelector matches the pod's spec.nodeSelector. It never matches
+	// pods without a nodeSelector.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// OwnerKinds matches pods with an owner reference of one of the kinds,
+	// e.g. DaemonSet or Job.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// NamespaceLabels matches the labels of the pod's namespace.
+	NamespaceLabels *metav1.LabelSelector `json:"namespaceLabels,omitempty"`
+}
+
+// defaultRules are used when no --rules-file is given.
+var defaultRules = []mutationRule{
+	{
+		// Pods with custom nodeSelectors but no kubernetes.io/os selector are
+		// likely test fixtures (e.g. ResourceQuota tests with unsatisfiable
+		// selectors) that are not intended to run as Windows workloads.
+		// Injecting overhead into them would break resource accounting in
+		// those tests.
+		Name:   "custom-node-selector",
+		Action: ruleActionSkip,
+		Match: ruleMatch{NodeSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      corev1.LabelOSStable,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			}},
+		}},
+	},
+}
+
+var defaultRuleSet = mustCompileRules(defaultRules)
+
+// compiledRule is a validated mutationRule with parsed selectors.
+type compiledRule struct {
+	name            string
+	action          string
+	labels          labels.Selector
+	annotations     labels.Selector
+	nodeSelector    labels.Selector
+	ownerKinds      map[string]struct{}
+	namespaceLabels labels.Selector
+}
+
+// ruleSet evaluates rules in order; the first matching rule wins.
+type ruleSet struct {
+	rules      []compiledRule
+	conditions []matchconditions.Compiled
+}
+
+func loadRulesFile(path string) (*ruleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := ruleConfig{}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	rs, err := compileRules(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if rs.conditions, err = matchconditions.Compile(cfg.MatchConditions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+func compileRules(rules []mutationRule) (*ruleSet, error) {
+	rs := &ruleSet{}
+	seen := map[string]bool{}
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", r.Name)
+		}
+		seen[r.Name] = true
+		if r.Action != ruleActionSkip && r.Action != ruleActionForce {
+			return nil, fmt.Errorf("rule %s: unknown action %q, must be %s or %s", r.Name, r.Action, ruleActionSkip, ruleActionForce)
+		}
+
+		c := compiledRule{name: r.Name, action: r.Action}
+		var err error
+		for _, s := range []struct {
+			field    string
+			selector *metav1.LabelSelector
+			out      *labels.Selector
+		}{
+			{"labels", r.Match.Labels, &c.labels},
+			{"annotations", r.Match.Annotations, &c.annotations},
+			{"nodeSelector", r.Match.NodeSelector, &c.nodeSelector},
+			{"namespaceLabels", r.Match.NamespaceLabels, &c.namespaceLabels},
+		} {
+			if s.selector == nil {
+				continue
+			}
+			if *s.out, err = metav1.LabelSelectorAsSelector(s.selector); err != nil {
+				return nil, fmt.Errorf("rule %s: %s: %w", r.Name, s.field, err)
+			}
+		}
+		if len(r.Match.OwnerKinds) > 0 {
+			c.ownerKinds = map[string]struct{}{}
+			for _, k := range r.Match.OwnerKinds {
+				c.ownerKinds[k] = struct{}{}
+			}
+		}
+		if c.labels == nil && c.annotations == nil && c.nodeSelector == nil && c.ownerKinds == nil && c.namespaceLabels == nil {
+			return nil, fmt.Errorf("rule %s: at least one matcher is required", r.Name)
+		}
+		rs.rules = append(rs.rules, c)
+	}
+	return rs, nil
+}
+
+// mustCompileRules is compileRules for rules known to be valid.
+func mustCompileRules(rules []mutationRule) *ruleSet {
+	rs, err := compileRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// match returns the first rule matching the pod, or nil. namespaceLabels is
+// only called for rules with a namespace matcher.
+func (rs *ruleSet) match(pod *corev1.Pod, namespaceLabels func() (labels.Set, error)) (*compiledRule, error) {
+	for i := range rs.rules {
+		r := &rs.rules[i]
+		if r.labels != nil && !r.labels.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if r.annotations != nil && !r.annotations.Matches(labels.Set(pod.Annotations)) {
+			continue
+		}
+		if r.nodeSelector != nil && (len(pod.Spec.NodeSelector) == 0 || !r.nodeSelector.Matches(labels.Set(pod.Spec.NodeSelector))) {
+			continue
+		}
+		if r.ownerKinds != nil && !hasOwnerKind(pod, r.ownerKinds) {
+			continue
+		}
+		if r.namespaceLabels != nil {
+			nsLabels, err := namespaceLabels()
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+			if !r.namespaceLabels.Matches(nsLabels) {
+				continue
+			}
+		}
+		return r, nil
+	}
+	return nil, nil
+}
+
+func hasOwnerKind(pod *corev1.Pod, kinds map[string]struct{}) bool {
+	for _, o := range pod.OwnerReferences {
+		if _, ok := kinds[o.Kind]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleReason is the skip reason reported for pods skipped by a rule.
+func ruleReason(name string) string {
+	return "rule:" + name
+}
+
+// namespaceLabelGetter returns a lazy lookup of the namespace's labels.
+func namespaceLabelGetter(ctx context.Context, c client.Reader, namespace string) func() (labels.Set, error) {
+	return func() (labels.Set, error) {
+		if c == nil {
+			return nil, fmt.Errorf("no client to read namespace %s", namespace)
+		}
+		ns := &corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return nil, fmt.Errorf("getting namespace %s: %w", namespace, err)
+		}
+		return labels.Set(ns.Labels), nil
+	}
+}
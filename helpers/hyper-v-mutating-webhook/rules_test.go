@@ -0,0 +1,229 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testRules = `
+rules:
+- name: quota-fixtures
+  action: skip
+  match:
+    nodeSelector:
+      matchExpressions:
+      - {key: kubernetes.io/os, operator: DoesNotExist}
+- name: daemonsets
+  action: skip
+  match:
+    ownerKinds: [DaemonSet]
+- name: opt-out
+  action: skip
+  match:
+    annotations:
+      matchLabels:
+        example.com/hyperv: "false"
+- name: always-hyperv
+  action: force
+  match:
+    labels:
+      matchLabels:
+        app: critical
+- name: e2e-namespaces
+  action: skip
+  match:
+    namespaceLabels:
+      matchLabels:
+        e2e-framework: img-puller
+`
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestLoadRulesFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "missing name",
+			content: "rules:\n- action: skip\n  match:\n    ownerKinds: [Job]\n",
+			wantErr: "name is required",
+		},
+		{
+			name:    "duplicate name",
+			content: "rules:\n- {name: a, action: skip, match: {ownerKinds: [Job]}}\n- {name: a, action: skip, match: {ownerKinds: [Job]}}\n",
+			wantErr: "duplicate name",
+		},
+		{
+			name:    "unknown action",
+			content: "rules:\n- {name: a, action: allow, match: {ownerKinds: [Job]}}\n",
+			wantErr: "unknown action",
+		},
+		{
+			name:    "no matcher",
+			content: "rules:\n- {name: a, action: skip}\n",
+			wantErr: "at least one matcher",
+		},
+		{
+			name:    "invalid selector",
+			content: "rules:\n- {name: a, action: skip, match: {labels: {matchExpressions: [{key: app, operator: Bogus}]}}}\n",
+			wantErr: "labels",
+		},
+		{
+			name:    "unknown field",
+			content: "rules:\n- {name: a, action: skip, match: {owner: [Job]}}\n",
+			wantErr: "unknown field",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadRulesFile(writeRules(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRulesSkipReason(t *testing.T) {
+	rules, err := loadRulesFile(writeRules(t, testRules))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "puller", Labels: map[string]string{"e2e-framework": "img-puller"}}},
+	).Build()
+	// No capable nodes: only forced pods are mutated.
+	gate := newNodeGate(fake.NewClientBuilder().WithObjects(testRuntimeClassObject()).Build(), "", 0)
+	pu := &podUpdater{Client: c, Rules: rules}
+	gated := &podUpdater{Client: c, Rules: rules, Nodes: gate}
+
+	pod := func(mutate func(p *corev1.Pod)) *corev1.Pod {
+		p := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default"}}
+		mutate(p)
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		updater *podUpdater
+		pod     *corev1.Pod
+		want    string
+	}{
+		{
+			name:    "no rule matches",
+			updater: pu,
+			pod:     pod(func(p *corev1.Pod) {}),
+			want:    "",
+		},
+		{
+			name:    "nodeSelector without os",
+			updater: pu,
+			pod:     pod(func(p *corev1.Pod) { p.Spec.NodeSelector = map[string]string{"disktype": "ssd"} }),
+			want:    "rule:quota-fixtures",
+		},
+		{
+			name:    "owner kind",
+			updater: pu,
+			pod: pod(func(p *corev1.Pod) {
+				p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds"}}
+			}),
+			want: "rule:daemonsets",
+		},
+		{
+			name:    "annotation",
+			updater: pu,
+			pod:     pod(func(p *corev1.Pod) { p.Annotations = map[string]string{"example.com/hyperv": "false"} }),
+			want:    "rule:opt-out",
+		},
+		{
+			name:    "namespace label",
+			updater: pu,
+			pod:     pod(func(p *corev1.Pod) { p.Namespace = "puller" }),
+			want:    "rule:e2e-namespaces",
+		},
+		{
+			name:    "hard checks are not overridden by force",
+			updater: pu,
+			pod: pod(func(p *corev1.Pod) {
+				p.Labels = map[string]string{"app": "critical"}
+				p.Spec.HostNetwork = true
+			}),
+			want: skipHostNetwork,
+		},
+		{
+			name:    "force bypasses the node gate",
+			updater: gated,
+			pod:     pod(func(p *corev1.Pod) { p.Labels = map[string]string{"app": "critical"} }),
+			want:    "",
+		},
+		{
+			name:    "unmatched pod is gated",
+			updater: gated,
+			pod:     pod(func(p *corev1.Pod) {}),
+			want:    skipNoCapableNodes,
+		},
+		{
+			name:    "missing namespace fails open",
+			updater: pu,
+			pod:     pod(func(p *corev1.Pod) { p.Namespace = "missing" }),
+			want:    skipRuleEvaluationFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.updater.skipReason(context.Background(), tc.pod); got != tc.want {
+				t.Errorf("skipReason() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatchesMetric(t *testing.T) {
+	pu := &podUpdater{}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{"disktype": "ssd"}}}
+
+	before := testutil.ToFloat64(ruleMatches.WithLabelValues("custom-node-selector", ruleActionSkip))
+	if got := pu.skipReason(context.Background(), pod); got != "rule:custom-node-selector" {
+		t.Errorf("expected the default rule to skip the pod, got %q", got)
+	}
+	after := testutil.ToFloat64(ruleMatches.WithLabelValues("custom-node-selector", ruleActionSkip))
+	if after != before+1 {
+		t.Errorf("expected rule metric to increase by 1, got %v -> %v", before, after)
+	}
+}
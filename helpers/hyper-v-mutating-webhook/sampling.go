@@ -0,0 +1,114 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// isolationAnnotation records the isolation a pod was admitted with when
+	// sampling is enabled, so test results can be split by isolation.
+	isolationAnnotation = "hyperv-runtimeclass-mutating-webhook/isolation"
+
+	isolationHyperV  = "hyperv"
+	isolationProcess = "process"
+
+	// sampleBuckets is the resolution of the sampling percentage.
+	sampleBuckets = 10000
+)
+
+var sampledPods = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "hyperv_webhook_sampled_pods_total",
+		Help: "Pods admitted while sampling is enabled, by recorded isolation.",
+	},
+	[]string{"isolation"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(sampledPods)
+}
+
+// sampler selects a deterministic fraction of workloads for Hyper-V
+// isolation. Pods are grouped by namespace and controlling owner, so all pods
+// of a workload get the same isolation, and the same seed selects the same
+// workloads in every run.
+type sampler struct {
+	// Percent of workloads that get Hyper-V isolation, between 0 and 100.
+	Percent float64
+	// Seed changes which workloads are selected.
+	Seed string
+}
+
+func newSampler(percent float64, seed string) (*sampler, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("sample percent must be between 0 and 100, got %v", percent)
+	}
+	return &sampler{Percent: percent, Seed: seed}, nil
+}
+
+// sampleKey identifies the workload a pod belongs to. Pods without a
+// controller are keyed by their generateName, or their name.
+func sampleKey(pod *corev1.Pod) string {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return pod.Namespace + "/" + owner.Kind + "/" + owner.Name
+	}
+	if pod.GenerateName != "" {
+		return pod.Namespace + "/" + pod.GenerateName
+	}
+	return pod.Namespace + "/" + pod.Name
+}
+
+// selected reports whether the pod's workload is sampled for Hyper-V
+// isolation.
+func (s *sampler) selected(pod *corev1.Pod) bool {
+	h := fnv.New64a()
+	h.Write([]byte(s.Seed))
+	h.Write([]byte{0})
+	h.Write([]byte(sampleKey(pod)))
+	return float64(h.Sum64()%sampleBuckets) < s.Percent*sampleBuckets/100
+}
+
+// podIsolation returns the isolation recorded for a pod with the given
+// runtime class. Pods with any other runtime class are reported as process
+// isolated.
+func podIsolation(pod *corev1.Pod, hypervRuntimeClass string) string {
+	if pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName == hypervRuntimeClass {
+		return isolationHyperV
+	}
+	return isolationProcess
+}
+
+// sampledResponse admits the pod as mutated (which may be the original pod)
+// with the isolation it runs with recorded in isolationAnnotation.
+func sampledResponse(rawObject, mutated []byte, isolation string) admission.Response {
+	sampledPods.WithLabelValues(isolation).Inc()
+	annotated, err := annotatePodRaw(mutated, map[string]string{isolationAnnotation: isolation})
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(rawObject, annotated)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func ownedPod(namespace, name, owner string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "ReplicaSet", Name: owner, Controller: &controller},
+		},
+	}}
+}
+
+func TestNewSampler(t *testing.T) {
+	for _, p := range []float64{-1, 100.5} {
+		if _, err := newSampler(p, ""); err == nil {
+			t.Errorf("expected an error for percent %v", p)
+		}
+	}
+}
+
+func TestSamplerSelected(t *testing.T) {
+	s, err := newSampler(30, "seed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Pods of the same workload share a decision.
+	a := s.selected(ownedPod("ns", "web-1", "web"))
+	for i := 2; i < 10; i++ {
+		if s.selected(ownedPod("ns", fmt.Sprintf("web-%d", i), "web")) != a {
+			t.Fatal("expected all pods of a workload to share a decision")
+		}
+	}
+
+	// The fraction of selected workloads approaches the percentage.
+	selected := 0
+	const workloads = 5000
+	for i := 0; i < workloads; i++ {
+		if s.selected(ownedPod(fmt.Sprintf("ns-%d", i%50), "p", fmt.Sprintf("rs-%d", i))) {
+			selected++
+		}
+	}
+	if got := float64(selected) / workloads * 100; got < 27 || got > 33 {
+		t.Errorf("expected about 30%% of workloads to be selected, got %.1f%%", got)
+	}
+
+	// The seed changes the selection; the same seed reproduces it.
+	other, _ := newSampler(30, "other")
+	same, _ := newSampler(30, "seed")
+	differs := false
+	for i := 0; i < 100; i++ {
+		pod := ownedPod("ns", "p", fmt.Sprintf("rs-%d", i))
+		if s.selected(pod) != same.selected(pod) {
+			t.Fatal("expected the same seed to select the same workloads")
+		}
+		if s.selected(pod) != other.selected(pod) {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("expected a different seed to select different workloads")
+	}
+
+	none, _ := newSampler(0, "")
+	all, _ := newSampler(100, "")
+	pod := ownedPod("ns", "p", "rs")
+	if none.selected(pod) || !all.selected(pod) {
+		t.Error("expected 0% to select nothing and 100% to select everything")
+	}
+}
+
+func TestHandleSampled(t *testing.T) {
+	tests := []struct {
+		name          string
+		percent       float64
+		raw           string
+		wantIsolation string
+	}{
+		{
+			name:          "sampled pod gets hyper-v",
+			percent:       100,
+			raw:           `{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`,
+			wantIsolation: isolationHyperV,
+		},
+		{
+			name:          "unsampled pod stays process isolated",
+			percent:       0,
+			raw:           `{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`,
+			wantIsolation: isolationProcess,
+		},
+		{
+			name:          "skipped pod is recorded as process isolated",
+			percent:       100,
+			raw:           `{"metadata":{"name":"p1"},"spec":{"hostNetwork":true,"containers":[{"name":"c","image":"busybox"}]}}`,
+			wantIsolation: isolationProcess,
+		},
+		{
+			name:          "other runtime class is not hyper-v",
+			percent:       100,
+			raw:           `{"metadata":{"name":"p1"},"spec":{"runtimeClassName":"other","containers":[{"name":"c","image":"busybox"}]}}`,
+			wantIsolation: isolationProcess,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newSampler(tc.percent, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pu := &podUpdater{Sampler: s}
+			resp := pu.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: []byte(tc.raw)},
+			}})
+			if !resp.Allowed {
+				t.Fatalf("expected request to be allowed, got %v", resp.Result)
+			}
+
+			patched := decodeRaw(t, applyPatches(t, []byte(tc.raw), resp))
+			if got := mapAt(patched, "metadata", "annotations")[isolationAnnotation]; got != tc.wantIsolation {
+				t.Errorf("expected isolation %q, got %v", tc.wantIsolation, got)
+			}
+			rc := mapAt(patched, "spec")["runtimeClassName"]
+			if (tc.wantIsolation == isolationHyperV) != (rc == runtimeClassName) {
+				t.Errorf("isolation %q does not match runtimeClassName %v", tc.wantIsolation, rc)
+			}
+		})
+	}
+}
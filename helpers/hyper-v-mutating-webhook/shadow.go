@@ -0,0 +1,113 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gomodules.xyz/jsonpatch/v2"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// shadowDecisionAnnotation records whether the pod would have been
+	// mutated ("mutate") or not ("skip") when running in shadow mode.
+	shadowDecisionAnnotation = "hyperv-runtimeclass-mutating-webhook/shadow-decision"
+	// shadowReasonAnnotation records why a pod would have been skipped.
+	shadowReasonAnnotation = "hyperv-runtimeclass-mutating-webhook/shadow-reason"
+	// shadowPatchAnnotation records the JSON patch that would have been
+	// applied to the pod.
+	shadowPatchAnnotation = "hyperv-runtimeclass-mutating-webhook/shadow-patch"
+
+	shadowDecisionMutate = "mutate"
+	shadowDecisionSkip   = "skip"
+)
+
+var shadowDecisions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "hyperv_webhook_shadow_decisions_total",
+		Help: "Admission decisions computed in shadow mode, by decision and skip reason.",
+	},
+	[]string{"decision", "reason"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(shadowDecisions)
+}
+
+// shadowResponse admits the pod without injecting the runtime class. The
+// decision, and for mutate decisions the would-be patch, is recorded in pod
+// annotations, audit annotations and the shadow decisions metric. reason is
+// empty when the pod would have been mutated.
+func shadowResponse(rawObject []byte, reason string, patches []jsonpatch.JsonPatchOperation) admission.Response {
+	decision := shadowDecisionMutate
+	if reason != "" {
+		decision = shadowDecisionSkip
+	}
+	shadowDecisions.WithLabelValues(decision, reason).Inc()
+
+	annotations := map[string]string{shadowDecisionAnnotation: decision}
+	audit := map[string]string{"shadow-decision": decision}
+	if reason != "" {
+		annotations[shadowReasonAnnotation] = reason
+		audit["shadow-reason"] = reason
+	}
+	if len(patches) > 0 {
+		patchBytes, err := json.Marshal(patches)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		annotations[shadowPatchAnnotation] = string(patchBytes)
+		audit["shadow-patch"] = string(patchBytes)
+	}
+
+	annotated, err := annotatePodRaw(rawObject, annotations)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	resp := admission.PatchResponseFromRaw(rawObject, annotated)
+	resp.AuditAnnotations = audit
+	return resp
+}
+
+// annotatePodRaw sets the given annotations on the raw pod JSON, preserving
+// all other fields.
+func annotatePodRaw(rawObject []byte, annotations map[string]string) ([]byte, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(rawObject, &raw); err != nil {
+		return nil, err
+	}
+
+	metadata, _ := raw["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		raw["metadata"] = metadata
+	}
+	existing, _ := metadata["annotations"].(map[string]interface{})
+	if existing == nil {
+		existing = map[string]interface{}{}
+		metadata["annotations"] = existing
+	}
+	for k, v := range annotations {
+		existing[k] = v
+	}
+
+	return json.Marshal(raw)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestHandleShadow(t *testing.T) {
+	pu := &podUpdater{Shadow: true}
+
+	tests := []struct {
+		name         string
+		raw          string
+		wantDecision string
+		wantReason   string
+	}{
+		{
+			name:         "would mutate",
+			raw:          `{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`,
+			wantDecision: shadowDecisionMutate,
+		},
+		{
+			name:         "would skip",
+			raw:          `{"metadata":{"name":"p2"},"spec":{"hostNetwork":true,"containers":[{"name":"c","image":"busybox"}]}}`,
+			wantDecision: shadowDecisionSkip,
+			wantReason:   skipHostNetwork,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := testutil.ToFloat64(shadowDecisions.WithLabelValues(tc.wantDecision, tc.wantReason))
+			resp := pu.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: []byte(tc.raw)},
+			}})
+			if !resp.Allowed {
+				t.Fatalf("expected request to be allowed, got %v", resp.Result)
+			}
+
+			for _, p := range resp.Patches {
+				if !strings.HasPrefix(p.Path, "/metadata/annotations") {
+					t.Errorf("shadow mode must only patch annotations, got %s %s", p.Operation, p.Path)
+				}
+			}
+
+			patched := decodeRaw(t, applyPatches(t, []byte(tc.raw), resp))
+			ann := mapAt(patched, "metadata", "annotations")
+			if ann[shadowDecisionAnnotation] != tc.wantDecision {
+				t.Errorf("expected decision %q, got %v", tc.wantDecision, ann[shadowDecisionAnnotation])
+			}
+			if tc.wantReason != "" && ann[shadowReasonAnnotation] != tc.wantReason {
+				t.Errorf("expected reason %q, got %v", tc.wantReason, ann[shadowReasonAnnotation])
+			}
+			if tc.wantDecision == shadowDecisionMutate {
+				if !strings.Contains(ann[shadowPatchAnnotation].(string), "/spec/runtimeClassName") {
+					t.Errorf("expected would-be patch to set runtimeClassName, got %v", ann[shadowPatchAnnotation])
+				}
+				if resp.AuditAnnotations["shadow-patch"] == "" {
+					t.Error("expected the would-be patch in the audit annotations")
+				}
+			}
+			if _, ok := mapAt(patched, "spec")["runtimeClassName"]; ok {
+				t.Error("shadow mode must not set runtimeClassName")
+			}
+			if resp.AuditAnnotations["shadow-decision"] != tc.wantDecision {
+				t.Errorf("expected audit annotation shadow-decision=%q, got %v", tc.wantDecision, resp.AuditAnnotations)
+			}
+
+			after := testutil.ToFloat64(shadowDecisions.WithLabelValues(tc.wantDecision, tc.wantReason))
+			if after != before+1 {
+				t.Errorf("expected shadow decision metric to increase by 1, got %v -> %v", before, after)
+			}
+		})
+	}
+}
+
+func TestShadowReport(t *testing.T) {
+	pod := func(name string, ann map[string]string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: ann}}
+	}
+	rows := summarizeShadowDecisions([]corev1.Pod{
+		pod("b", map[string]string{shadowDecisionAnnotation: shadowDecisionMutate}),
+		pod("a", map[string]string{shadowDecisionAnnotation: shadowDecisionMutate}),
+		pod("c", map[string]string{shadowDecisionAnnotation: shadowDecisionSkip, shadowReasonAnnotation: skipHostNetwork}),
+		pod("d", nil),
+	})
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %+v", rows)
+	}
+	if rows[0].Decision != shadowDecisionMutate || strings.Join(rows[0].Pods, ",") != "a,b" {
+		t.Errorf("unexpected mutate row %+v", rows[0])
+	}
+	if rows[1].Decision != shadowDecisionSkip || rows[1].Reason != skipHostNetwork {
+		t.Errorf("unexpected skip row %+v", rows[1])
+	}
+	if rows[2].Decision != "unrecorded" {
+		t.Errorf("unexpected unrecorded row %+v", rows[2])
+	}
+
+	var out bytes.Buffer
+	if err := printShadowSummary(&out, "e2e", rows, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"mutate", "2 (a, b)", "hostNetwork", "total"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected report to contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/tracing"
+)
+
+// tracingService is the service.name of the exported spans.
+const tracingService = "hyperv-webhook"
+
+// admissionTrace holds the spans of one admission request. controller-runtime
+// decodes the AdmissionReview before calling the handler and encodes the
+// response after it returns, so those spans are started and ended on either
+// side of the handler.
+type admissionTrace struct {
+	decode trace.Span
+	encode trace.Span
+}
+
+type admissionTraceKey struct{}
+
+// tracingWebhook traces the admission requests served by h, continuing the
+// trace of the API server if the request carries one.
+func tracingWebhook(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer(tracingService).Start(ctx, "admission", trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		at := &admissionTrace{}
+		_, at.decode = tracing.StartSpan(ctx, tracingService, "decode")
+		ctx = context.WithValue(ctx, admissionTraceKey{}, at)
+		h.ServeHTTP(&tracingResponseWriter{ResponseWriter: w, trace: at}, r.WithContext(ctx))
+
+		// Requests rejected before the handler only have a decode span.
+		at.decode.End()
+		if at.encode != nil {
+			at.encode.End()
+		}
+	})
+}
+
+// tracingResponseWriter ends the encode span when the response is written.
+type tracingResponseWriter struct {
+	http.ResponseWriter
+	trace *admissionTrace
+}
+
+func (w *tracingResponseWriter) Write(b []byte) (int, error) {
+	if w.trace.encode != nil {
+		w.trace.encode.End()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// tracingHandler annotates the admission span with the request and the
+// outcome of Handler.
+type tracingHandler struct {
+	Handler admission.Handler
+}
+
+func (h *tracingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	at, _ := ctx.Value(admissionTraceKey{}).(*admissionTrace)
+	if at != nil {
+		at.decode.End()
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(tracing.RequestAttributes(&req.AdmissionRequest)...)
+
+	resp := h.Handler.Handle(ctx, req)
+
+	span.SetAttributes(
+		attribute.Bool("admission.allowed", resp.Allowed),
+		attribute.Int("admission.patch_operations", len(resp.Patches)),
+	)
+	if at != nil {
+		_, at.encode = tracing.StartSpan(ctx, tracingService, "encode")
+	}
+	return resp
+}
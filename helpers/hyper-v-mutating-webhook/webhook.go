@@ -22,11 +22,18 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"windows.k8s.io/webhook-common/matchconditions"
+	"windows.k8s.io/webhook-common/tracing"
 )
 
 // +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.kb.io,admissionReviewVersions={v1},sideEffects=None
@@ -37,77 +44,345 @@ var (
 )
 
 type podUpdater struct {
-	Client  client.Client
-	decoder admission.Decoder
+	Client client.Client
+	// ExcludedNamespaces lists namespaces whose pods are admitted unmodified.
+	ExcludedNamespaces map[string]struct{}
+	// Compat, when set, restricts injection to pods whose images cannot run
+	// process-isolated on the nodes they may be scheduled to.
+	Compat *compatibilityChecker
+	// Shadow records the decision on the pod instead of injecting the
+	// runtime class.
+	Shadow bool
+	// Nodes, when set, withholds the runtime class while no Windows node can
+	// run it.
+	Nodes *nodeGate
+	// Rules skip or force injection for matching pods. Defaults to
+	// defaultRules when nil.
+	Rules *ruleSet
+	// Sampler, when set, only injects the runtime class into a fraction of
+	// workloads and records the chosen isolation on every pod.
+	Sampler *sampler
+
+	// noPrecheck decides every pod from the full decode, to benchmark the
+	// precheck against it.
+	noPrecheck bool
 }
 
 func (pu *podUpdater) Handle(ctx context.Context, req admission.Request) admission.Response {
-	pod := &corev1.Pod{}
-	err := pu.decoder.Decode(req, pod)
+	parent := ctx
+	ctx, span := tracing.StartSpan(parent, tracingService, "decide")
+	defer func() { span.End() }()
+
+	if _, ok := pu.ExcludedNamespaces[req.Namespace]; ok {
+		noteSkipReason(ctx, skipExcludedNamespace)
+		return admission.Allowed("")
+	}
+
+	// runtimeClassName is immutable, so the runtime class can only be
+	// injected on CREATE. Other operations only keep the webhook's
+	// annotations intact.
+	if req.Operation != admissionv1.Create {
+		return handleUpdate(req)
+	}
+
+	// Most pods in an e2e run are skipped by podSkipReason. Decide those from
+	// a partial decode before decoding the full pod.
+	if !pu.noPrecheck {
+		sparse, err := precheckRaw(req.Object.Raw)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if reason := podSkipReason(sparse); reason != "" {
+			return pu.skipResponse(ctx, req, sparse, reason)
+		}
+	}
+
+	// The pod is decoded once; the decision reads the typed pod and the
+	// mutation is applied to the unstructured one.
+	raw, pod, err := decodePodRaw(req.Object.Raw)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
 
-	if !shouldMutatePod(pod) {
-		return admission.Allowed("")
+	if reason := pu.conditionSkipReason(ctx, &req.AdmissionRequest); reason != "" {
+		return pu.skipResponse(ctx, req, pod, reason)
+	}
+	if reason := pu.skipReason(ctx, pod); reason != "" {
+		return pu.skipResponse(ctx, req, pod, reason)
+	}
+	span.End()
+	_, span = tracing.StartSpan(parent, tracingService, "patch")
+
+	marshaledPod, err := mutatePod(raw, runtimeClassName)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if pu.Sampler != nil && !pu.Shadow {
+		if pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName == "" {
+			pod.Spec.RuntimeClassName = &runtimeClassName
+		}
+		webhookLogger.Info(fmt.Sprintf("Pod %s is being mutated (sampled)", pod.Name))
+		return sampledResponse(req.Object.Raw, marshaledPod, podIsolation(pod, runtimeClassName))
+	}
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+
+	if pu.Shadow {
+		webhookLogger.Info(fmt.Sprintf("Pod %s would be mutated (shadow mode)", pod.Name))
+		return shadowResponse(req.Object.Raw, "", resp.Patches)
 	}
 
 	webhookLogger.Info(fmt.Sprintf("Pod %s is being mutated", pod.Name))
+	return resp
+}
+
+// skipResponse admits a pod that does not get the runtime class. pod may be
+// the sparse pod returned by precheckRaw.
+func (pu *podUpdater) skipResponse(ctx context.Context, req admission.Request, pod *corev1.Pod, reason string) admission.Response {
+	noteSkipReason(ctx, reason)
+	if pu.Shadow {
+		return shadowResponse(req.Object.Raw, reason, nil)
+	}
+	if pu.Sampler != nil {
+		return sampledResponse(req.Object.Raw, req.Object.Raw, podIsolation(pod, runtimeClassName))
+	}
+	return admission.Allowed("")
+}
+
+// webhookAnnotationPrefix is shared by all annotations the webhook sets: the
+// mutated marker, the shadow decision and the sampled isolation.
+const webhookAnnotationPrefix = "hyperv-runtimeclass-mutating-webhook"
+
+// handleUpdate admits UPDATEs without touching the spec. Annotations set by
+// the webhook at creation are records of the admission decision, so if an
+// update removes or changes them they are restored from the old object.
+func handleUpdate(req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Update || req.SubResource != "" || len(req.OldObject.Raw) == 0 {
+		return admission.Allowed("")
+	}
 
-	marshaledPod, err := mutatePodRaw(req.Object.Raw, runtimeClassName)
+	oldPod := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.OldObject.Raw, oldPod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	newPod := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, newPod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	restore := map[string]string{}
+	for k, v := range oldPod.Annotations {
+		if !strings.HasPrefix(k, webhookAnnotationPrefix) {
+			continue
+		}
+		if current, ok := newPod.Annotations[k]; !ok || current != v {
+			restore[k] = v
+		}
+	}
+	if len(restore) == 0 {
+		return admission.Allowed("")
+	}
+
+	annotated, err := annotatePodRaw(req.Object.Raw, restore)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, annotated)
+}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+// skipDecision is why the policy skips a pod. It is decided without side
+// effects, so the drift auditor can ask for it without counting as an
+// admission; the webhook records it with observe.
+type skipDecision struct {
+	// reason is empty if the pod gets the runtime class.
+	reason string
+	// rule is the mutation rule that matched the pod, if any.
+	rule *compiledRule
+	// condition is the match condition that was false, if any.
+	condition string
+	// err is the evaluation error the pod was admitted unmodified for.
+	err error
 }
 
+// skipReason returns why the pod should not get the hyper-v runtime class, or
+// an empty string if it should be mutated, and records the decision.
+func (pu *podUpdater) skipReason(ctx context.Context, pod *corev1.Pod) string {
+	d := pu.decideSkip(ctx, pod)
+	d.observe(pod.Name)
+	return d.reason
+}
+
+// conditionSkipReason returns why the match conditions of the rules file
+// skip the request, or an empty string if they all hold, and records the
+// decision.
+func (pu *podUpdater) conditionSkipReason(ctx context.Context, req *admissionv1.AdmissionRequest) string {
+	d := pu.decideConditions(ctx, req)
+	d.observe(req.Name)
+	return d.reason
+}
+
+// decideSkip returns why the pod should not get the hyper-v runtime class.
+func (pu *podUpdater) decideSkip(ctx context.Context, pod *corev1.Pod) skipDecision {
+	if reason := podSkipReason(pod); reason != "" {
+		return skipDecision{reason: reason}
+	}
+
+	rules := pu.Rules
+	if rules == nil {
+		rules = defaultRuleSet
+	}
+	rule, err := rules.match(pod, namespaceLabelGetter(ctx, pu.Client, pod.Namespace))
+	if err != nil {
+		return skipDecision{reason: skipRuleEvaluationFailed, err: err}
+	}
+	if rule != nil {
+		if rule.action == ruleActionForce {
+			return skipDecision{rule: rule}
+		}
+		return skipDecision{reason: ruleReason(rule.name), rule: rule}
+	}
+
+	if pu.Sampler != nil && !pu.Sampler.selected(pod) {
+		return skipDecision{reason: skipNotSampled}
+	}
+
+	if pu.Nodes != nil && !pu.Nodes.allowInjection(ctx) {
+		return skipDecision{reason: skipNoCapableNodes}
+	}
+
+	if pu.Compat != nil {
+		required, err := pu.Compat.requiresHyperV(ctx, pod)
+		if err != nil {
+			// Fail open: admit the pod unmodified rather than blocking pod
+			// creation while the registry or node list is unavailable.
+			return skipDecision{reason: skipCompatibilityUnknown, err: err}
+		}
+		if !required {
+			return skipDecision{reason: skipProcessIsolationCompatible}
+		}
+	}
+	return skipDecision{}
+}
+
+// decideConditions returns why the match conditions of the rules file skip
+// the request.
+func (pu *podUpdater) decideConditions(ctx context.Context, req *admissionv1.AdmissionRequest) skipDecision {
+	if pu.Rules == nil || len(pu.Rules.conditions) == 0 {
+		return skipDecision{}
+	}
+	name, err := matchconditions.Eval(ctx, pu.Rules.conditions, req, namespaceObjectGetter(ctx, pu.Client, req.Namespace))
+	if err != nil {
+		return skipDecision{reason: skipMatchConditionFailed, err: err}
+	}
+	if name != "" {
+		return skipDecision{reason: matchConditionReason(name), condition: name}
+	}
+	return skipDecision{}
+}
+
+// skipErrorMessages are logged for decisions that failed to evaluate.
+var skipErrorMessages = map[string]string{
+	skipRuleEvaluationFailed: "unable to evaluate mutation rules, admitting pod unmodified",
+	skipMatchConditionFailed: "unable to evaluate match conditions, admitting pod unmodified",
+	skipCompatibilityUnknown: "unable to determine image compatibility, admitting pod unmodified",
+}
+
+// observe logs the decision for the named pod and counts the rule or match
+// condition it was made by.
+func (d skipDecision) observe(pod string) {
+	if d.err != nil {
+		webhookLogger.Error(d.err, skipErrorMessages[d.reason], "pod", pod)
+	}
+	if d.rule != nil {
+		ruleMatches.WithLabelValues(d.rule.name, d.rule.action).Inc()
+		webhookLogger.Info("pod matched mutation rule", "pod", pod, "rule", d.rule.name, "action", d.rule.action)
+	}
+	if d.condition != "" {
+		matchConditionSkips.WithLabelValues(d.condition).Inc()
+	}
+}
+
+// Reasons reported by podSkipReason, podUpdater.skipReason and
+// podUpdater.conditionSkipReason.
+const (
+	skipExcludedNamespace          = "excludedNamespace"
+	skipHostProcess                = "hostProcess"
+	skipHostNetwork                = "hostNetwork"
+	skipLinuxNodeSelector          = "linuxNodeSelector"
+	skipRuleEvaluationFailed       = "ruleEvaluationFailed"
+	skipMatchConditionFailed       = "matchConditionEvaluationFailed"
+	skipCompatibilityUnknown       = "compatibilityUnknown"
+	skipProcessIsolationCompatible = "processIsolationCompatible"
+	skipNoCapableNodes             = "noHyperVCapableNodes"
+	skipNotSampled                 = "notSampled"
+)
+
 // shouldMutatePod reports whether the hyper-v runtime class should be injected
-// into the given pod. It returns false for pods that are incompatible with
-// Hyper-V isolation (hostProcess, hostNetwork), explicitly Linux pods, and pods
-// carrying a custom nodeSelector with no kubernetes.io/os key (likely test
-// fixtures whose resource accounting would break if overhead were injected).
+// into the given pod under the default rules. It returns false for pods that
+// are incompatible with Hyper-V isolation (hostProcess, hostNetwork),
+// explicitly Linux pods, and pods skipped by defaultRules.
 func shouldMutatePod(pod *corev1.Pod) bool {
+	if podSkipReason(pod) != "" {
+		return false
+	}
+	rule, err := defaultRuleSet.match(pod, nil)
+	return err == nil && (rule == nil || rule.action == ruleActionForce)
+}
+
+// podSkipReason returns the reason a pod cannot run Hyper-V isolated, or an
+// empty string if it can. These checks cannot be overridden by rules.
+func podSkipReason(pod *corev1.Pod) string {
 	// Don't apply hyper-v runtime class to hostProcess pods
 	if isHostProcessPod(pod) {
-		return false
+		return skipHostProcess
 	}
 
 	// Don't apply hyper-v runtime class to hostNetwork pods, as Hyper-V
 	// isolation runs containers inside a utility VM with its own network
 	// namespace which is incompatible with host networking
 	if pod.Spec.HostNetwork {
-		return false
+		return skipHostNetwork
 	}
 
 	// Don't apply hyper-v runtime class for linux pods that are explicitly labeled, as this is a windows only supported runtimeclass
 	if osLabel, ok := pod.Spec.NodeSelector["kubernetes.io/os"]; ok && osLabel == "linux" {
-		return false
+		return skipLinuxNodeSelector
 	}
 
-	// Don't apply hyper-v runtime class for pods that have custom nodeSelectors
-	// but no kubernetes.io/os selector. These are likely test fixture pods
-	// (e.g., ResourceQuota tests with unsatisfiable selectors) that are not
-	// intended to run as Windows workloads. Injecting overhead into them would
-	// break resource accounting in those tests.
-	if _, hasOS := pod.Spec.NodeSelector["kubernetes.io/os"]; !hasOS && len(pod.Spec.NodeSelector) > 0 {
-		return false
-	}
+	return ""
+}
 
-	return true
+// decodePodRaw decodes the raw pod JSON once, both as the unstructured object
+// mutatePod patches and as the typed pod the decision is made on.
+func decodePodRaw(rawObject []byte) (map[string]interface{}, *corev1.Pod, error) {
+	raw := map[string]interface{}{}
+	if err := utiljson.Unmarshal(rawObject, &raw); err != nil {
+		return nil, nil, err
+	}
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, pod); err != nil {
+		return nil, nil, err
+	}
+	return raw, pod, nil
 }
 
 // mutatePodRaw injects the hyper-v mutation annotation and, when unset, the
-// runtimeClassName into the raw pod JSON, preserving all other fields. It
-// operates on the raw request bytes rather than a re-marshaled typed Pod so that
-// fields newer than the vendored k8s.io/api (e.g. container-level
-// restartPolicyRules) are not dropped.
+// runtimeClassName into the raw pod JSON, preserving all other fields.
 func mutatePodRaw(rawObject []byte, runtimeClassName string) ([]byte, error) {
 	raw := map[string]interface{}{}
 	if err := json.Unmarshal(rawObject, &raw); err != nil {
 		return nil, err
 	}
+	return mutatePod(raw, runtimeClassName)
+}
 
+// mutatePod is mutatePodRaw on the decoded pod, which it modifies. It
+// operates on the unstructured pod rather than a re-marshaled typed Pod so
+// that fields newer than the vendored k8s.io/api (e.g. container-level
+// restartPolicyRules) are not dropped.
+func mutatePod(raw map[string]interface{}, runtimeClassName string) ([]byte, error) {
 	metadata, _ := raw["metadata"].(map[string]interface{})
 	if metadata == nil {
 		metadata = map[string]interface{}{}
@@ -118,7 +393,7 @@ func mutatePodRaw(rawObject []byte, runtimeClassName string) ([]byte, error) {
 		annotations = map[string]interface{}{}
 		metadata["annotations"] = annotations
 	}
-	annotations["hyperv-runtimeclass-mutating-webhook"] = "mutated"
+	annotations[webhookAnnotationPrefix] = "mutated"
 
 	spec, _ := raw["spec"].(map[string]interface{})
 	if spec == nil {
@@ -134,12 +409,6 @@ func mutatePodRaw(rawObject []byte, runtimeClassName string) ([]byte, error) {
 	return json.Marshal(raw)
 }
 
-// InjectDecoder injects a decoder into the podUpdater
-func (pu *podUpdater) InjectDecoder(d admission.Decoder) error {
-	pu.decoder = d
-	return nil
-}
-
 func isHostProcessPod(p *corev1.Pod) bool {
 	// Check if hostProcess is set at pod level
 	if p.Spec.SecurityContext != nil && p.Spec.SecurityContext.WindowsOptions != nil && p.Spec.SecurityContext.WindowsOptions.HostProcess != nil {
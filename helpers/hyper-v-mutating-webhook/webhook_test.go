@@ -17,11 +17,17 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 const testRuntimeClass = "runhcs-wcow-hypervisor"
@@ -49,6 +55,24 @@ func mapAt(m map[string]interface{}, keys ...string) map[string]interface{} {
 	return cur
 }
 
+// applyPatches applies the JSON patch of an admission response to raw.
+func applyPatches(t *testing.T, raw []byte, resp admission.Response) []byte {
+	t.Helper()
+	patchBytes, err := json.Marshal(resp.Patches)
+	if err != nil {
+		t.Fatalf("failed to marshal patches: %v", err)
+	}
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		t.Fatalf("failed to decode patch: %v", err)
+	}
+	out, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("failed to apply patch: %v", err)
+	}
+	return out
+}
+
 func TestMutatePodRaw(t *testing.T) {
 	const annKey = "hyperv-runtimeclass-mutating-webhook"
 
@@ -310,3 +334,150 @@ func TestShouldMutatePod(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleExcludedNamespace(t *testing.T) {
+	pu := &podUpdater{
+		ExcludedNamespaces: parseList("skipped"),
+	}
+	raw := []byte(`{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`)
+
+	for ns, wantPatch := range map[string]bool{"skipped": false, "default": true} {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: ns,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+		resp := pu.Handle(context.Background(), req)
+		if !resp.Allowed {
+			t.Fatalf("namespace %s: expected request to be allowed, got %v", ns, resp.Result)
+		}
+		if got := len(resp.Patches) > 0; got != wantPatch {
+			t.Errorf("namespace %s: patched = %v, want %v", ns, got, wantPatch)
+		}
+	}
+}
+
+func TestHandleOperations(t *testing.T) {
+	pu := &podUpdater{}
+	bare := `{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox"}]}}`
+	mutated := `{"metadata":{"name":"p1","annotations":{"hyperv-runtimeclass-mutating-webhook":"mutated","hyperv-runtimeclass-mutating-webhook/isolation":"hyperv"}},` +
+		`"spec":{"runtimeClassName":"runhcs-wcow-hypervisor","containers":[{"name":"c","image":"busybox"}]}}`
+	stripped := `{"metadata":{"name":"p1","labels":{"a":"b"},"annotations":{"hyperv-runtimeclass-mutating-webhook/isolation":"process","other":"x"}},` +
+		`"spec":{"runtimeClassName":"runhcs-wcow-hypervisor","containers":[{"name":"c","image":"busybox"}]}}`
+
+	tests := []struct {
+		name        string
+		operation   admissionv1.Operation
+		subResource string
+		oldObject   string
+		object      string
+		// wantAnnotations are expected on the patched object; nil means no
+		// patch is expected.
+		wantAnnotations  map[string]interface{}
+		wantRuntimeClass bool
+	}{
+		{
+			name:             "create injects the runtime class",
+			operation:        admissionv1.Create,
+			object:           bare,
+			wantAnnotations:  map[string]interface{}{"hyperv-runtimeclass-mutating-webhook": "mutated"},
+			wantRuntimeClass: true,
+		},
+		{
+			name:      "update of an unmutated pod is untouched",
+			operation: admissionv1.Update,
+			oldObject: bare,
+			object:    bare,
+		},
+		{
+			name:      "update keeping the annotations is untouched",
+			operation: admissionv1.Update,
+			oldObject: mutated,
+			object:    mutated,
+		},
+		{
+			name:      "update restores removed and changed annotations",
+			operation: admissionv1.Update,
+			oldObject: mutated,
+			object:    stripped,
+			wantAnnotations: map[string]interface{}{
+				"hyperv-runtimeclass-mutating-webhook":           "mutated",
+				"hyperv-runtimeclass-mutating-webhook/isolation": "hyperv",
+				"other": "x",
+			},
+			wantRuntimeClass: true,
+		},
+		{
+			name:        "status update is untouched",
+			operation:   admissionv1.Update,
+			subResource: "status",
+			oldObject:   mutated,
+			object:      stripped,
+		},
+		{
+			name:      "delete is untouched",
+			operation: admissionv1.Delete,
+			oldObject: mutated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace:   "default",
+				Operation:   tc.operation,
+				SubResource: tc.subResource,
+				Object:      runtime.RawExtension{Raw: []byte(tc.object)},
+				OldObject:   runtime.RawExtension{Raw: []byte(tc.oldObject)},
+			}}
+			resp := pu.Handle(context.Background(), req)
+			if !resp.Allowed {
+				t.Fatalf("expected request to be allowed, got %v", resp.Result)
+			}
+
+			if tc.wantAnnotations == nil {
+				if len(resp.Patches) != 0 {
+					t.Errorf("expected no patches, got %v", resp.Patches)
+				}
+				return
+			}
+			for _, p := range resp.Patches {
+				if tc.operation != admissionv1.Create && !strings.HasPrefix(p.Path, "/metadata/annotations") {
+					t.Errorf("updates must only patch annotations, got %s %s", p.Operation, p.Path)
+				}
+			}
+			patched := decodeRaw(t, applyPatches(t, []byte(tc.object), resp))
+			ann := mapAt(patched, "metadata", "annotations")
+			for k, v := range tc.wantAnnotations {
+				if ann[k] != v {
+					t.Errorf("annotation %s = %v, want %v", k, ann[k], v)
+				}
+			}
+			if got := mapAt(patched, "spec")["runtimeClassName"] == testRuntimeClass; got != tc.wantRuntimeClass {
+				t.Errorf("runtimeClassName set = %v, want %v", got, tc.wantRuntimeClass)
+			}
+		})
+	}
+}
+
+func TestHandleKeepsUnknownFields(t *testing.T) {
+	// The mutation is applied to the unstructured pod, so fields newer than
+	// the vendored k8s.io/api are not removed by the patch.
+	raw := []byte(`{"metadata":{"name":"p1"},"spec":{"containers":[{"name":"c","image":"busybox",` +
+		`"restartPolicyRules":[{"action":"Restart","exitCodes":{"operator":"In","values":[42]}}]}],"futureField":true}}`)
+	resp := (&podUpdater{}).Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed {
+		t.Fatalf("expected request to be allowed, got %v", resp.Result)
+	}
+	paths := map[string]string{}
+	for _, p := range resp.Patches {
+		paths[p.Path] = p.Operation
+	}
+	want := map[string]string{"/metadata/annotations": "add", "/spec/runtimeClassName": "add"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected patches %v, got %v", want, paths)
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certs generates and rotates the self-managed CA and serving
+// certificate of a webhook, used when cert-manager is not installed. Storing
+// them in a Secret and injecting the caBundle is left to each webhook.
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// CABundleKey holds the PEM encoded CAs trusted for the serving
+	// certificate: the current CA first, followed by any previous CA that has
+	// not expired yet so that rotation does not break in-flight certificates.
+	CABundleKey = "ca.crt"
+	// CAKeyKey holds the private key of the current (first) CA in CABundleKey.
+	CAKeyKey = "ca.key"
+)
+
+// Options configures the built-in certificate management.
+type Options struct {
+	SecretName        string
+	SecretNamespace   string
+	WebhookConfigName string
+	ServiceName       string
+	CAValidity        time.Duration
+	CertValidity      time.Duration
+	RefreshBefore     time.Duration
+	SyncInterval      time.Duration
+}
+
+// Validate reports missing or inconsistent options by their flag names.
+func (o *Options) Validate() error {
+	if o.SecretName == "" || o.SecretNamespace == "" || o.WebhookConfigName == "" || o.ServiceName == "" {
+		return errors.New("--cert-secret-name, --cert-secret-namespace, --webhook-configuration-name and --service-name are required for self-managed certificates")
+	}
+	if o.RefreshBefore >= o.CertValidity {
+		return fmt.Errorf("--cert-refresh-before (%s) must be shorter than --cert-validity (%s)", o.RefreshBefore, o.CertValidity)
+	}
+	if o.CertValidity >= o.CAValidity {
+		return fmt.Errorf("--cert-validity (%s) must be shorter than --ca-validity (%s)", o.CertValidity, o.CAValidity)
+	}
+	return nil
+}
+
+// DNSNames returns the names the apiserver uses to reach the webhook Service.
+func (o *Options) DNSNames() []string {
+	return []string{
+		o.ServiceName,
+		fmt.Sprintf("%s.%s", o.ServiceName, o.SecretNamespace),
+		fmt.Sprintf("%s.%s.svc", o.ServiceName, o.SecretNamespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", o.ServiceName, o.SecretNamespace),
+	}
+}
+
+// Store holds the serving certificate presented by this replica.
+type Store struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	raw  []byte
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (s *Store) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, errors.New("serving certificate not loaded yet")
+	}
+	return s.cert, nil
+}
+
+// Ready fails until a serving certificate has been loaded.
+func (s *Store) Ready() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return errors.New("serving certificate not loaded yet")
+	}
+	return nil
+}
+
+// Set loads the serving certificate of the Secret data written by Ensure. The
+// returned bool reports whether a different certificate was loaded.
+func (s *Store) Set(data map[string][]byte) (bool, error) {
+	certPEM, keyPEM := data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey]
+	s.mu.RLock()
+	unchanged := s.cert != nil && bytes.Equal(s.raw, certPEM)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	s.cert = &cert
+	s.raw = certPEM
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Ensure returns Secret data holding a valid CA bundle and serving
+// certificate, generating new ones when they are missing, do not match the
+// options or expire within the refresh window. trusted are the caBundles the
+// apiserver currently uses to verify the webhook. A serving certificate of a
+// new CA is only issued once every trusted bundle contains that CA; until
+// then the previous certificate keeps being served while it is valid, so
+// replicas never present a certificate the apiserver cannot verify. The
+// returned bool reports whether the data changed.
+func Ensure(data map[string][]byte, trusted [][]byte, o *Options, now time.Time) (map[string][]byte, bool, error) {
+	out := map[string][]byte{}
+	for k, v := range data {
+		out[k] = v
+	}
+
+	cas := ValidCerts(out[CABundleKey], now)
+	var caCert *x509.Certificate
+	var caKey *ecdsa.PrivateKey
+	if len(cas) > 0 {
+		caCert = cas[0]
+		if key, err := parseECKey(out[CAKeyKey]); err == nil && key.PublicKey.Equal(caCert.PublicKey) {
+			caKey = key
+		}
+	}
+
+	changed := false
+	// A new serving certificate must never outlive its CA.
+	if caCert == nil || caKey == nil || !caCert.IsCA || caCert.NotAfter.Before(now.Add(o.CertValidity)) {
+		var err error
+		caCert, caKey, err = generateCA(now, o.CAValidity)
+		if err != nil {
+			return nil, false, err
+		}
+		keyPEM, err := encodeECKey(caKey)
+		if err != nil {
+			return nil, false, err
+		}
+		cas = append([]*x509.Certificate{caCert}, cas...)
+		out[CAKeyKey] = keyPEM
+		changed = true
+	}
+
+	bundle := encodeCerts(cas)
+	if !bytes.Equal(bundle, out[CABundleKey]) {
+		out[CABundleKey] = bundle
+		changed = true
+	}
+
+	certPEM, keyPEM := out[corev1.TLSCertKey], out[corev1.TLSPrivateKeyKey]
+	if servingCertValid(certPEM, keyPEM, cas[:1], o, now.Add(o.RefreshBefore)) {
+		return out, changed, nil
+	}
+	if !trustedBy(caCert, trusted, now) && servingCertValid(certPEM, keyPEM, cas[1:], o, now) {
+		// the certificate of a previous CA is served until the new CA is
+		// injected into the caBundle, and replaced on a later sync
+		return out, changed, nil
+	}
+	certPEM, keyPEM, err := generateServingCert(caCert, caKey, o.DNSNames(), now, o.CertValidity)
+	if err != nil {
+		return nil, false, err
+	}
+	out[corev1.TLSCertKey] = certPEM
+	out[corev1.TLSPrivateKeyKey] = keyPEM
+	return out, true, nil
+}
+
+// servingCertValid reports whether the serving certificate is valid until at
+// least validUntil, is signed by one of cas and has the DNS names of o.
+func servingCertValid(certPEM, keyPEM []byte, cas []*x509.Certificate, o *Options, validUntil time.Time) bool {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if validUntil.After(cert.NotAfter) {
+		return false
+	}
+	if !slices.ContainsFunc(cas, func(ca *x509.Certificate) bool { return cert.CheckSignatureFrom(ca) == nil }) {
+		return false
+	}
+	return slices.Equal(cert.DNSNames, o.DNSNames())
+}
+
+// trustedBy reports whether every bundle contains ca. Without bundles the CA
+// is not known to be trusted.
+func trustedBy(ca *x509.Certificate, bundles [][]byte, now time.Time) bool {
+	if len(bundles) == 0 {
+		return false
+	}
+	for _, bundle := range bundles {
+		if !slices.ContainsFunc(ValidCerts(bundle, now), ca.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidCerts decodes the PEM certificates that have not expired at now.
+func ValidCerts(bundle []byte, now time.Time) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || now.After(cert.NotAfter) {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func generateCA(now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating CA key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("windows-testing-webhook-ca@%d", now.Unix())},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func generateServingCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, dnsNames []string, now time.Time, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating serving key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating serving certificate: %w", err)
+	}
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number: %w", err)
+	}
+	return serial, nil
+}
+
+func encodeCerts(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
+
+func encodeECKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseECKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
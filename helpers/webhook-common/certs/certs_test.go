@@ -0,0 +1,213 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const day = 24 * time.Hour
+
+func testOptions() Options {
+	return Options{
+		SecretName:        "webhook-tls",
+		SecretNamespace:   "webhook",
+		WebhookConfigName: "webhook",
+		ServiceName:       "webhook",
+		CAValidity:        100 * day,
+		CertValidity:      10 * day,
+		RefreshBefore:     2 * day,
+		SyncInterval:      time.Minute,
+	}
+}
+
+// verifyServing checks that the serving certificate in data chains to the CA
+// bundle for the Service DNS name.
+func verifyServing(t *testing.T, data map[string][]byte, now time.Time) *x509.Certificate {
+	t.Helper()
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data[CABundleKey]) {
+		t.Fatalf("no CA certificates in bundle")
+	}
+	certs := ValidCerts(data[corev1.TLSCertKey], now)
+	if len(certs) != 1 {
+		t.Fatalf("expected one serving certificate, got %d", len(certs))
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:     "webhook.webhook.svc",
+		Roots:       roots,
+		CurrentTime: now,
+	}); err != nil {
+		t.Fatalf("serving certificate does not verify: %v", err)
+	}
+	return certs[0]
+}
+
+func TestEnsure(t *testing.T) {
+	o := testOptions()
+	now := time.Now()
+
+	data, changed, err := Ensure(nil, nil, &o, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected certificates to be generated")
+	}
+	serving := verifyServing(t, data, now)
+	trusted := [][]byte{data[CABundleKey]}
+
+	t.Run("is idempotent", func(t *testing.T) {
+		again, changed, err := Ensure(data, trusted, &o, now.Add(day))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changed || !bytes.Equal(again[corev1.TLSCertKey], data[corev1.TLSCertKey]) {
+			t.Error("expected valid certificates to be kept")
+		}
+	})
+
+	t.Run("rotates the serving certificate before expiry", func(t *testing.T) {
+		later := now.Add(9 * day)
+		rotated, changed, err := Ensure(data, trusted, &o, later)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed {
+			t.Fatal("expected the serving certificate to be rotated")
+		}
+		if !bytes.Equal(rotated[CAKeyKey], data[CAKeyKey]) {
+			t.Error("expected the CA to be kept while it is valid")
+		}
+		if got := verifyServing(t, rotated, later); got.SerialNumber.Cmp(serving.SerialNumber) == 0 {
+			t.Error("expected a new serving certificate")
+		}
+	})
+
+	t.Run("rotates the CA and keeps the previous one trusted", func(t *testing.T) {
+		later := now.Add(95 * day)
+		rotated, changed, err := Ensure(data, trusted, &o, later)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed || bytes.Equal(rotated[CAKeyKey], data[CAKeyKey]) {
+			t.Fatal("expected a new CA")
+		}
+		if cas := ValidCerts(rotated[CABundleKey], later); len(cas) != 2 {
+			t.Errorf("expected the new and previous CA in the bundle, got %d", len(cas))
+		}
+		// the previous serving certificate expired, so there is nothing to
+		// keep serving until the new CA is injected
+		verifyServing(t, rotated, later)
+
+		// Once the previous CA expires it is dropped from the bundle.
+		pruned, _, err := Ensure(rotated, [][]byte{rotated[CABundleKey]}, &o, now.Add(101*day))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cas := ValidCerts(pruned[CABundleKey], now.Add(101*day)); len(cas) != 1 {
+			t.Errorf("expected the expired CA to be pruned, got %d", len(cas))
+		}
+	})
+
+	t.Run("switches to the new CA after its caBundle is injected", func(t *testing.T) {
+		issued := now.Add(89 * day)
+		current, _, err := Ensure(data, trusted, &o, issued)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		serving := verifyServing(t, current, issued)
+
+		later := now.Add(91 * day)
+		staged, changed, err := Ensure(current, [][]byte{current[CABundleKey]}, &o, later)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed || bytes.Equal(staged[CAKeyKey], current[CAKeyKey]) {
+			t.Fatal("expected a new CA")
+		}
+		if !bytes.Equal(staged[corev1.TLSCertKey], current[corev1.TLSCertKey]) {
+			t.Fatal("expected the previous serving certificate until the new CA is trusted")
+		}
+		if again, changed, err := Ensure(staged, [][]byte{current[CABundleKey]}, &o, later); err != nil || changed || !bytes.Equal(again[corev1.TLSCertKey], current[corev1.TLSCertKey]) {
+			t.Errorf("expected nothing to change while the caBundle is not injected, got %t, %v", changed, err)
+		}
+
+		switched, changed, err := Ensure(staged, [][]byte{staged[CABundleKey], staged[CABundleKey]}, &o, later)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed || !bytes.Equal(switched[CAKeyKey], staged[CAKeyKey]) {
+			t.Fatal("expected a serving certificate of the new CA")
+		}
+		cas := ValidCerts(switched[CABundleKey], later)
+		if got := verifyServing(t, switched, later); got.SerialNumber.Cmp(serving.SerialNumber) == 0 || got.CheckSignatureFrom(cas[0]) != nil {
+			t.Error("expected the serving certificate to be signed by the new CA")
+		}
+	})
+
+	t.Run("reissues when the service name changes", func(t *testing.T) {
+		renamed := o
+		renamed.ServiceName = "other"
+		_, changed, err := Ensure(data, trusted, &renamed, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed {
+			t.Error("expected a certificate for the new DNS names")
+		}
+	})
+}
+
+func TestStore(t *testing.T) {
+	o := testOptions()
+	data, _, err := Ensure(nil, nil, &o, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store := &Store{}
+	if store.Ready() == nil {
+		t.Error("expected the store to be unready before loading")
+	}
+	if _, err := store.GetCertificate(nil); err == nil {
+		t.Error("expected no certificate before loading")
+	}
+	if changed, err := store.Set(data); err != nil || !changed {
+		t.Fatalf("expected the certificate to be loaded, got %t, %v", changed, err)
+	}
+	if err := store.Ready(); err != nil {
+		t.Errorf("expected the store to be ready: %v", err)
+	}
+	if cert, err := store.GetCertificate(nil); err != nil || cert == nil {
+		t.Errorf("expected a serving certificate, got %v", err)
+	}
+	if changed, err := store.Set(data); err != nil || changed {
+		t.Errorf("expected an unchanged certificate not to be reloaded, got %t, %v", changed, err)
+	}
+	if _, err := store.Set(map[string][]byte{corev1.TLSCertKey: []byte("garbage")}); err == nil {
+		t.Error("expected an invalid certificate to be rejected")
+	}
+	if err := store.Ready(); err != nil {
+		t.Errorf("expected the previous certificate to be kept: %v", err)
+	}
+}
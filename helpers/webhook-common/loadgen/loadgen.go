@@ -0,0 +1,437 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loadgen implements the loadgen subcommand of the webhooks, which
+// sends synthesized or recorded AdmissionReviews to a webhook endpoint, or
+// in-process to the webhook's handler, and reports throughput, errors and
+// latency percentiles.
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"windows.k8s.io/webhook-common/recording"
+)
+
+// TargetInProcess sends reviews directly to the webhook's handler instead of
+// a webhook endpoint.
+const TargetInProcess = "in-process"
+
+// SynthesizedPods are the pod shapes an e2e run creates most: plain Windows
+// pods, hostNetwork and hostProcess pods, HPC agnhost pods, Linux pods and
+// test fixtures with custom nodeSelectors.
+var SynthesizedPods = []string{
+	`{"metadata":{"generateName":"pod-","namespace":"e2e"},"spec":{"nodeSelector":{"kubernetes.io/os":"windows"},"containers":[{"name":"c","image":"registry.k8s.io/e2e-test-images/agnhost:2.47","args":["pause"]}]}}`,
+	`{"metadata":{"generateName":"netserver-","namespace":"e2e"},"spec":{"hostNetwork":true,"nodeSelector":{"kubernetes.io/os":"windows"},"containers":[{"name":"c","image":"registry.k8s.io/e2e-test-images/agnhost:2.47","args":["netexec"]}]}}`,
+	`{"metadata":{"generateName":"hpc-","namespace":"e2e"},"spec":{"hostNetwork":true,"securityContext":{"windowsOptions":{"hostProcess":true,"runAsUserName":"NT AUTHORITY\\SYSTEM"}},"nodeSelector":{"kubernetes.io/os":"windows"},"containers":[{"name":"c","image":"registry.k8s.io/e2e-test-images/agnhost:2.47","command":["agnhost"],"args":["netexec"]}]}}`,
+	`{"metadata":{"generateName":"linux-","namespace":"e2e"},"spec":{"nodeSelector":{"kubernetes.io/os":"linux"},"containers":[{"name":"c","image":"busybox"}]}}`,
+	`{"metadata":{"generateName":"quota-","namespace":"e2e"},"spec":{"nodeSelector":{"disktype":"ssd"},"containers":[{"name":"c","image":"busybox"}]}}`,
+}
+
+// Options configures a load generator run.
+type Options struct {
+	Target      string
+	Rate        float64
+	Concurrency int
+	Requests    int
+	Duration    time.Duration
+	Timeout     time.Duration
+}
+
+// result is the outcome of a single admission review.
+type result struct {
+	Latency time.Duration
+	Err     error
+	Allowed bool
+	Patched bool
+}
+
+// Report summarizes a run.
+type Report struct {
+	Requests   int
+	Errors     int
+	Denied     int
+	Patched    int
+	Elapsed    time.Duration
+	Throughput float64
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	// FirstError is reported to make failing runs easier to debug.
+	FirstError string `json:",omitempty"`
+}
+
+// ErrorRate is the fraction of requests that failed.
+func (r Report) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// LoadPayloads reads AdmissionReviews, recorder lines or bare pods, one JSON
+// object per line. Without a file the synthesized pods are used.
+func LoadPayloads(path string) ([]admissionv1.AdmissionReview, error) {
+	if path == "" {
+		reviews := make([]admissionv1.AdmissionReview, 0, len(SynthesizedPods))
+		for _, p := range SynthesizedPods {
+			reviews = append(reviews, PodReview([]byte(p)))
+		}
+		return reviews, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reviews []admissionv1.AdmissionReview
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var probe struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(b, &probe); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if probe.Kind != "AdmissionReview" && probe.Kind != recording.Kind {
+			reviews = append(reviews, PodReview(append([]byte(nil), b...)))
+			continue
+		}
+		// Recorder lines carry the request in the same field as an
+		// AdmissionReview.
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(b, &review); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		review.TypeMeta = metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"}
+		if review.Request == nil {
+			return nil, fmt.Errorf("%s:%d: AdmissionReview without request", path, line)
+		}
+		reviews = append(reviews, review)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(reviews) == 0 {
+		return nil, fmt.Errorf("%s: no payloads", path)
+	}
+	return reviews, nil
+}
+
+// PodReview wraps a raw pod in a CREATE AdmissionReview.
+func PodReview(rawPod []byte) admissionv1.AdmissionReview {
+	namespace := ""
+	var meta struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if json.Unmarshal(rawPod, &meta) == nil {
+		namespace = meta.Metadata.Namespace
+	}
+	return admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Namespace: namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: rawPod},
+		},
+	}
+}
+
+// AdmitFunc sends one review and reports the response.
+type AdmitFunc func(ctx context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error)
+
+// HTTPAdmit posts reviews to a webhook endpoint.
+func HTTPAdmit(c *http.Client, url string) AdmitFunc {
+	return func(ctx context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+		body, err := json.Marshal(review)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+		}
+		out := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(respBody, &out); err != nil {
+			return nil, fmt.Errorf("decoding response: %w", err)
+		}
+		if out.Response == nil {
+			return nil, errors.New("AdmissionReview without response")
+		}
+		if out.Response.UID != review.Request.UID {
+			return nil, fmt.Errorf("response UID %q does not match request UID %q", out.Response.UID, review.Request.UID)
+		}
+		return out.Response, nil
+	}
+}
+
+// work is a request handed to a worker.
+type work struct {
+	index int
+	// scheduled is when the request was due to be sent. Latency is measured
+	// from it rather than from the actual send, so requests delayed by
+	// busy workers count the delay (coordinated omission).
+	scheduled time.Time
+}
+
+// Run sends the payloads round-robin at the configured rate and concurrency
+// until Requests have been sent or Duration has elapsed. With a rate, the
+// requests are scheduled at fixed intervals from the start of the run,
+// whether or not a worker is free to send them.
+func Run(ctx context.Context, o Options, payloads []admissionv1.AdmissionReview, admit AdmitFunc) Report {
+	if o.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Duration)
+		defer cancel()
+	}
+
+	queue := make(chan work)
+	go func() {
+		defer close(queue)
+		var interval time.Duration
+		if o.Rate > 0 {
+			interval = time.Duration(float64(time.Second) / o.Rate)
+		}
+		start := time.Now()
+		for i := 0; o.Requests <= 0 || i < o.Requests; i++ {
+			scheduled := time.Now()
+			if interval > 0 {
+				scheduled = start.Add(time.Duration(i) * interval)
+				if wait := time.Until(scheduled); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					case <-timer.C:
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case queue <- work{index: i, scheduled: scheduled}:
+			}
+		}
+	}()
+
+	results := make(chan result, o.Concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				review := payloads[item.index%len(payloads)]
+				req := *review.Request
+				req.UID = types.UID(fmt.Sprintf("loadgen-%d", item.index))
+				review.Request = &req
+
+				reqCtx := context.Background()
+				cancel := func() {}
+				if o.Timeout > 0 {
+					reqCtx, cancel = context.WithTimeout(reqCtx, o.Timeout)
+				}
+				resp, err := admit(reqCtx, review)
+				latency := time.Since(item.scheduled)
+				cancel()
+
+				r := result{Latency: latency, Err: err}
+				if resp != nil {
+					r.Allowed = resp.Allowed
+					r.Patched = len(resp.Patch) > 0
+				}
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	report := Report{}
+	var latencies []time.Duration
+	for r := range results {
+		report.Requests++
+		latencies = append(latencies, r.Latency)
+		switch {
+		case r.Err != nil:
+			report.Errors++
+			if report.FirstError == "" {
+				report.FirstError = r.Err.Error()
+			}
+		case !r.Allowed:
+			report.Denied++
+		case r.Patched:
+			report.Patched++
+		}
+	}
+	report.Elapsed = time.Since(start)
+	if report.Elapsed > 0 {
+		report.Throughput = float64(report.Requests) / report.Elapsed.Seconds()
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		percentile := func(p int) time.Duration { return latencies[(len(latencies)-1)*p/100] }
+		report.P50, report.P90, report.P99 = percentile(50), percentile(90), percentile(99)
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report
+}
+
+// PrintReport writes the report as a table.
+func PrintReport(w io.Writer, target string, r Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "target\t%s\n", target)
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "throughput\t%.1f req/s\n", r.Throughput)
+	fmt.Fprintf(tw, "errors\t%d (%.2f%%)\n", r.Errors, r.ErrorRate()*100)
+	fmt.Fprintf(tw, "denied\t%d\n", r.Denied)
+	fmt.Fprintf(tw, "patched\t%d\n", r.Patched)
+	fmt.Fprintf(tw, "latency p50\t%s\n", r.P50)
+	fmt.Fprintf(tw, "latency p90\t%s\n", r.P90)
+	fmt.Fprintf(tw, "latency p99\t%s\n", r.P99)
+	fmt.Fprintf(tw, "latency max\t%s\n", r.Max)
+	if r.FirstError != "" {
+		fmt.Fprintf(tw, "first error\t%s\n", r.FirstError)
+	}
+	return tw.Flush()
+}
+
+// Main implements the loadgen subcommand of program. inProcess handles the
+// reviews when --target is in-process, which is the default.
+func Main(program string, args []string, out io.Writer, inProcess AdmitFunc) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	o := Options{}
+	fs.StringVar(&o.Target, "target", TargetInProcess,
+		"Webhook URL (e.g. https://localhost:9443/mutate-v1-pod for the Hyper-V webhook or https://localhost:8443/mutate for the HPC webhook), "+
+			"or in-process to call the pod handler of "+program+" directly.")
+	fs.Float64Var(&o.Rate, "rate", 0, "Requests per second. 0 sends as fast as the workers allow.")
+	fs.IntVar(&o.Concurrency, "concurrency", 10, "Number of concurrent requests.")
+	fs.IntVar(&o.Requests, "requests", 1000, "Total requests to send. 0 runs until --duration elapses.")
+	fs.DurationVar(&o.Duration, "duration", 0, "Maximum run time. 0 means no limit.")
+	fs.DurationVar(&o.Timeout, "timeout", 10*time.Second, "Per-request timeout.")
+	payloadFile := fs.String("payloads", "",
+		"File with one AdmissionReview, recorded admission or pod per line. Defaults to synthesized e2e pods.")
+	caFile := fs.String("ca-file", "", "CA bundle used to verify the webhook's serving certificate.")
+	insecure := fs.Bool("insecure-skip-verify", false, "Do not verify the webhook's serving certificate.")
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON.")
+	maxErrorRate := fs.Float64("max-error-rate", -1, "Exit with an error if the error rate (0-1) exceeds this value.")
+	maxP99 := fs.Duration("max-p99", 0, "Exit with an error if the p99 latency exceeds this value.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s loadgen [flags]\n\n", program)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if o.Requests <= 0 && o.Duration <= 0 {
+		return fmt.Errorf("one of --requests or --duration is required")
+	}
+
+	payloads, err := LoadPayloads(*payloadFile)
+	if err != nil {
+		return err
+	}
+
+	admit := inProcess
+	if o.Target != TargetInProcess {
+		tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+		if *caFile != "" {
+			pem, err := os.ReadFile(*caFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in %s", *caFile)
+			}
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		transport.MaxIdleConnsPerHost = o.Concurrency
+		admit = HTTPAdmit(&http.Client{Transport: transport}, o.Target)
+	}
+
+	report := Run(context.Background(), o, payloads, admit)
+	if *jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else if err := PrintReport(out, o.Target, report); err != nil {
+		return err
+	}
+
+	if *maxErrorRate >= 0 && report.ErrorRate() > *maxErrorRate {
+		return fmt.Errorf("error rate %.4f exceeds --max-error-rate %.4f", report.ErrorRate(), *maxErrorRate)
+	}
+	if *maxP99 > 0 && report.P99 > *maxP99 {
+		return fmt.Errorf("p99 latency %s exceeds --max-p99 %s", report.P99, *maxP99)
+	}
+	return nil
+}
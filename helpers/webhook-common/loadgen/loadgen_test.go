@@ -0,0 +1,142 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// patchFirst patches the first synthesized pod and admits the others
+// unmodified.
+func patchFirst(_ context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	resp := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if bytes.Equal(review.Request.Object.Raw, []byte(SynthesizedPods[0])) {
+		resp.Patch = []byte(`[{"op":"add","path":"/metadata/annotations","value":{}}]`)
+	}
+	return resp, nil
+}
+
+func TestRun(t *testing.T) {
+	payloads, err := LoadPayloads("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report := Run(context.Background(), Options{Concurrency: 4, Requests: 50}, payloads, patchFirst)
+	if report.Requests != 50 {
+		t.Errorf("expected 50 requests, got %d", report.Requests)
+	}
+	if report.Errors != 0 || report.Denied != 0 {
+		t.Errorf("expected no errors or denials, got %+v", report)
+	}
+	if report.Patched != 10 {
+		t.Errorf("expected 10 patched requests, got %d", report.Patched)
+	}
+	if report.P50 > report.P99 || report.P99 > report.Max {
+		t.Errorf("percentiles out of order: %+v", report)
+	}
+}
+
+func TestRunRateAndDuration(t *testing.T) {
+	payloads, err := LoadPayloads("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report := Run(context.Background(), Options{Concurrency: 2, Rate: 100, Duration: 200 * time.Millisecond}, payloads, patchFirst)
+	if report.Requests < 5 || report.Requests > 25 {
+		t.Errorf("expected about 20 requests at 100 req/s for 200ms, got %d", report.Requests)
+	}
+}
+
+func TestRunMeasuresFromSchedule(t *testing.T) {
+	payloads, err := LoadPayloads("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// One worker taking 20ms per request falls behind a schedule of one
+	// request every 5ms. Each request waits for the previous ones, and that
+	// wait is part of its latency.
+	var mu sync.Mutex
+	slow := func(ctx context.Context, review admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		return patchFirst(ctx, review)
+	}
+
+	report := Run(context.Background(), Options{Concurrency: 1, Rate: 200, Requests: 10}, payloads, slow)
+	if report.Requests != 10 {
+		t.Fatalf("expected 10 requests, got %d", report.Requests)
+	}
+	// The last request is due after 45ms and completes after 200ms.
+	if report.Max < 120*time.Millisecond {
+		t.Errorf("expected the queueing delay to be included in the latency, got max %s", report.Max)
+	}
+}
+
+func TestMainCommand(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	payloadFile := filepath.Join(t.TempDir(), "payloads.jsonl")
+	review, err := json.Marshal(PodReview([]byte(SynthesizedPods[0])))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := string(review) + "\n\n" + SynthesizedPods[1] + "\n"
+	if err := os.WriteFile(payloadFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Main("webhook", []string{"--requests=10", "--payloads=" + payloadFile, "--json"}, &out, patchFirst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := Report{}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("expected a JSON report, got %q: %v", out.String(), err)
+	}
+	if report.Requests != 10 || report.Patched != 5 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	out.Reset()
+	err = Main("webhook", []string{"--target=" + failing.URL, "--requests=5", "--max-error-rate=0"}, &out, patchFirst)
+	if err == nil || !strings.Contains(err.Error(), "error rate") {
+		t.Errorf("expected the error rate check to fail, got %v", err)
+	}
+	for _, want := range []string{"throughput", "latency p99", "HTTP 503"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected report to contain %q:\n%s", want, out.String())
+		}
+	}
+}
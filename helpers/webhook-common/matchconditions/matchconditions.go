@@ -0,0 +1,308 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package matchconditions compiles and evaluates CEL expressions with the
+// semantics of the matchConditions of a MutatingWebhookConfiguration, so
+// webhooks can skip pods the same way the apiserver would.
+package matchconditions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	apiservercel "k8s.io/apiserver/pkg/cel"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/apiserver/pkg/cel/openapi"
+)
+
+const (
+	// MaxConditions is the limit the apiserver applies to the
+	// matchConditions of a webhook.
+	MaxConditions = 64
+	// CostLimit is the runtime cost limit of one expression, the per-call
+	// limit of CEL in the apiserver.
+	CostLimit = 1000000
+	// CostBudget is the runtime cost limit of all expressions evaluated for
+	// one request, the admission budget of the apiserver. An expression whose
+	// estimated worst-case cost exceeds it is rejected when it is compiled.
+	CostBudget = 10000000
+)
+
+// Condition mirrors admissionregistration/v1 MatchCondition: a pod is only
+// mutated if all expressions evaluate to true.
+type Condition struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// Compiled is a type-checked Condition.
+type Compiled struct {
+	Name       string
+	Expression string
+	// MaxCost is the estimated worst-case cost of an evaluation.
+	MaxCost uint64
+
+	program cel.Program
+}
+
+var (
+	podType       = openapi.SchemaDeclType(podSchema, false).MaybeAssignTypeName("kubernetes.Pod")
+	requestType   = plugincel.BuildRequestType()
+	namespaceType = plugincel.BuildNamespaceType()
+)
+
+// variableTypes are the declared types of the variables. object and oldObject
+// are pods; request and namespaceObject have the types the apiserver
+// declares for them.
+var variableTypes = map[string]*apiservercel.DeclType{
+	"object":          podType,
+	"oldObject":       podType,
+	"request":         requestType,
+	"namespaceObject": namespaceType,
+}
+
+// newEnv declares the typed variables of Kubernetes matchConditions, so field
+// access is checked when an expression is compiled.
+func newEnv() (*cel.Env, error) {
+	base, err := cel.NewEnv(ext.Strings(), ext.Sets(), ext.Lists())
+	if err != nil {
+		return nil, err
+	}
+	provider := apiservercel.NewDeclTypeProvider(podType, requestType, namespaceType)
+	opts, err := provider.EnvOptions(base.CELTypeProvider())
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range variableTypes {
+		opts = append(opts, cel.Variable(name, t.CelType()))
+	}
+	return base.Extend(opts...)
+}
+
+// Compile type-checks conditions. Each expression must evaluate to bool and
+// its estimated worst-case cost must be within CostBudget. The estimate
+// assumes objects of the maximum request size, so iterating over a list of a
+// pod usually exceeds CostLimit; that limit is only enforced when the
+// expression is evaluated.
+func Compile(conditions []Condition) ([]Compiled, error) {
+	if len(conditions) > MaxConditions {
+		return nil, fmt.Errorf("at most %d matchConditions are allowed, got %d", MaxConditions, len(conditions))
+	}
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	estimator := &library.CostEstimator{SizeEstimator: sizeEstimator{}}
+	seen := map[string]bool{}
+	compiled := make([]Compiled, 0, len(conditions))
+	for i, c := range conditions {
+		if c.Name == "" {
+			return nil, fmt.Errorf("matchCondition %d: name is required", i)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("matchCondition %s: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Expression == "" {
+			return nil, fmt.Errorf("matchCondition %s: expression is required", c.Name)
+		}
+
+		ast, issues := env.Compile(c.Expression)
+		if issues.Err() != nil {
+			return nil, fmt.Errorf("matchCondition %s: %w", c.Name, issues.Err())
+		}
+		if out := ast.OutputType(); !out.IsExactType(cel.BoolType) {
+			return nil, fmt.Errorf("matchCondition %s: expression must evaluate to bool, got %s", c.Name, out)
+		}
+		cost, err := env.EstimateCost(ast, estimator)
+		if err != nil {
+			return nil, fmt.Errorf("matchCondition %s: estimating cost: %w", c.Name, err)
+		}
+		if cost.Max > CostBudget {
+			return nil, fmt.Errorf("matchCondition %s: estimated cost %d exceeds the cost budget of %d", c.Name, cost.Max, CostBudget)
+		}
+		program, err := env.Program(ast,
+			cel.CostLimit(CostLimit),
+			cel.EvalOptions(cel.OptTrackCost),
+			cel.InterruptCheckFrequency(100),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("matchCondition %s: %w", c.Name, err)
+		}
+		compiled = append(compiled, Compiled{Name: c.Name, Expression: c.Expression, MaxCost: cost.Max, program: program})
+	}
+	return compiled, nil
+}
+
+// sizeEstimator bounds the size of the variables and their fields by their
+// declared types. Like for schemas without maxItems or maxLength in the
+// apiserver, the bounds are derived from the maximum request size.
+type sizeEstimator struct{}
+
+func (sizeEstimator) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	path := element.Path()
+	if len(path) == 0 {
+		return nil
+	}
+	t, ok := variableTypes[path[0]]
+	if !ok {
+		return nil
+	}
+	for _, name := range path[1:] {
+		switch name {
+		case "@items", "@values":
+			t = t.ElemType
+		case "@keys":
+			t = t.KeyType
+		default:
+			field, ok := t.Fields[name]
+			if !ok {
+				return nil
+			}
+			t = field.Type
+		}
+		if t == nil {
+			return nil
+		}
+	}
+	// Nothing in a request is larger than the request.
+	max := apiservercel.DefaultMaxRequestSizeBytes
+	if t.MaxElements > 0 && t.MaxElements < max {
+		max = t.MaxElements
+	}
+	return &checker.SizeEstimate{Min: 0, Max: uint64(max)}
+}
+
+// EstimateCallCost leaves the cost of functions to library.CostEstimator.
+func (sizeEstimator) EstimateCallCost(function, overloadID string, target *checker.AstNode, args []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// Eval returns the name of the first condition that is false for the
+// request, or "" if all are true. Like the apiserver, a false condition takes
+// precedence over errors of other conditions. namespace is only called by
+// expressions that use namespaceObject.
+func Eval(ctx context.Context, conditions []Compiled, req *admissionv1.AdmissionRequest, namespace func() (*corev1.Namespace, error)) (string, error) {
+	vars, err := variables(req, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	var firstErr error
+	var cost uint64
+	for _, c := range conditions {
+		val, details, err := c.program.ContextEval(ctx, vars)
+		if details != nil && details.ActualCost() != nil {
+			cost += *details.ActualCost()
+		}
+		if err == nil && cost > CostBudget {
+			err = fmt.Errorf("cost budget of %d exceeded", CostBudget)
+		}
+		if err == nil {
+			matched, ok := val.Value().(bool)
+			if !ok {
+				err = fmt.Errorf("expression evaluated to %s, not bool", val.Type().TypeName())
+			} else if !matched {
+				return c.Name, nil
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("matchCondition %s: %w", c.Name, err)
+		}
+	}
+	return "", firstErr
+}
+
+// variables returns the variables of an evaluation: the objects as
+// unstructured maps, the request without its objects, and namespaceObject as
+// a lazy lookup. Like in the apiserver, the declared types are only used to
+// check expressions, the values are not converted.
+func variables(req *admissionv1.AdmissionRequest, namespace func() (*corev1.Namespace, error)) (map[string]any, error) {
+	object, err := unstructuredRaw(req.Object.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding object: %w", err)
+	}
+	oldObject, err := unstructuredRaw(req.OldObject.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding oldObject: %w", err)
+	}
+	withoutObjects := req.DeepCopy()
+	withoutObjects.Object, withoutObjects.OldObject = runtime.RawExtension{}, runtime.RawExtension{}
+	b, err := json.Marshal(withoutObjects)
+	if err != nil {
+		return nil, err
+	}
+	request, err := unstructuredRaw(b)
+	if err != nil {
+		return nil, err
+	}
+	delete(request, "object")
+	delete(request, "oldObject")
+
+	return map[string]any{
+		"object":    orNull(object),
+		"oldObject": orNull(oldObject),
+		"request":   request,
+		"namespaceObject": func() ref.Val {
+			if req.Namespace == "" || namespace == nil {
+				return types.NullValue
+			}
+			ns, err := namespace()
+			if err != nil {
+				return types.NewErr("namespaceObject: %v", err)
+			}
+			// Like the apiserver, only expose the fields of the
+			// namespace type.
+			ns = plugincel.CreateNamespaceObject(ns)
+			u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ns)
+			if err != nil {
+				return types.NewErr("namespaceObject: %v", err)
+			}
+			return types.DefaultTypeAdapter.NativeToValue(u)
+		},
+	}, nil
+}
+
+// unstructuredRaw decodes a JSON object with integers as int64, as the
+// apiserver does. Empty input is null.
+func unstructuredRaw(raw []byte) (map[string]interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	out := map[string]interface{}{}
+	if err := utiljson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func orNull(m map[string]interface{}) any {
+	if m == nil {
+		return types.NullValue
+	}
+	return m
+}
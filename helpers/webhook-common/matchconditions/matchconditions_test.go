@@ -0,0 +1,213 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matchconditions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tooMany := make([]Condition, MaxConditions+1)
+	for i := range tooMany {
+		tooMany[i] = Condition{Name: fmt.Sprintf("c%d", i), Expression: "true"}
+	}
+
+	tests := []struct {
+		name       string
+		conditions []Condition
+		wantErr    string
+	}{
+		{
+			name:       "missing name",
+			conditions: []Condition{{Expression: "true"}},
+			wantErr:    "name is required",
+		},
+		{
+			name:       "duplicate name",
+			conditions: []Condition{{Name: "a", Expression: "true"}, {Name: "a", Expression: "true"}},
+			wantErr:    "duplicate name",
+		},
+		{
+			name:       "missing expression",
+			conditions: []Condition{{Name: "a"}},
+			wantErr:    "expression is required",
+		},
+		{
+			name:       "undeclared variable",
+			conditions: []Condition{{Name: "a", Expression: "params.enabled"}},
+			wantErr:    "undeclared reference",
+		},
+		{
+			name:       "unknown pod field",
+			conditions: []Condition{{Name: "a", Expression: "has(object.spec.nodeSelectr)"}},
+			wantErr:    "undefined field 'nodeSelectr'",
+		},
+		{
+			name:       "unknown request field",
+			conditions: []Condition{{Name: "a", Expression: "request.operaton == 'CREATE'"}},
+			wantErr:    "undefined field 'operaton'",
+		},
+		{
+			name:       "not bool",
+			conditions: []Condition{{Name: "a", Expression: "object.metadata.name"}},
+			wantErr:    "must evaluate to bool, got string",
+		},
+		{
+			name:       "dyn",
+			conditions: []Condition{{Name: "a", Expression: "object.spec.containers[0].livenessProbe.httpGet.port"}},
+			wantErr:    "must evaluate to bool, got dyn",
+		},
+		{
+			name:       "estimated cost",
+			conditions: []Condition{{Name: "a", Expression: "object.spec.containers.all(c, c.env.all(e, e.value != 'x'))"}},
+			wantErr:    "exceeds the cost budget",
+		},
+		{
+			name:       "too many",
+			conditions: tooMany,
+			wantErr:    "at most 64",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.conditions)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func podRequest(pod string) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:       "a",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: "e2e",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(pod)},
+	}
+}
+
+func TestEval(t *testing.T) {
+	conditions, err := Compile([]Condition{
+		{Name: "create-only", Expression: "request.operation == 'CREATE' && oldObject == null"},
+		{Name: "short-grace-period", Expression: "!has(object.spec.terminationGracePeriodSeconds) || object.spec.terminationGracePeriodSeconds <= 30"},
+		{Name: "no-windows-image", Expression: "!object.spec.containers.exists(c, c.image.startsWith('mcr.microsoft.com/windows'))"},
+		{Name: "not-privileged-namespace", Expression: "namespaceObject == null || !has(namespaceObject.metadata.labels) || namespaceObject.metadata.labels['pod-security.kubernetes.io/enforce'] != 'privileged'"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	namespace := func(labels map[string]string) func() (*corev1.Namespace, error) {
+		return func() (*corev1.Namespace, error) {
+			return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "e2e", Labels: labels}}, nil
+		}
+	}
+
+	tests := []struct {
+		name      string
+		pod       string
+		namespace func() (*corev1.Namespace, error)
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "all true",
+			pod:       `{"spec":{"terminationGracePeriodSeconds":30,"containers":[{"name":"c","image":"busybox"}]}}`,
+			namespace: namespace(nil),
+		},
+		{
+			name:      "integer field",
+			pod:       `{"spec":{"terminationGracePeriodSeconds":60,"containers":[]}}`,
+			namespace: namespace(nil),
+			want:      "short-grace-period",
+		},
+		{
+			name:      "list field",
+			pod:       `{"spec":{"containers":[{"name":"c","image":"busybox"},{"name":"w","image":"mcr.microsoft.com/windows/nanoserver"}]}}`,
+			namespace: namespace(nil),
+			want:      "no-windows-image",
+		},
+		{
+			name:      "namespace object",
+			pod:       `{"spec":{"containers":[]}}`,
+			namespace: namespace(map[string]string{"pod-security.kubernetes.io/enforce": "privileged"}),
+			want:      "not-privileged-namespace",
+		},
+		{
+			name: "namespace lookup error",
+			pod:  `{"spec":{"containers":[]}}`,
+			namespace: func() (*corev1.Namespace, error) {
+				return nil, fmt.Errorf("forbidden")
+			},
+			wantErr: "namespaceObject: forbidden",
+		},
+		{
+			name: "false wins over errors",
+			pod:  `{"spec":{"terminationGracePeriodSeconds":60,"containers":[]}}`,
+			namespace: func() (*corev1.Namespace, error) {
+				return nil, fmt.Errorf("forbidden")
+			},
+			want: "short-grace-period",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Eval(context.Background(), conditions, podRequest(tc.pod), tc.namespace)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCostLimit(t *testing.T) {
+	conditions, err := Compile([]Condition{{
+		Name:       "expensive",
+		Expression: "['v', 'w', 'y', 'z'].all(s, !object.metadata.name.contains(s))",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conditions[0].MaxCost <= CostLimit {
+		t.Errorf("expected an estimate above the cost limit, got %d", conditions[0].MaxCost)
+	}
+
+	req := podRequest(`{"metadata":{"name":"` + strings.Repeat("x", 3000000) + `"}}`)
+	_, err = Eval(context.Background(), conditions, req, nil)
+	if err == nil || !strings.Contains(err.Error(), "cost limit exceeded") {
+		t.Errorf("expected the cost limit to be exceeded, got %v", err)
+	}
+}
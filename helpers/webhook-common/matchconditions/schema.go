@@ -0,0 +1,116 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matchconditions
+
+import (
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// openAPIType is implemented by types with a custom JSON encoding, such as
+// metav1.Time, resource.Quantity and intstr.IntOrString.
+type openAPIType interface {
+	OpenAPISchemaType() []string
+	OpenAPISchemaFormat() string
+}
+
+// podSchema is the OpenAPI schema of a Pod, derived from its JSON encoding.
+// It only declares the types of object and oldObject.
+var podSchema = schemaOf(reflect.TypeOf(corev1.Pod{}), map[reflect.Type]bool{})
+
+// schemaOf returns the schema of the JSON encoding of t. Every value is
+// nullable, like the omitted fields of an unstructured object. A recursive
+// type is an object without fields below its first occurrence.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *spec.Schema {
+	if custom, ok := reflect.New(t).Interface().(openAPIType); ok {
+		s := &spec.Schema{}
+		s.Type = custom.OpenAPISchemaType()
+		// Evaluations see the unstructured object, so only int-or-string
+		// keeps its format; timestamps stay strings.
+		if format := custom.OpenAPISchemaFormat(); format == "int-or-string" {
+			s.Format = format
+		}
+		s.Nullable = true
+		return s
+	}
+
+	s := &spec.Schema{}
+	s.Nullable = true
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), seen)
+	case reflect.String:
+		s.Type = spec.StringOrArray{"string"}
+	case reflect.Bool:
+		s.Type = spec.StringOrArray{"boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = spec.StringOrArray{"integer"}
+	case reflect.Float32, reflect.Float64:
+		s.Type = spec.StringOrArray{"number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			s.Type = spec.StringOrArray{"string"}
+			break
+		}
+		s.Type = spec.StringOrArray{"array"}
+		s.Items = &spec.SchemaOrArray{Schema: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		s.Type = spec.StringOrArray{"object"}
+		s.AdditionalProperties = &spec.SchemaOrBool{Allows: true, Schema: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			s.Type = spec.StringOrArray{"object"}
+			break
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s.Type = spec.StringOrArray{"object"}
+		s.Properties = map[string]spec.Schema{}
+		addFields(s, t, seen)
+	}
+	return s
+}
+
+// addFields adds the JSON fields of struct t, including inlined ones, to s.
+func addFields(s *spec.Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && (f.Anonymous || strings.Contains(opts, "inline")) {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			addFields(s, ft, seen)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = *schemaOf(f.Type, seen)
+	}
+}
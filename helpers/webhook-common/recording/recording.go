@@ -0,0 +1,475 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recording writes admission requests and their outcome to rotated
+// JSON Lines files with secrets redacted, reads them back and replays them.
+// Both webhooks write the same format, so recordings of either can be
+// replayed or used as loadgen payloads.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kind marks a line written by a Recorder.
+const Kind = "AdmissionRecord"
+
+// Decisions written to Record.Decision.
+const (
+	DecisionMutated  = "mutated"
+	DecisionAdmitted = "admitted"
+	DecisionDenied   = "denied"
+)
+
+// redactedValue replaces redacted strings.
+const redactedValue = "REDACTED"
+
+// lastAppliedAnnotation holds a copy of the object, including its env values.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// DefaultRedactPattern matches env var names and annotation keys whose values
+// are redacted even when env values are recorded.
+const DefaultRedactPattern = `(?i)(password|passwd|secret|token|credential|api[-_]?key|private[-_]?key)`
+
+// Record is one line of a recording: the (redacted) request, the decision and
+// the response patch.
+type Record struct {
+	Kind    string                        `json:"kind"`
+	Time    time.Time                     `json:"time"`
+	Webhook string                        `json:"webhook"`
+	Request *admissionv1.AdmissionRequest `json:"request"`
+	// Allowed and Decision summarize the response: mutated, admitted or
+	// denied. Reason is the skip reason for admitted pods, if the webhook
+	// reports one.
+	Allowed  bool                  `json:"allowed"`
+	Decision string                `json:"decision"`
+	Reason   string                `json:"reason,omitempty"`
+	Patch    []jsonpatch.Operation `json:"patch,omitempty"`
+	Result   *metav1.Status        `json:"result,omitempty"`
+	// LatencyMicros is the time spent in the handler.
+	LatencyMicros int64 `json:"latencyMicros"`
+}
+
+// SetResponse fills in Allowed, Decision, Patch and Result from resp. patches
+// are used instead of the encoded resp.Patch when set.
+func (r *Record) SetResponse(resp *admissionv1.AdmissionResponse, patches []jsonpatch.Operation) error {
+	r.Allowed = resp.Allowed
+	r.Result = resp.Result
+	r.Patch = patches
+	if len(r.Patch) == 0 && len(resp.Patch) > 0 {
+		if err := json.Unmarshal(resp.Patch, &r.Patch); err != nil {
+			return fmt.Errorf("decoding response patch: %w", err)
+		}
+	}
+	switch {
+	case !resp.Allowed:
+		r.Decision = DecisionDenied
+	case len(r.Patch) > 0:
+		r.Decision = DecisionMutated
+	default:
+		r.Decision = DecisionAdmitted
+	}
+	return nil
+}
+
+// Redaction controls which values are removed from recordings.
+type Redaction struct {
+	// EnvValues redacts the value of every env var and every container
+	// command and args entry.
+	EnvValues bool
+	// Keys redacts env vars and annotations whose name matches, and the
+	// values of matching command line flags.
+	Keys *regexp.Regexp
+}
+
+// NewRedaction returns a Redaction of env values and of the keys matching
+// pattern, if set.
+func NewRedaction(envValues bool, pattern string) (Redaction, error) {
+	r := Redaction{EnvValues: envValues}
+	if pattern == "" {
+		return r, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return r, fmt.Errorf("invalid redaction pattern: %w", err)
+	}
+	r.Keys = re
+	return r, nil
+}
+
+func (r Redaction) matches(key string) bool {
+	return r.Keys != nil && r.Keys.MatchString(key)
+}
+
+// Object redacts a raw JSON object. Env vars and command lines are found
+// anywhere in the object, so pod templates and ephemeral containers are
+// covered as well.
+func (r Redaction) Object(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	var obj interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.walk(obj))
+}
+
+func (r Redaction) walk(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			switch {
+			case k == "env":
+				r.env(child)
+			case k == "annotations":
+				r.annotations(child)
+			case k == "command" || k == "args":
+				r.commandLine(child)
+			default:
+				v[k] = r.walk(child)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = r.walk(v[i])
+		}
+	}
+	return v
+}
+
+func (r Redaction) env(v interface{}) {
+	vars, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	for _, e := range vars {
+		envVar, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := envVar["name"].(string)
+		if _, ok := envVar["value"]; ok && (r.EnvValues || r.matches(name)) {
+			envVar["value"] = redactedValue
+		}
+	}
+}
+
+// commandLine redacts a container command or args list. A flag matching Keys
+// has its value redacted, whether it is passed as --flag=value or as the
+// next entry.
+func (r Redaction) commandLine(v interface{}) {
+	entries, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	redactNext := false
+	for i, e := range entries {
+		s, ok := e.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case r.EnvValues || redactNext:
+			entries[i] = redactedValue
+			redactNext = false
+		case r.matches(s):
+			if name, _, found := strings.Cut(s, "="); found {
+				entries[i] = name + "=" + redactedValue
+			} else {
+				redactNext = true
+			}
+		}
+	}
+}
+
+func (r Redaction) annotations(v interface{}) {
+	annotations, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, value := range annotations {
+		switch {
+		case r.matches(k):
+			annotations[k] = redactedValue
+		case k == lastAppliedAnnotation:
+			s, _ := value.(string)
+			redacted, err := r.Object([]byte(s))
+			if err != nil {
+				annotations[k] = redactedValue
+				continue
+			}
+			annotations[k] = string(redacted)
+		}
+	}
+}
+
+var envValuePath = regexp.MustCompile(`/env/\d+/value$`)
+
+// Patch returns a copy of ops with the values that set env values, command
+// lines or matching annotations redacted.
+func (r Redaction) Patch(ops []jsonpatch.Operation) ([]jsonpatch.Operation, error) {
+	// Copy the operations, their values are shared with the response.
+	raw, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	var out []jsonpatch.Operation
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	for i, op := range out {
+		if op.Value == nil {
+			continue
+		}
+		segments := strings.Split(op.Path, "/")
+		key := strings.NewReplacer("~1", "/", "~0", "~").Replace(segments[len(segments)-1])
+		parent := ""
+		if len(segments) > 1 {
+			parent = segments[len(segments)-2]
+		}
+		switch {
+		case envValuePath.MatchString(op.Path):
+			// The env var name is not part of the path, so any key
+			// pattern redacts it too.
+			if r.EnvValues || r.Keys != nil {
+				out[i].Value = redactedValue
+			}
+		case parent == "annotations" && r.matches(key):
+			out[i].Value = redactedValue
+		case parent == "annotations" && key == lastAppliedAnnotation:
+			wrapped := map[string]interface{}{key: op.Value}
+			r.annotations(wrapped)
+			out[i].Value = wrapped[key]
+		case parent == "env":
+			r.env([]interface{}{op.Value})
+		case key == "env":
+			r.env(op.Value)
+		case parent == "command" || parent == "args":
+			// A single entry, the flag it may be the value of is not
+			// part of the patch.
+			if s, ok := op.Value.(string); ok && (r.EnvValues || r.matches(s)) {
+				out[i].Value = redactedValue
+			}
+		case key == "command" || key == "args":
+			r.commandLine(op.Value)
+		case key == "annotations":
+			r.annotations(op.Value)
+		default:
+			out[i].Value = r.walk(op.Value)
+		}
+	}
+	return out, nil
+}
+
+// Request returns a copy of req with its objects redacted.
+func (r Redaction) Request(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionRequest, error) {
+	out := req.DeepCopy()
+	var err error
+	if out.Object.Raw, err = r.Object(out.Object.Raw); err != nil {
+		return nil, fmt.Errorf("redacting object: %w", err)
+	}
+	if out.OldObject.Raw, err = r.Object(out.OldObject.Raw); err != nil {
+		return nil, fmt.Errorf("redacting oldObject: %w", err)
+	}
+	out.Object.Object = nil
+	out.OldObject.Object = nil
+	return out, nil
+}
+
+// RotatingFile appends lines to a file and rotates it to <path>.1,
+// <path>.2, ... when it exceeds MaxBytes, keeping at most MaxFiles rotated
+// files.
+type RotatingFile struct {
+	Path     string
+	MaxBytes int64
+	MaxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// FilePath returns the file a recorder writes to. A directory gets an
+// admissions.jsonl file.
+func FilePath(path string) string {
+	if info, err := os.Stat(path); (err == nil && info.IsDir()) || strings.HasSuffix(path, string(os.PathSeparator)) {
+		return filepath.Join(path, "admissions.jsonl")
+	}
+	return path
+}
+
+// WriteLine appends line, rotating the file first if it would grow too large.
+func (w *RotatingFile) WriteLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f != nil && w.MaxBytes > 0 && w.size+int64(len(line))+1 > w.MaxBytes && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		if err := os.MkdirAll(filepath.Dir(w.Path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.f, w.size = f, info.Size()
+	}
+	n, err := w.f.Write(append(line, '\n'))
+	w.size += int64(n)
+	return err
+}
+
+func (w *RotatingFile) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+	if w.MaxFiles < 1 {
+		return os.Remove(w.Path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", w.Path, w.MaxFiles))
+	for i := w.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.Path, i), fmt.Sprintf("%s.%d", w.Path, i+1))
+	}
+	return os.Rename(w.Path, w.Path+".1")
+}
+
+// Close closes the current file.
+func (w *RotatingFile) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// Recorder writes the records of a webhook.
+type Recorder struct {
+	Webhook string
+	Redact  Redaction
+	Out     *RotatingFile
+}
+
+// Record redacts rec and appends it to the recording. Webhooks log the error
+// instead of failing the admission request.
+func (r *Recorder) Record(rec Record) error {
+	rec.Kind = Kind
+	rec.Webhook = r.Webhook
+	req, err := r.Redact.Request(rec.Request)
+	if err != nil {
+		return fmt.Errorf("redacting request: %w", err)
+	}
+	rec.Request = req
+	if rec.Patch, err = r.Redact.Patch(rec.Patch); err != nil {
+		return fmt.Errorf("redacting response patch: %w", err)
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.Out.WriteLine(line)
+}
+
+// Read reads the records in the given files. Directories are read
+// in rotation order, oldest first. Lines that are not admission records are
+// skipped.
+func Read(paths []string) ([]Record, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.jsonl*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(matches, func(i, j int) bool { return rotationIndex(matches[i]) > rotationIndex(matches[j]) })
+		files = append(files, matches...)
+	}
+
+	var records []Record
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			b := bytes.TrimSpace(scanner.Bytes())
+			if len(b) == 0 {
+				continue
+			}
+			rec := Record{}
+			if err := json.Unmarshal(b, &rec); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+			}
+			if rec.Kind != Kind || rec.Request == nil {
+				continue
+			}
+			records = append(records, rec)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// rotationIndex returns n for <name>.n and 0 for the current file.
+func rotationIndex(path string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return 0
+	}
+	return n
+}
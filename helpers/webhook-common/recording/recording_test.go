@@ -0,0 +1,286 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const podWithSecrets = `{"metadata":{"name":"p","namespace":"e2e","annotations":{` +
+	`"api-token":"abc","kubectl.kubernetes.io/last-applied-configuration":"{\"spec\":{\"containers\":[{\"env\":[{\"name\":\"A\",\"value\":\"leak\"}]}]}}"}},` +
+	`"spec":{"nodeSelector":{"kubernetes.io/os":"windows"},"containers":[{"name":"c","image":"busybox","env":[` +
+	`{"name":"PLAIN","value":"visible"},{"name":"DB_PASSWORD","value":"hunter2"},` +
+	`{"name":"FROM_SECRET","valueFrom":{"secretKeyRef":{"name":"s","key":"k"}}}]}]}}`
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		envValues bool
+		pattern   string
+		hidden    []string
+		visible   []string
+	}{
+		{
+			name:      "env values and secret-like keys",
+			envValues: true,
+			pattern:   DefaultRedactPattern,
+			hidden:    []string{"visible", "hunter2", "abc", "leak"},
+			visible:   []string{"PLAIN", "DB_PASSWORD", "secretKeyRef"},
+		},
+		{
+			name:    "secret-like keys only",
+			pattern: DefaultRedactPattern,
+			hidden:  []string{"hunter2", "abc"},
+			visible: []string{"visible", "leak"},
+		},
+		{
+			name:    "disabled",
+			visible: []string{"visible", "hunter2", "abc", "leak"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedaction(tt.envValues, tt.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			out, err := r.Object([]byte(podWithSecrets))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, s := range tt.hidden {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("expected %q to be redacted: %s", s, out)
+				}
+			}
+			for _, s := range tt.visible {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("expected %q to be kept: %s", s, out)
+				}
+			}
+		})
+	}
+
+	if _, err := NewRedaction(true, "("); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestRedactCommandLine(t *testing.T) {
+	const pod = `{"spec":{"initContainers":[{"name":"i","command":["setup.exe","--api-key=abc123"]}],` +
+		`"containers":[{"name":"c","command":["app.exe"],"args":["--db-password","hunter2","--port","8080"]}]}}`
+	tests := []struct {
+		name      string
+		envValues bool
+		pattern   string
+		hidden    []string
+		visible   []string
+	}{
+		{
+			name:      "all entries",
+			envValues: true,
+			pattern:   DefaultRedactPattern,
+			hidden:    []string{"setup.exe", "abc123", "hunter2", "8080"},
+		},
+		{
+			name:    "secret-like flags",
+			pattern: DefaultRedactPattern,
+			hidden:  []string{"abc123", "hunter2"},
+			visible: []string{"setup.exe", "--api-key=", "--db-password", "--port", "8080"},
+		},
+		{
+			name:    "disabled",
+			visible: []string{"abc123", "hunter2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedaction(tt.envValues, tt.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			out, err := r.Object([]byte(pod))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, s := range tt.hidden {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("expected %q to be redacted: %s", s, out)
+				}
+			}
+			for _, s := range tt.visible {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("expected %q to be kept: %s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactPatchCopiesValues(t *testing.T) {
+	r, err := NewRedaction(true, DefaultRedactPattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	env := []interface{}{map[string]interface{}{"name": "A", "value": "secret-value"}}
+	ops := []jsonpatch.Operation{
+		{Operation: "add", Path: "/spec/containers/0/env", Value: env},
+		{Operation: "replace", Path: "/spec/containers/0/env/1/value", Value: "other"},
+		{Operation: "add", Path: "/metadata/annotations/my-token", Value: "abc"},
+		{Operation: "add", Path: "/spec/containers/0/args", Value: []interface{}{"--token", "tok-value"}},
+		{Operation: "replace", Path: "/spec/containers/0/command/1", Value: "--client-secret=cs-value"},
+		{Operation: "add", Path: "/spec/runtimeClassName", Value: "runhcs-wcow-hypervisor"},
+	}
+
+	out, err := r.Patch(ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := json.Marshal(out)
+	for _, s := range []string{"secret-value", "other", "abc", "tok-value", "cs-value"} {
+		if bytes.Contains(b, []byte(s)) {
+			t.Errorf("expected %q to be redacted: %s", s, b)
+		}
+	}
+	if !bytes.Contains(b, []byte("runhcs-wcow-hypervisor")) {
+		t.Errorf("expected the runtime class to be kept: %s", b)
+	}
+	if env[0].(map[string]interface{})["value"] != "secret-value" {
+		t.Error("expected the response patch to be left unchanged")
+	}
+}
+
+func TestDiffOutcomesRedacted(t *testing.T) {
+	r, err := NewRedaction(false, DefaultRedactPattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	patch := []jsonpatch.Operation{
+		{Operation: "add", Path: "/spec/containers/0/args", Value: []interface{}{"--api-key=abc", "--port", "8080"}},
+		{Operation: "add", Path: "/spec/containers/0/env", Value: []interface{}{map[string]interface{}{"name": "TOKEN", "value": "abc"}}},
+	}
+	redacted, err := r.Patch(patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorded := Record{Decision: DecisionMutated, Patch: redacted}
+
+	if changes := DiffOutcomes(recorded, Record{Decision: DecisionMutated, Patch: patch}); len(changes) != 0 {
+		t.Errorf("expected the redacted values to match, got %v", changes)
+	}
+	changed := []jsonpatch.Operation{
+		{Operation: "add", Path: "/spec/containers/0/args", Value: []interface{}{"--api-key=abc", "--port", "9090"}},
+		patch[1],
+	}
+	if changes := DiffOutcomes(recorded, Record{Decision: DecisionMutated, Patch: changed}); len(changes) != 2 {
+		t.Errorf("expected the changed args to be reported, got %v", changes)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	w := &RotatingFile{Path: FilePath(dir), MaxBytes: 25, MaxFiles: 2}
+	defer w.Close()
+	for _, line := range []string{"line-1-xxxxxxx", "line-2-xxxxxxx", "line-3-xxxxxxx", "line-4-xxxxxxx"} {
+		if err := w.WriteLine([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := map[string]string{
+		"admissions.jsonl":   "line-4-xxxxxxx\n",
+		"admissions.jsonl.1": "line-3-xxxxxxx\n",
+		"admissions.jsonl.2": "line-2-xxxxxxx\n",
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(want) {
+		t.Errorf("expected %d files, got %d", len(want), len(entries))
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, b)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	redact, err := NewRedaction(true, DefaultRedactPattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := &RotatingFile{Path: FilePath(dir), MaxFiles: 1}
+	r := &Recorder{Webhook: "test", Redact: redact, Out: out}
+	req := &admissionv1.AdmissionRequest{UID: "a", Object: runtime.RawExtension{Raw: []byte(podWithSecrets)}}
+	rec := Record{Request: req}
+	if err := rec.SetResponse(&admissionv1.AdmissionResponse{Allowed: true}, []jsonpatch.Operation{{Operation: "add", Path: "/spec/runtimeClassName", Value: "x"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Record(rec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out.Close()
+
+	if !bytes.Contains(req.Object.Raw, []byte("hunter2")) {
+		t.Error("expected the admitted request to be left unchanged")
+	}
+	records, err := Read([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	got := records[0]
+	if got.Kind != Kind || got.Webhook != "test" || got.Decision != DecisionMutated {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if bytes.Contains(got.Request.Object.Raw, []byte("hunter2")) {
+		t.Errorf("expected the recorded request to be redacted: %s", got.Request.Object.Raw)
+	}
+
+	results, skipped, err := Replay("test", append(records, Record{Webhook: "other"}), func(req *admissionv1.AdmissionRequest) (Record, error) {
+		replayed := Record{Request: req}
+		return replayed, replayed.SetResponse(&admissionv1.AdmissionResponse{Allowed: true}, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if changed := PrintResults(&buf, results, skipped); changed != 1 {
+		t.Errorf("expected 1 changed record, got %d", changed)
+	}
+	for _, want := range []string{"decision: mutated -> admitted", `- add /spec/runtimeClassName "x"`, "0 unchanged, 1 changed, 1 skipped"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected output to contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// ReplayResult is a recorded admission replayed through the current handler.
+type ReplayResult struct {
+	Recorded Record
+	Replayed Record
+	Changes  []string
+}
+
+// Replay feeds the records of webhook through admit and diffs the outcomes.
+// admit returns the unredacted record of the replayed request. Records of
+// other webhooks are counted as skipped.
+func Replay(webhook string, records []Record, admit func(*admissionv1.AdmissionRequest) (Record, error)) (results []ReplayResult, skipped int, err error) {
+	for _, rec := range records {
+		if rec.Webhook != webhook {
+			skipped++
+			continue
+		}
+		replayed, err := admit(rec.Request)
+		if err != nil {
+			return nil, skipped, fmt.Errorf("replaying %s: %w", rec.Request.UID, err)
+		}
+		results = append(results, ReplayResult{Recorded: rec, Replayed: replayed, Changes: DiffOutcomes(rec, replayed)})
+	}
+	return results, skipped, nil
+}
+
+// DiffOutcomes describes how the replayed outcome differs from the recorded
+// one. Patch operations are compared regardless of order. The recorded patch
+// is redacted, so a redacted value matches any replayed value.
+func DiffOutcomes(recorded, replayed Record) []string {
+	var changes []string
+	if recorded.Decision != replayed.Decision {
+		changes = append(changes, fmt.Sprintf("decision: %s -> %s", recorded.Decision, replayed.Decision))
+	}
+	if recorded.Reason != replayed.Reason {
+		changes = append(changes, fmt.Sprintf("reason: %s -> %s", orDash(recorded.Reason), orDash(replayed.Reason)))
+	}
+	before, after := sortedOps(recorded.Patch), sortedOps(replayed.Patch)
+	matched := make([]bool, len(after))
+	for _, op := range before {
+		j := matchingOp(op, after, matched)
+		if j < 0 {
+			changes = append(changes, "- "+op.line)
+			continue
+		}
+		matched[j] = true
+	}
+	for j, op := range after {
+		if !matched[j] {
+			changes = append(changes, "+ "+op.line)
+		}
+	}
+	return changes
+}
+
+// patchOp is a patch operation with its value in generic JSON form.
+type patchOp struct {
+	op, path string
+	value    interface{}
+	line     string
+}
+
+func sortedOps(ops []jsonpatch.Operation) []patchOp {
+	out := make([]patchOp, 0, len(ops))
+	for _, op := range ops {
+		p := patchOp{op: op.Operation, path: op.Path, line: op.Operation + " " + op.Path}
+		if op.Value != nil {
+			value, err := json.Marshal(op.Value)
+			if err != nil {
+				value = []byte(fmt.Sprint(op.Value))
+			}
+			p.line += " " + string(value)
+			if err := json.Unmarshal(value, &p.value); err != nil {
+				p.value = string(value)
+			}
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].line < out[j].line })
+	return out
+}
+
+// matchingOp returns the index of the first unmatched op in ops that matches
+// recorded, or -1.
+func matchingOp(recorded patchOp, ops []patchOp, matched []bool) int {
+	for j, op := range ops {
+		if !matched[j] && op.op == recorded.op && op.path == recorded.path && redactedEqual(recorded.value, op.value) {
+			return j
+		}
+	}
+	return -1
+}
+
+// redactedEqual reports whether the replayed value equals the recorded one,
+// where the redacted parts of recorded match anything.
+func redactedEqual(recorded, replayed interface{}) bool {
+	switch r := recorded.(type) {
+	case string:
+		s, ok := replayed.(string)
+		if r == redactedValue || (ok && r == s) {
+			return true
+		}
+		name, value, found := strings.Cut(r, "=")
+		return ok && found && value == redactedValue && strings.HasPrefix(s, name+"=")
+	case map[string]interface{}:
+		m, ok := replayed.(map[string]interface{})
+		if !ok || len(m) != len(r) {
+			return false
+		}
+		for k, v := range r {
+			if other, ok := m[k]; !ok || !redactedEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := replayed.([]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range r {
+			if !redactedEqual(r[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(recorded, replayed)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// PrintResults prints the changed results and a summary, and returns the
+// number of changed results.
+func PrintResults(w io.Writer, results []ReplayResult, skipped int) (changed int) {
+	for _, r := range results {
+		if len(r.Changes) == 0 {
+			continue
+		}
+		changed++
+		req := r.Recorded.Request
+		fmt.Fprintf(w, "CHANGED %s %s %s/%s\n", req.UID, req.Operation, req.Namespace, orDash(req.Name))
+		for _, c := range r.Changes {
+			fmt.Fprintf(w, "  %s\n", c)
+		}
+	}
+	fmt.Fprintf(w, "replayed %d records: %d unchanged, %d changed, %d skipped (recorded by another webhook)\n",
+		len(results), len(results)-changed, changed, skipped)
+	return changed
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing exports the admission spans of the webhooks over OTLP.
+// Spans of a webhook are exported with its service name as service.name, by
+// the tracer windows.k8s.io/<service name>.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Options configures the export of admission spans.
+type Options struct {
+	// Endpoint is the host:port of an OTLP gRPC collector. Spans are not
+	// exported when empty.
+	Endpoint string
+	// Insecure disables TLS to the collector.
+	Insecure bool
+	// SamplingRatio is the fraction of requests without a trace context
+	// that are traced. Other requests follow the API server's decision.
+	SamplingRatio float64
+}
+
+// Setup installs the W3C trace context propagator and, if an endpoint is
+// set, a tracer provider exporting the spans of service to it. The returned
+// function flushes and stops the exporter.
+func Setup(ctx context.Context, service string, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if opts.SamplingRatio < 0 || opts.SamplingRatio > 1 {
+		return nil, fmt.Errorf("sampling ratio must be between 0 and 1, got %v", opts.SamplingRatio)
+	}
+
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", service)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SamplingRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of service from the global tracer provider.
+func Tracer(service string) trace.Tracer {
+	return otel.Tracer("windows.k8s.io/" + service)
+}
+
+// StartSpan starts a span of the tracer of service.
+func StartSpan(ctx context.Context, service, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer(service).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RequestAttributes identifies an admission request on a span.
+func RequestAttributes(req *admissionv1.AdmissionRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("admission.uid", string(req.UID)),
+		attribute.String("admission.operation", string(req.Operation)),
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.String("k8s.pod.name", req.Name),
+	}
+}